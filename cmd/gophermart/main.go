@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -13,11 +14,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Svirex/gofermart-loyality/internal/adapters/accrual"
 	"github.com/Svirex/gofermart-loyality/internal/adapters/api"
 	adapterspg "github.com/Svirex/gofermart-loyality/internal/adapters/postgres"
 	"github.com/Svirex/gofermart-loyality/internal/common"
 	"github.com/Svirex/gofermart-loyality/internal/config"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/services"
+	"github.com/go-chi/chi/middleware"
 	"github.com/golang-migrate/migrate"
 	"github.com/golang-migrate/migrate/database/postgres"
 	_ "github.com/golang-migrate/migrate/source/file"
@@ -25,6 +29,7 @@ import (
 	"github.com/jackc/pgx/v5/stdlib"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
@@ -81,13 +86,95 @@ func main() {
 	serverCtx, serverCancel := context.WithCancel(context.Background())
 
 	authRepo := adapterspg.NewAuthRepository(dbpool)
-	auth, err := services.NewAuthService(authRepo, 80, 8, 10, cfg.SecretKey)
+	sessionRepo := adapterspg.NewSessionRepository(dbpool, logger)
+	revocationRepo := adapterspg.NewRevocationRepository(dbpool, logger)
+	loginThrottleRepo := adapterspg.NewLoginThrottleRepository(dbpool, logger)
+	secondFactorRepo := adapterspg.NewSecondFactorRepository(dbpool, logger)
+	keyRing := services.NewHMACKeyRing(cfg.SecretKey)
+	if cfg.JWTKeys != "" {
+		keyRing, err = services.LoadKeyRing(cfg.JWTKeys, cfg.JWTSigningKeyID, cfg.JWTDefaultKeyID)
+		if err != nil {
+			logger.Fatalf("jwt key ring: %v", err)
+		}
+	}
+	var passwordBlocklist []string
+	if cfg.PasswordBlocklistFile != "" {
+		passwordBlocklist, err = services.LoadPasswordBlocklist(cfg.PasswordBlocklistFile)
+		if err != nil {
+			logger.Fatalf("password blocklist: %v", err)
+		}
+	}
+	var passwordHasher services.PasswordHasher
+	switch cfg.PasswordHasher {
+	case "bcrypt":
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			logger.Fatalf("bcrypt cost must be in [%d, %d]: %d", bcrypt.MinCost, bcrypt.MaxCost, cfg.BcryptCost)
+		}
+		passwordHasher = services.BcryptHasher{Cost: cfg.BcryptCost}
+	case "argon2id":
+		argon2Hasher := services.Argon2idHasher{
+			Time:    cfg.Argon2Time,
+			Memory:  cfg.Argon2MemoryKiB,
+			Threads: cfg.Argon2Threads,
+			KeyLen:  32,
+			SaltLen: 16,
+		}
+		if err = argon2Hasher.Validate(); err != nil {
+			logger.Fatalf("password hasher: %v", err)
+		}
+		passwordHasher = argon2Hasher
+	default:
+		logger.Fatalf("unknown password hasher: %s", cfg.PasswordHasher)
+	}
+	auth, err := services.NewAuthService(authRepo, sessionRepo, revocationRepo, loginThrottleRepo, secondFactorRepo, services.AuthConfig{
+		MinPasswordEntropyBits: cfg.PasswordMinEntropyBits,
+		MinPasswordLength:      cfg.PasswordMinLength,
+		PasswordBlocklist:      passwordBlocklist,
+		PasswordHasher:         passwordHasher,
+		KeyRing:                keyRing,
+		AccessTokenTTL:         cfg.AccessTokenTTL,
+		RefreshTokenTTL:        cfg.RefreshTokenTTL,
+		RevocationCacheTTL:     cfg.RevocationCacheTTL,
+		TOTPIssuer:             cfg.TOTPIssuer,
+		Logger:                 logger,
+		LoginThrottle: services.LoginThrottleConfig{
+			LoginFreeAttempts: cfg.LoginFreeAttempts,
+			IPFreeAttempts:    cfg.LoginIPFreeAttempts,
+			BaseLockout:       cfg.LoginLockoutBase,
+			MaxLockout:        cfg.LoginLockoutMax,
+			Window:            cfg.LoginFailureWindow,
+		},
+	})
 	if err != nil {
 		logger.Fatalf("auth service create: ", err)
 	}
 
 	ordersRepo := adapterspg.NewOrdersRepository(dbpool, logger)
-	orders, err := services.NewOrderService(dbpool, ordersRepo, logger, 20, cfg.AccrualSystemAddress, 100*time.Millisecond, 20, 2*time.Second)
+	accrualRepo := adapterspg.NewAccrualRepository(dbpool, logger)
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Fatalf("get hostname: %v", err)
+	}
+	accrualLimiter := accrual.NewLimiter(cfg.AccrualRateLimit, 1)
+	accrual.PublishMetrics("accrual_limiter", accrualLimiter)
+	accrualHTTPClient := accrual.NewHTTPClient(cfg.AccrualRequestTimeout, cfg.AccrualWorkers)
+	accrualClient := accrual.NewClient(cfg.AccrualSystemAddress, accrualHTTPClient, accrualLimiter)
+	orders, err := services.NewOrderService(ordersRepo, accrualRepo, accrualClient, logger, services.CheckAccrualConfig{
+		InstanceID:    fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		Workers:       cfg.AccrualWorkers,
+		PollInterval:  2 * time.Second,
+		BatchSize:     20,
+		LeaseDuration: 30 * time.Second,
+		Retry: services.RetryPolicy{
+			BaseDelay:   cfg.AccrualRetryBaseDelay,
+			MaxDelay:    cfg.AccrualRetryMaxDelay,
+			Multiplier:  2,
+			Jitter:      0.2,
+			MaxAttempts: cfg.AccrualRetryMaxAttempts,
+			MaxAge:      cfg.AccrualRetryMaxAge,
+		},
+		CallbackWindow: cfg.AccrualCallbackWindow,
+	})
 	if err != nil {
 		logger.Fatalf("orders service create: ", err)
 	}
@@ -97,13 +184,34 @@ func main() {
 	balance := services.NewBalanceService(balanceRepo)
 
 	withdrawRepo := adapterspg.NewWithdrawRepository(dbpool, logger)
-	withdraw := services.NewWithdrawService(withdrawRepo)
+	secondFactorThreshold, err := domain.NewMoneyFromFloat(cfg.WithdrawSecondFactorThreshold)
+	if err != nil {
+		logger.Fatalf("withdraw second factor threshold: %v", err)
+	}
+	withdraw := services.NewWithdrawService(withdrawRepo, auth, cfg.WithdrawCancelWindow, secondFactorThreshold)
 
-	api := api.NewAPI(auth, orders, balance, withdraw, logger)
+	admin := services.NewAdminService(adapterspg.NewAdminRepository(dbpool, logger), adapterspg.NewOutboxRepository(dbpool, logger), auth)
 
+	sameSite, err := api.ParseSameSite(cfg.CookieSameSite)
+	if err != nil {
+		logger.Fatalf("cookie same site: %v", err)
+	}
+	if sameSite == http.SameSiteNoneMode && !cfg.CookieSecure {
+		logger.Fatalf("cookie same site none requires secure cookies")
+	}
+	api := api.NewAPI(auth, orders, balance, withdraw, admin, logger, cfg.AdminToken, cfg.AccrualWebhookSecret, api.CookieConfig{
+		Domain:   cfg.CookieDomain,
+		Secure:   cfg.CookieSecure,
+		SameSite: sameSite,
+	})
+
+	var handler http.Handler = api.Routes()
+	if cfg.TrustProxyHeaders {
+		handler = middleware.RealIP(handler)
+	}
 	server := &http.Server{
 		Addr:        cfg.RunAddress,
-		Handler:     api.Routes(),
+		Handler:     handler,
 		BaseContext: func(net.Listener) context.Context { return serverCtx },
 	}
 
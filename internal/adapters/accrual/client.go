@@ -0,0 +1,105 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+// defaultThrottlePause - пауза после 429, если система расчёта не сообщила ни Retry-After, ни лимит
+const defaultThrottlePause = time.Second
+
+type Client struct {
+	addr    string
+	http    *http.Client
+	limiter *Limiter
+}
+
+var _ ports.AccrualClient = (*Client)(nil)
+
+func NewClient(addr string, httpClient *http.Client, limiter *Limiter) *Client {
+	return &Client{
+		addr:    addr,
+		http:    httpClient,
+		limiter: limiter,
+	}
+}
+
+func (c *Client) Limiter() *Limiter {
+	return c.limiter
+}
+
+// GetOrderAccrual запрашивает /api/orders/{number}, дожидаясь токена лимитера.
+// Таймаут http-клиента отсчитывается от начала запроса, ожидание токена в него не входит.
+// На 429 подстраивает лимитер под ответ системы расчёта.
+func (c *Client) GetOrderAccrual(ctx context.Context, orderNum string) (*domain.AccrualResponse, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("accrual client, wait limiter: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+"/api/orders/"+url.PathEscape(orderNum), http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("accrual client, new request: %w", err)
+	}
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("accrual client, do request: %w", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("accrual client, read body: %w", err)
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		ar := &domain.AccrualResponse{}
+		if err := json.Unmarshal(body, ar); err != nil {
+			return nil, fmt.Errorf("accrual client, unmarshal body: %w, body: %v", err, string(body))
+		}
+		ar.Raw = body
+		return ar, nil
+	case http.StatusNoContent:
+		return nil, ports.ErrAccrualNotRegistered
+	case http.StatusTooManyRequests:
+		pause := c.throttle(response.Header.Get("Retry-After"), body)
+		return nil, &ports.AccrualRateLimitError{RetryAfter: pause, Message: string(body)}
+	default:
+		return nil, fmt.Errorf("accrual client, unexpected status %d: %s", response.StatusCode, string(body))
+	}
+}
+
+// throttle приостанавливает лимитер и возвращает паузу до следующего запроса
+func (c *Client) throttle(retryAfter string, body []byte) time.Duration {
+	pause := defaultThrottlePause
+	perMinute, ok := ParseRateLimit(body)
+	if ok {
+		c.limiter.SetPerMinute(perMinute)
+		pause = time.Minute / time.Duration(perMinute)
+	}
+	if d, ok := ParseRetryAfter(retryAfter, time.Now()); ok {
+		pause = d
+	}
+	c.limiter.Throttle(pause)
+	return pause
+}
+
+// NewHTTPClient создаёт отдельный от http.DefaultClient клиент с собственным пулом соединений,
+// чтобы медленные ответы системы расчёта не занимали соединения остального приложения
+func NewHTTPClient(timeout time.Duration, maxConns int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxConns
+	transport.MaxIdleConnsPerHost = maxConns
+	transport.MaxConnsPerHost = maxConns
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.ResponseHeaderTimeout = timeout
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
@@ -0,0 +1,63 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+// FakeClient - система расчёта в памяти для тестов.
+// Заказ, для которого ничего не задано, считается незарегистрированным.
+type FakeClient struct {
+	mu        sync.Mutex
+	responses map[string]*domain.AccrualResponse
+	errors    map[string]error
+	calls     map[string]int
+}
+
+var _ ports.AccrualClient = (*FakeClient)(nil)
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		responses: make(map[string]*domain.AccrualResponse),
+		errors:    make(map[string]error),
+		calls:     make(map[string]int),
+	}
+}
+
+func (c *FakeClient) Set(ar *domain.AccrualResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.errors, ar.OrderNum)
+	c.responses[ar.OrderNum] = ar
+}
+
+func (c *FakeClient) SetError(orderNum string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.responses, orderNum)
+	c.errors[orderNum] = err
+}
+
+func (c *FakeClient) Calls(orderNum string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[orderNum]
+}
+
+func (c *FakeClient) GetOrderAccrual(ctx context.Context, orderNum string) (*domain.AccrualResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[orderNum]++
+	if err, ok := c.errors[orderNum]; ok {
+		return nil, err
+	}
+	ar, ok := c.responses[orderNum]
+	if !ok {
+		return nil, ports.ErrAccrualNotRegistered
+	}
+	copied := *ar
+	return &copied, nil
+}
@@ -0,0 +1,138 @@
+package accrual
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Limiter - token bucket, общий для всех запросов к системе расчёта.
+// Скорость меняется на ходу по ответам 429, а Retry-After приостанавливает выдачу токенов целиком.
+type Limiter struct {
+	mu          sync.Mutex
+	perSecond   float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	throttled   int64
+	waiting     int64
+}
+
+type LimiterStats struct {
+	PerMinute   float64   `json:"per_minute"`
+	Burst       float64   `json:"burst"`
+	Tokens      float64   `json:"tokens"`
+	PausedUntil time.Time `json:"paused_until"`
+	Throttled   int64     `json:"throttled"`
+	Waiting     int64     `json:"waiting"`
+}
+
+func NewLimiter(perMinute int, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		perSecond: float64(perMinute) / 60,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// Wait блокируется, пока не будет выдан токен, или до отмены ctx
+func (l *Limiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	delay := l.reserve(time.Now())
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// reserve забирает токен, даже если его ещё нет, и возвращает, сколько нужно подождать.
+// Вызывается под mu.
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.refill(now)
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		if l.perSecond <= 0 {
+			// скорость не ограничена
+			l.tokens = 0
+		} else {
+			delay = time.Duration(-l.tokens / l.perSecond * float64(time.Second))
+		}
+	}
+	if pause := l.pausedUntil.Sub(now); pause > delay {
+		delay = pause
+	}
+	return delay
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.perSecond
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+	}
+}
+
+func (l *Limiter) SetPerMinute(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.perSecond = float64(perMinute) / 60
+}
+
+// Throttle вызывается на каждый ответ 429 и приостанавливает выдачу токенов на pause
+func (l *Limiter) Throttle(pause time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.throttled++
+	until := time.Now().Add(pause)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	return LimiterStats{
+		PerMinute:   l.perSecond * 60,
+		Burst:       l.burst,
+		Tokens:      l.tokens,
+		PausedUntil: l.pausedUntil,
+		Throttled:   l.throttled,
+		Waiting:     l.waiting,
+	}
+}
+
+// PublishMetrics публикует состояние лимитера в expvar под именем name
+func PublishMetrics(name string, l *Limiter) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return l.Stats()
+	}))
+}
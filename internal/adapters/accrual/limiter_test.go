@@ -0,0 +1,106 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{value: "60", expected: time.Minute, ok: true},
+		{value: " 5 ", expected: 5 * time.Second, ok: true},
+		{value: "Mon, 01 Apr 2024 12:00:30 GMT", expected: 30 * time.Second, ok: true},
+		{value: "Mon, 01 Apr 2024 11:00:00 GMT", expected: 0, ok: true},
+		{value: "", ok: false},
+		{value: "-1", ok: false},
+		{value: "soon", ok: false},
+	}
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			d, ok := ParseRetryAfter(c.value, now)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.expected, d)
+		})
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	n, ok := ParseRateLimit([]byte("No more than 2 requests per minute allowed"))
+	require.True(t, ok)
+	require.Equal(t, 2, n)
+
+	_, ok = ParseRateLimit([]byte("Too many requests"))
+	require.False(t, ok)
+}
+
+func TestLimiterReserve(t *testing.T) {
+	l := NewLimiter(60, 2)
+	now := l.last
+
+	require.Equal(t, time.Duration(0), l.reserve(now))
+	require.Equal(t, time.Duration(0), l.reserve(now))
+	require.Equal(t, time.Second, l.reserve(now))
+	require.Equal(t, 2*time.Second, l.reserve(now))
+
+	// за 3 секунды накопилось 3 токена, два из них уже выданы в долг
+	require.Equal(t, time.Duration(0), l.reserve(now.Add(3*time.Second)))
+
+	l.pausedUntil = now.Add(10 * time.Second)
+	require.Equal(t, 7*time.Second, l.reserve(now.Add(3*time.Second)))
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := NewLimiter(0, 1)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+}
+
+func TestClientThrottle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("No more than 2 requests per minute allowed"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, server.Client(), NewLimiter(600, 1))
+
+	_, err := client.GetOrderAccrual(context.Background(), "59")
+	require.ErrorIs(t, err, ports.ErrAccrualRateLimited)
+	var rateLimitErr *ports.AccrualRateLimitError
+	require.ErrorAs(t, err, &rateLimitErr)
+	require.Equal(t, 30*time.Second, rateLimitErr.RetryAfter)
+
+	stats := client.Limiter().Stats()
+	require.Equal(t, float64(2), stats.PerMinute)
+	require.Equal(t, int64(1), stats.Throttled)
+	require.True(t, stats.PausedUntil.After(time.Now().Add(29*time.Second)))
+}
+
+func TestClientTimeoutExcludesLimiterWait(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"order": "59", "status": "PROCESSING"}`))
+	}))
+	defer server.Close()
+
+	limiter := NewLimiter(600, 1)
+	limiter.Throttle(200 * time.Millisecond)
+	client := NewClient(server.URL, NewHTTPClient(100*time.Millisecond, 1), limiter)
+
+	ar, err := client.GetOrderAccrual(context.Background(), "59")
+	require.NoError(t, err)
+	require.Equal(t, domain.AccrualProcessing, ar.Status)
+}
@@ -0,0 +1,128 @@
+package accrual
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+// Record - один ответ системы расчёта, одна строка JSON в записи
+type Record struct {
+	OrderNum string                  `json:"order"`
+	Response *domain.AccrualResponse `json:"response,omitempty"`
+	Error    string                  `json:"error,omitempty"`
+}
+
+// RecordingClient проксирует запросы в другой клиент и пишет ответы в w,
+// запись потом проигрывается через ReplayClient
+type RecordingClient struct {
+	mu     sync.Mutex
+	client ports.AccrualClient
+	w      io.Writer
+}
+
+var _ ports.AccrualClient = (*RecordingClient)(nil)
+
+func NewRecordingClient(client ports.AccrualClient, w io.Writer) *RecordingClient {
+	return &RecordingClient{
+		client: client,
+		w:      w,
+	}
+}
+
+func (c *RecordingClient) GetOrderAccrual(ctx context.Context, orderNum string) (*domain.AccrualResponse, error) {
+	ar, err := c.client.GetOrderAccrual(ctx, orderNum)
+	record := Record{
+		OrderNum: orderNum,
+		Response: ar,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	line, merr := json.Marshal(record)
+	if merr != nil {
+		return nil, fmt.Errorf("recording client, marshal record: %w", merr)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, werr := c.w.Write(append(line, '\n')); werr != nil {
+		return nil, fmt.Errorf("recording client, write record: %w", werr)
+	}
+	return ar, err
+}
+
+// ReplayClient отдаёт записанные ответы по каждому заказу в том же порядке,
+// после последней записи повторяет её
+type ReplayClient struct {
+	mu      sync.Mutex
+	records map[string][]Record
+	played  map[string]int
+}
+
+var _ ports.AccrualClient = (*ReplayClient)(nil)
+
+func NewReplayClient(r io.Reader) (*ReplayClient, error) {
+	c := &ReplayClient{
+		records: make(map[string][]Record),
+		played:  make(map[string]int),
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("new replay client, unmarshal record: %w", err)
+		}
+		c.records[record.OrderNum] = append(c.records[record.OrderNum], record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("new replay client, read records: %w", err)
+	}
+	return c, nil
+}
+
+func (c *ReplayClient) GetOrderAccrual(ctx context.Context, orderNum string) (*domain.AccrualResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	records := c.records[orderNum]
+	if len(records) == 0 {
+		return nil, ports.ErrAccrualNotRegistered
+	}
+	i := c.played[orderNum]
+	if i >= len(records) {
+		i = len(records) - 1
+	}
+	c.played[orderNum]++
+	record := records[i]
+	if record.Error != "" {
+		return nil, replayError(record.Error)
+	}
+	if record.Response == nil {
+		return nil, fmt.Errorf("replay client, order %s, record without response and error", orderNum)
+	}
+	copied := *record.Response
+	return &copied, nil
+}
+
+// replayError восстанавливает ошибки из ports, чтобы errors.Is работал и на проигранных ответах
+func replayError(message string) error {
+	for _, err := range []error{ports.ErrAccrualNotRegistered, ports.ErrAccrualRateLimited} {
+		if message == err.Error() {
+			return err
+		}
+		if strings.HasPrefix(message, err.Error()+": ") {
+			return fmt.Errorf("%w: %s", err, strings.TrimPrefix(message, err.Error()+": "))
+		}
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,58 @@
+package accrual
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	fake := NewFakeClient()
+	buf := &bytes.Buffer{}
+	recorder := NewRecordingClient(fake, buf)
+
+	_, err := recorder.GetOrderAccrual(context.Background(), "3511871356")
+	require.ErrorIs(t, err, ports.ErrAccrualNotRegistered)
+
+	fake.Set(&domain.AccrualResponse{OrderNum: "3511871356", Status: domain.AccrualProcessing})
+	_, err = recorder.GetOrderAccrual(context.Background(), "3511871356")
+	require.NoError(t, err)
+
+	fake.Set(&domain.AccrualResponse{OrderNum: "3511871356", Status: domain.AccrualProcessed, Accrual: 72998})
+	_, err = recorder.GetOrderAccrual(context.Background(), "3511871356")
+	require.NoError(t, err)
+
+	replay, err := NewReplayClient(buf)
+	require.NoError(t, err)
+
+	_, err = replay.GetOrderAccrual(context.Background(), "3511871356")
+	require.ErrorIs(t, err, ports.ErrAccrualNotRegistered)
+
+	ar, err := replay.GetOrderAccrual(context.Background(), "3511871356")
+	require.NoError(t, err)
+	require.Equal(t, domain.AccrualProcessing, ar.Status)
+
+	for i := 0; i < 2; i++ {
+		ar, err = replay.GetOrderAccrual(context.Background(), "3511871356")
+		require.NoError(t, err)
+		require.Equal(t, domain.AccrualProcessed, ar.Status)
+		require.Equal(t, domain.Money(72998), ar.Accrual)
+	}
+
+	_, err = replay.GetOrderAccrual(context.Background(), "unknown")
+	require.ErrorIs(t, err, ports.ErrAccrualNotRegistered)
+}
+
+func TestReplayRecordWithoutResponse(t *testing.T) {
+	replay, err := NewReplayClient(strings.NewReader(`{"order": "3511871356"}` + "\n"))
+	require.NoError(t, err)
+
+	ar, err := replay.GetOrderAccrual(context.Background(), "3511871356")
+	require.Error(t, err)
+	require.Nil(t, ar)
+}
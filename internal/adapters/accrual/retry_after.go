@@ -0,0 +1,46 @@
+package accrual
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRetryAfter разбирает заголовок Retry-After в обеих формах: число секунд или HTTP-дата
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+var perMinuteRegexp = regexp.MustCompile(`No more than (\d+) requests per minute`)
+
+// ParseRateLimit достаёт N из тела ответа 429 "No more than N requests per minute allowed"
+func ParseRateLimit(body []byte) (int, bool) {
+	match := perMinuteRegexp.FindSubmatch(body)
+	if match == nil {
+		return 0, false
+	}
+	perMinute, err := strconv.Atoi(string(match[1]))
+	if err != nil || perMinute <= 0 {
+		return 0, false
+	}
+	return perMinute, true
+}
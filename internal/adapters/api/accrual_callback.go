@@ -0,0 +1,90 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+const maxCallbackBodySize = 1 << 16
+
+// maxCallbackSkew - насколько время подписи callback может расходиться с нашим,
+// более старый перехваченный callback не удастся прислать повторно
+const maxCallbackSkew = 5 * time.Minute
+
+// AccrualCallback принимает от системы расчёта тот же ответ, что отдаёт GET /api/orders/{number}.
+// Время отправки в unix-секундах передаётся в заголовке X-Timestamp, строка "<timestamp>.<тело>"
+// подписывается HMAC-SHA256 общим секретом, подпись в hex передаётся в заголовке X-Signature.
+// Если секрет не задан, callback выключен.
+func (api *API) AccrualCallback(response http.ResponseWriter, request *http.Request) {
+	if api.accrualWebhookSecret == "" {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(response, request.Body, maxCallbackBodySize))
+	if err != nil {
+		api.logger.Debugf("api accrual callback, read body: %v", err)
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	timestamp := request.Header.Get("X-Timestamp")
+	if !freshTimestamp(timestamp, time.Now()) ||
+		!validSignature(api.accrualWebhookSecret, timestamp, body, request.Header.Get("X-Signature")) {
+		response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	ar := &domain.AccrualResponse{}
+	if err = json.Unmarshal(body, ar); err != nil || ar.OrderNum == "" {
+		api.logger.Debugf("api accrual callback, unmarshal: %v", err)
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ar.Raw = body
+	err = api.ordersService.ApplyAccrual(request.Context(), ar)
+	if err != nil {
+		// повтор того же callback ничего не исправит
+		if errors.Is(err, ports.ErrOrderNotFound) {
+			api.logger.Debugf("api accrual callback, service response: %v", err)
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, ports.ErrIllegalTransition) {
+			api.logger.Infof("api accrual callback, service response: %v", err)
+			response.WriteHeader(http.StatusConflict)
+			return
+		}
+		api.logger.Errorf("api accrual callback, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusOK)
+}
+
+func freshTimestamp(timestamp string, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	return skew <= maxCallbackSkew && skew >= -maxCallbackSkew
+}
+
+func validSignature(secret string, timestamp string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
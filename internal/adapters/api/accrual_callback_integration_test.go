@@ -0,0 +1,142 @@
+//go:build integration || integration_api
+// +build integration integration_api
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/stretchr/testify/require"
+)
+
+// signCallback подписывает тело как система расчёта и ставит заголовки на запрос
+func signCallback(req *http.Request, at time.Time, body string) {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("webhook_secret"))
+	mac.Write([]byte(timestamp + "." + body))
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestAccrualCallbackInvalidSignature(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	body := `{"order":"4561261212345467","status":"PROCESSED","accrual":500}`
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/api/internal/accrual/callback", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("X-Signature", hex.EncodeToString([]byte("wrong")))
+
+	resp, err := testServer.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAccrualCallbackStaleTimestamp(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	body := `{"order":"4561261212345467","status":"PROCESSED","accrual":500}`
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/api/internal/accrual/callback", strings.NewReader(body))
+	require.NoError(t, err)
+	signCallback(req, time.Now().Add(-time.Hour), body)
+
+	resp, err := testServer.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAccrualCallbackProcessed(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/api/user/orders", strings.NewReader("4561261212345467"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	body := `{"order":"4561261212345467","status":"PROCESSED","accrual":500}`
+	req, err = http.NewRequest(http.MethodPost, testServer.URL+"/api/internal/accrual/callback", strings.NewReader(body))
+	require.NoError(t, err)
+	signCallback(req, time.Now(), body)
+	resp, err = testServer.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(testServer.URL + "/api/user/orders")
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+
+	orders := make([]domain.Order, 0)
+	require.NoError(t, json.Unmarshal(data, &orders))
+	require.Len(t, orders, 1)
+	require.Equal(t, domain.Processed, orders[0].Status)
+
+	// PROCESSED конечный статус, запоздавший PROCESSING отклоняется
+	body = `{"order":"4561261212345467","status":"PROCESSING"}`
+	req, err = http.NewRequest(http.MethodPost, testServer.URL+"/api/internal/accrual/callback", strings.NewReader(body))
+	require.NoError(t, err)
+	signCallback(req, time.Now(), body)
+	resp, err = testServer.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, err = client.Get(testServer.URL + "/api/user/orders/4561261212345467")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var order domain.Order
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&order))
+	resp.Body.Close()
+	require.Len(t, order.History, 2)
+	require.Equal(t, domain.SourceUser, order.History[0].Source)
+	require.Equal(t, domain.Processed, order.History[1].Status)
+	require.Equal(t, domain.SourceWebhook, order.History[1].Source)
+	// ответ системы расчёта видят только сотрудники
+	require.Empty(t, order.History[1].RawResponse)
+
+	resp = adminRequest(t, http.DefaultClient, http.MethodGet, testServer.URL+"/api/admin/users?q=test", "", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var users []domain.UserInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
+	resp.Body.Close()
+	require.Len(t, users, 1)
+	resp = adminRequest(t, http.DefaultClient, http.MethodGet,
+		fmt.Sprintf("%s/api/admin/users/%d/orders/4561261212345467", testServer.URL, users[0].ID), "", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var adminView struct {
+		History []struct {
+			RawResponse json.RawMessage `json:"raw_response"`
+		} `json:"history"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&adminView))
+	resp.Body.Close()
+	require.Len(t, adminView.History, 2)
+	require.JSONEq(t, `{"order":"4561261212345467","status":"PROCESSED","accrual":500}`, string(adminView.History[1].RawResponse))
+}
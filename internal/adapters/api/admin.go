@@ -0,0 +1,309 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/go-chi/chi"
+)
+
+type setRoleData struct {
+	Role domain.Role `json:"role"`
+}
+
+// adminOrder - заказ для сотрудников, в истории есть ответы системы расчёта
+type adminOrder struct {
+	domain.Order
+	History []adminOrderStatusChange `json:"history,omitempty"`
+}
+
+type adminOrderStatusChange struct {
+	domain.OrderStatusChange
+	RawResponse json.RawMessage `json:"raw_response,omitempty"`
+}
+
+func (api *API) GetFailedOrders(response http.ResponseWriter, request *http.Request) {
+	orders, err := api.ordersService.GetFailedOrders(request.Context())
+	if err != nil {
+		api.logger.Errorf("api admin, get failed orders, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(orders) == 0 {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	data, err := json.Marshal(orders)
+	if err != nil {
+		api.logger.Errorf("api admin, get failed orders, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(data)
+}
+
+func (api *API) RequeueOrder(response http.ResponseWriter, request *http.Request) {
+	orderNum := chi.URLParam(request, "number")
+	err := api.ordersService.RequeueOrder(request.Context(), orderNum)
+	if err != nil {
+		if errors.Is(err, ports.ErrOrderNotFound) {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, ports.ErrIllegalTransition) {
+			response.WriteHeader(http.StatusConflict)
+			return
+		}
+		api.logger.Errorf("api admin, requeue order, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusAccepted)
+}
+
+func (api *API) RevokeUserSessions(response http.ResponseWriter, request *http.Request) {
+	uid, ok := uidFromPath(request)
+	if !ok {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err := api.authService.RevokeUserSessions(request.Context(), uid)
+	if err != nil {
+		if errors.Is(err, ports.ErrUserNotFound) {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		api.logger.Errorf("api admin, revoke user sessions, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) UnlockLogin(response http.ResponseWriter, request *http.Request) {
+	uid, ok := uidFromPath(request)
+	if !ok {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err := api.authService.UnlockLogin(request.Context(), uid)
+	if err != nil {
+		if errors.Is(err, ports.ErrUserNotFound) {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		api.logger.Errorf("api admin, unlock login, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// TargetUser подставляет в контекст uid пользователя из пути вместо uid сотрудника,
+// так пользовательские обработчики заказов, баланса и списаний отдают данные этого пользователя
+func (api *API) TargetUser(next http.Handler) http.Handler {
+	fn := func(response http.ResponseWriter, request *http.Request) {
+		uid, ok := uidFromPath(request)
+		if !ok {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, err := api.adminService.GetUser(request.Context(), uid); err != nil {
+			if errors.Is(err, ports.ErrUserNotFound) {
+				response.WriteHeader(http.StatusNotFound)
+				return
+			}
+			api.logger.Errorf("api admin, target user, service response: %v", err)
+			response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(request.Context(), JWTKey("uid"), uid)
+		next.ServeHTTP(response, request.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (api *API) SearchUsers(response http.ResponseWriter, request *http.Request) {
+	limit, _ := strconv.Atoi(request.URL.Query().Get("limit"))
+	users, err := api.adminService.SearchUsers(request.Context(), request.URL.Query().Get("q"), limit)
+	if err != nil {
+		api.logger.Errorf("api admin, search users, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(users) == 0 {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	api.writeAdminJSON(response, "search users", users)
+}
+
+func (api *API) GetUser(response http.ResponseWriter, request *http.Request) {
+	uid, ok := uidFromPath(request)
+	if !ok {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user, err := api.adminService.GetUser(request.Context(), uid)
+	if err != nil {
+		if errors.Is(err, ports.ErrUserNotFound) {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		api.logger.Errorf("api admin, get user, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	api.writeAdminJSON(response, "get user", user)
+}
+
+func (api *API) GetUserOrder(response http.ResponseWriter, request *http.Request) {
+	order, ok := api.getOrder(response, request)
+	if !ok {
+		return
+	}
+	view := adminOrder{Order: *order, History: make([]adminOrderStatusChange, len(order.History))}
+	for i, change := range order.History {
+		view.History[i] = adminOrderStatusChange{OrderStatusChange: change, RawResponse: change.RawResponse}
+	}
+	api.writeAdminJSON(response, "get user order", view)
+}
+
+func (api *API) SetRole(response http.ResponseWriter, request *http.Request) {
+	principal, err := getPrincipalFromRequest(request)
+	if err != nil {
+		api.logger.Errorf("api admin, set role, get principal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	uid, ok := uidFromPath(request)
+	if !ok {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var data setRoleData
+	if request.Header.Get("Content-Type") != "application/json" || json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data) != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = api.adminService.SetRole(request.Context(), principal, uid, data.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, ports.ErrInvalidRole):
+			response.WriteHeader(http.StatusBadRequest)
+		case errors.Is(err, ports.ErrSelfDemotion):
+			response.WriteHeader(http.StatusConflict)
+		case errors.Is(err, ports.ErrUserNotFound):
+			response.WriteHeader(http.StatusNotFound)
+		default:
+			api.logger.Errorf("api admin, set role, service response: %v", err)
+			response.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) AdjustBalance(response http.ResponseWriter, request *http.Request) {
+	principal, err := getPrincipalFromRequest(request)
+	if err != nil {
+		api.logger.Errorf("api admin, adjust balance, get principal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	uid, ok := uidFromPath(request)
+	if !ok {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var data domain.BalanceAdjustment
+	if request.Header.Get("Content-Type") != "application/json" || json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data) != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	balance, err := api.adminService.AdjustBalance(request.Context(), principal, uid, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ports.ErrInvalidAdjustment):
+			response.WriteHeader(http.StatusBadRequest)
+		case errors.Is(err, ports.ErrNotEnoughMoney):
+			response.WriteHeader(http.StatusPaymentRequired)
+		case errors.Is(err, ports.ErrUserNotFound):
+			response.WriteHeader(http.StatusNotFound)
+		default:
+			api.logger.Errorf("api admin, adjust balance, service response: %v", err)
+			response.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	api.writeAdminJSON(response, "adjust balance", balance)
+}
+
+func (api *API) GetAuditLog(response http.ResponseWriter, request *http.Request) {
+	var uid int64
+	if v := request.URL.Query().Get("uid"); v != "" {
+		var err error
+		if uid, err = strconv.ParseInt(v, 10, 64); err != nil || uid <= 0 {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(request.URL.Query().Get("limit"))
+	entries, err := api.adminService.GetAuditLog(request.Context(), uid, limit)
+	if err != nil {
+		api.logger.Errorf("api admin, get audit log, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	api.writeAdminJSON(response, "get audit log", entries)
+}
+
+func (api *API) GetOutboxEvents(response http.ResponseWriter, request *http.Request) {
+	var afterID int64
+	if v := request.URL.Query().Get("after"); v != "" {
+		var err error
+		if afterID, err = strconv.ParseInt(v, 10, 64); err != nil || afterID < 0 {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(request.URL.Query().Get("limit"))
+	events, err := api.adminService.GetOutboxEvents(request.Context(), afterID, limit)
+	if err != nil {
+		api.logger.Errorf("api admin, get outbox events, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	api.writeAdminJSON(response, "get outbox events", events)
+}
+
+func (api *API) writeAdminJSON(response http.ResponseWriter, action string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		api.logger.Errorf("api admin, %s, marshal: %v", action, err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(data)
+}
+
+func uidFromPath(request *http.Request) (int64, bool) {
+	uid, err := strconv.ParseInt(chi.URLParam(request, "uid"), 10, 64)
+	return uid, err == nil && uid > 0
+}
@@ -0,0 +1,213 @@
+//go:build integration || integration_api
+// +build integration integration_api
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminNotAuth(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/admin/orders/failed", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Admin-Token", "wrong")
+
+	resp, err := testServer.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAdminGetFailedOrdersNoContent(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/admin/orders/failed", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Admin-Token", "admin_token")
+
+	resp, err := testServer.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestAdminRequeueUnknownOrder(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/api/admin/orders/failed/4525634534/requeue", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Admin-Token", "admin_token")
+
+	resp, err := testServer.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func adminRequest(t *testing.T, client *http.Client, method, url, body string, adminToken bool) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if adminToken {
+		req.Header.Set("X-Admin-Token", "admin_token")
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestAdminRoles(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+	admin := testServer.URL + "/api/admin"
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "svirex")
+	resp := adminRequest(t, client, http.MethodGet, admin+"/users?q=svi", "", false)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// клиент общий для сервера, дальше в нём cookie сотрудника
+	staff := RegisterTestUser(t, testServer, testServer.URL, "staff")
+
+	resp = adminRequest(t, http.DefaultClient, http.MethodGet, admin+"/users?q=staff", "", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var users []domain.UserInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
+	resp.Body.Close()
+	require.Len(t, users, 1)
+	staffURL := fmt.Sprintf("%s/users/%d", admin, users[0].ID)
+
+	resp = adminRequest(t, http.DefaultClient, http.MethodPut, staffURL+"/role", `{"role": "root"}`, true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = adminRequest(t, http.DefaultClient, http.MethodPut, staffURL+"/role", `{"role": "support"}`, true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// смена роли отзывает токены, новая роль приходит с новым входом
+	resp = adminRequest(t, staff, http.MethodGet, admin+"/users", "", false)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, err := staff.Post(testServer.URL+"/api/user/login", "application/json",
+		strings.NewReader(`{"login": "staff", "password": "Gopher-Mart-Loyal-42"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = adminRequest(t, staff, http.MethodGet, admin+"/users?q=svirex", "", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
+	resp.Body.Close()
+	require.Len(t, users, 1)
+	userURL := fmt.Sprintf("%s/users/%d", admin, users[0].ID)
+
+	// support только смотрит
+	resp = adminRequest(t, staff, http.MethodPost, userURL+"/balance/adjustments", `{"amount": 100, "reason": "gift"}`, false)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = adminRequest(t, http.DefaultClient, http.MethodPost, userURL+"/balance/adjustments", `{"amount": 0, "reason": "gift"}`, true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = adminRequest(t, http.DefaultClient, http.MethodPost, userURL+"/balance/adjustments", `{"amount": -1, "reason": "fine"}`, true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	resp = adminRequest(t, http.DefaultClient, http.MethodPost, userURL+"/balance/adjustments", `{"amount": 100.5, "reason": "lost order"}`, true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = adminRequest(t, staff, http.MethodGet, userURL+"/balance", "", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var balance domain.Balance
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&balance))
+	resp.Body.Close()
+	require.Equal(t, domain.Money(100_50), balance.Current)
+
+	resp = adminRequest(t, staff, http.MethodGet, userURL+"/balance/history", "", false)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var history []domain.Posting
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	resp.Body.Close()
+	require.Len(t, history, 1)
+	require.Equal(t, domain.PostingAdjustment, history[0].Kind)
+
+	resp = adminRequest(t, staff, http.MethodGet, userURL+"/orders", "", false)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = adminRequest(t, staff, http.MethodGet, admin+"/users/100000/orders", "", false)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = adminRequest(t, staff, http.MethodGet, admin+"/audit", "", false)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = adminRequest(t, http.DefaultClient, http.MethodGet, admin+"/audit", "", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var audit []domain.AuditEntry
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&audit))
+	resp.Body.Close()
+	require.Len(t, audit, 2)
+	require.Equal(t, domain.AuditBalanceAdjusted, audit[0].Action)
+	require.Equal(t, "lost order", audit[0].Reason)
+	require.Equal(t, domain.AuditRoleChanged, audit[1].Action)
+
+	// отзыв сессий - только admin, пользователь выбирается по uid, как и в остальных маршрутах
+	resp, err = http.Post(testServer.URL+"/api/user/login", "application/json",
+		strings.NewReader(`{"login": "svirex", "password": "Gopher-Mart-Loyal-42"}`))
+	require.NoError(t, err)
+	var tokens tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = adminRequest(t, staff, http.MethodDelete, userURL+"/sessions", "", false)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = adminRequest(t, http.DefaultClient, http.MethodDelete, admin+"/users/100000/sessions", "", true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = adminRequest(t, http.DefaultClient, http.MethodDelete, userURL+"/sessions", "", true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/user/balance", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAdminGetOutboxEvents(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	resp := adminRequest(t, http.DefaultClient, http.MethodGet, testServer.URL+"/api/admin/outbox?after=-1", "", true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = adminRequest(t, http.DefaultClient, http.MethodGet, testServer.URL+"/api/admin/outbox?after=0", "", true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"expvar"
+
 	"github.com/Svirex/gofermart-loyality/internal/common"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -12,7 +15,12 @@ type API struct {
 	ordersService   ports.OrdersService
 	balanceService  ports.BalanceService
 	withdrawService ports.WithdrawService
+	adminService    ports.AdminService
 	logger          common.Logger
+	adminToken      string
+	cookies         CookieConfig
+
+	accrualWebhookSecret string
 }
 
 func NewAPI(
@@ -20,14 +28,23 @@ func NewAPI(
 	ordersService ports.OrdersService,
 	balanceService ports.BalanceService,
 	withdrawService ports.WithdrawService,
+	adminService ports.AdminService,
 	logger common.Logger,
+	adminToken string,
+	accrualWebhookSecret string,
+	cookies CookieConfig,
 ) *API {
 	return &API{
 		authService:     authService,
 		ordersService:   ordersService,
 		balanceService:  balanceService,
 		withdrawService: withdrawService,
+		adminService:    adminService,
 		logger:          logger,
+		adminToken:      adminToken,
+		cookies:         cookies,
+
+		accrualWebhookSecret: accrualWebhookSecret,
 	}
 }
 
@@ -38,15 +55,69 @@ func (api *API) Routes() chi.Router {
 	router.Use(middleware.Recoverer)
 	router.Use(GzipHandler)
 	router.Use(middleware.Compress(5, "text/html", "application/json"))
-	router.Use(api.CookieAuth([]string{"/api/user/register", "/api/user/login"}))
 
 	router.Post("/api/user/register", api.Register)
 	router.Post("/api/user/login", api.Login)
-	router.Post("/api/user/orders", api.CreateOrder)
-	router.Get("/api/user/orders", api.GetOrders)
-	router.Get("/api/user/balance", api.GetBalance)
-	router.Post("/api/user/balance/withdraw", api.Withdraw)
-	router.Get("/api/user/withdrawals", api.GetWithdrawals)
+	router.Post("/api/user/login/2fa", api.LoginSecondFactor)
+	router.Post("/api/user/token/refresh", api.RefreshToken)
+	router.Post("/api/internal/accrual/callback", api.AccrualCallback)
+	router.Get("/.well-known/jwks.json", api.JWKS)
+
+	router.Group(func(router chi.Router) {
+		router.Use(api.TokenAuth)
+
+		router.Post("/api/user/logout", api.Logout)
+		router.Post("/api/user/password", api.ChangePassword)
+		router.Delete("/api/user", api.DeleteUser)
+		router.Post("/api/user/2fa/enroll", api.EnrollTOTP)
+		router.Post("/api/user/2fa/verify", api.ConfirmTOTP)
+		router.Post("/api/user/2fa/disable", api.DisableTOTP)
+		router.Post("/api/user/orders", api.CreateOrder)
+		router.Post("/api/user/orders/batch", api.CreateOrdersBatch)
+		router.Get("/api/user/orders", api.GetOrders)
+		router.Get("/api/user/orders/{number}", api.GetOrder)
+		router.Get("/api/user/balance", api.GetBalance)
+		router.Get("/api/user/balance/history", api.GetBalanceHistory)
+		router.Post("/api/user/balance/withdraw", api.Withdraw)
+		router.Get("/api/user/withdrawals", api.GetWithdrawals)
+		router.Delete("/api/user/withdrawals/{order}", api.CancelWithdrawal)
+	})
+
+	router.Route("/api/admin", func(router chi.Router) {
+		router.Use(api.StaffAuth)
+
+		// support только смотрит и снимает блокировку входа
+		router.Group(func(router chi.Router) {
+			router.Use(api.RequireRole(domain.RoleSupport, domain.RoleAdmin))
+
+			router.Get("/users", api.SearchUsers)
+			router.Get("/orders/failed", api.GetFailedOrders)
+			router.Delete("/users/{uid}/lockout", api.UnlockLogin)
+
+			router.Group(func(router chi.Router) {
+				router.Use(api.TargetUser)
+
+				router.Get("/users/{uid}", api.GetUser)
+				router.Get("/users/{uid}/orders", api.GetOrders)
+				router.Get("/users/{uid}/orders/{number}", api.GetUserOrder)
+				router.Get("/users/{uid}/balance", api.GetBalance)
+				router.Get("/users/{uid}/balance/history", api.GetBalanceHistory)
+				router.Get("/users/{uid}/withdrawals", api.GetWithdrawals)
+			})
+		})
+
+		router.Group(func(router chi.Router) {
+			router.Use(api.RequireRole(domain.RoleAdmin))
+
+			router.Post("/orders/failed/{number}/requeue", api.RequeueOrder)
+			router.Delete("/users/{uid}/sessions", api.RevokeUserSessions)
+			router.Put("/users/{uid}/role", api.SetRole)
+			router.Post("/users/{uid}/balance/adjustments", api.AdjustBalance)
+			router.Get("/audit", api.GetAuditLog)
+			router.Get("/outbox", api.GetOutboxEvents)
+			router.Handle("/debug/vars", expvar.Handler())
+		})
+	})
 
 	return router
 }
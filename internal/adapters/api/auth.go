@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 )
 
@@ -14,6 +18,34 @@ type authData struct {
 	Password string `json:"password"`
 }
 
+// passwordRejectedResponse - тело 400 ответа со всеми причинами отказа в пароле
+type passwordRejectedResponse struct {
+	Error   string                     `json:"error"`
+	Reasons []domain.PasswordRejection `json:"reasons"`
+}
+
+type changePasswordData struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type deleteUserData struct {
+	Password string `json:"password"`
+}
+
+type refreshData struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// tokenResponse - токены в теле ответа для клиентов без cookie, access токен передаётся в Authorization: Bearer
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
 func (api *API) Register(response http.ResponseWriter, request *http.Request) {
 	contentType := request.Header.Get("Content-Type")
 	if contentType != "application/json" {
@@ -35,21 +67,23 @@ func (api *API) Register(response http.ResponseWriter, request *http.Request) {
 		response.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	jwt, err := api.authService.Register(request.Context(), auth.Login, auth.Password)
+	tokens, err := api.authService.Register(request.Context(), auth.Login, auth.Password)
 	if err != nil {
 		if errors.Is(err, ports.ErrUserAlreadyExists) {
 			response.WriteHeader(http.StatusConflict)
 			return
 		}
+		var policyErr *ports.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			api.logger.Debugf("api auth, register, password rejected: %v", err)
+			api.writePasswordRejected(response, policyErr)
+			return
+		}
 		api.logger.Error("api auth, register, service response: %v", err)
 		response.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	jwtCookie := &http.Cookie{
-		Name:  "jwt",
-		Value: jwt,
-	}
-	http.SetCookie(response, jwtCookie)
+	api.writeTokens(response, tokens)
 }
 
 func (api *API) Login(response http.ResponseWriter, request *http.Request) {
@@ -73,8 +107,19 @@ func (api *API) Login(response http.ResponseWriter, request *http.Request) {
 		response.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	jwt, err := api.authService.Login(request.Context(), auth.Login, auth.Password)
+	tokens, err := api.authService.Login(request.Context(), auth.Login, auth.Password, clientIP(request))
 	if err != nil {
+		var mfaErr *ports.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			api.writeMFARequired(response, mfaErr)
+			return
+		}
+		var lockedErr *ports.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			api.logger.Debugf("api auth, login, locked: %v", err)
+			writeLoginLocked(response, lockedErr)
+			return
+		}
 		if errors.Is(err, ports.ErrUserNotFound) || errors.Is(err, ports.ErrInvalidPassword) {
 			api.logger.Debugf("api auth, login, read body, service error: %v", err)
 			response.WriteHeader(http.StatusUnauthorized)
@@ -84,9 +129,166 @@ func (api *API) Login(response http.ResponseWriter, request *http.Request) {
 		response.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	jwtCookie := &http.Cookie{
-		Name:  "jwt",
-		Value: jwt,
+	api.writeTokens(response, tokens)
+}
+
+// RefreshToken берёт refresh токен из cookie, а без неё - из JSON тела {"refresh_token": "..."}
+func (api *API) RefreshToken(response http.ResponseWriter, request *http.Request) {
+	var refreshToken string
+	if cookie, err := request.Cookie(refreshTokenCookie); err == nil {
+		refreshToken = cookie.Value
+	} else if request.Header.Get("Content-Type") == "application/json" {
+		var data refreshData
+		if err := json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data); err != nil {
+			api.logger.Debugf("api auth, refresh token, unmarshal: %v", err)
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		refreshToken = data.RefreshToken
+	}
+	if refreshToken == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	tokens, err := api.authService.Refresh(request.Context(), refreshToken)
+	if err != nil {
+		if errors.Is(err, ports.ErrInvalidToken) {
+			api.logger.Debugf("api auth, refresh token, service response: %v", err)
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		api.logger.Errorf("api auth, refresh token, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	api.writeTokens(response, tokens)
+}
+
+func (api *API) Logout(response http.ResponseWriter, request *http.Request) {
+	principal, err := getPrincipalFromRequest(request)
+	if err != nil {
+		api.logger.Debugf("api auth, logout, get principal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = api.authService.Logout(request.Context(), principal)
+	if err != nil {
+		api.logger.Errorf("api auth, logout, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	api.clearTokenCookies(response)
+}
+
+func (api *API) ChangePassword(response http.ResponseWriter, request *http.Request) {
+	principal, err := getPrincipalFromRequest(request)
+	if err != nil {
+		api.logger.Errorf("api auth, change password, get principal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var data changePasswordData
+	if request.Header.Get("Content-Type") != "application/json" || json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data) != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = api.authService.ChangePassword(request.Context(), principal, data.OldPassword, data.NewPassword)
+	if err != nil {
+		api.writePasswordConfirmationError(response, "change password", err)
+		return
+	}
+}
+
+// DeleteUser удаляет аккаунт после подтверждения паролем
+func (api *API) DeleteUser(response http.ResponseWriter, request *http.Request) {
+	principal, err := getPrincipalFromRequest(request)
+	if err != nil {
+		api.logger.Errorf("api auth, delete user, get principal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var data deleteUserData
+	if request.Header.Get("Content-Type") != "application/json" || json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data) != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = api.authService.DeleteUser(request.Context(), principal, data.Password)
+	if err != nil {
+		api.writePasswordConfirmationError(response, "delete user", err)
+		return
+	}
+	api.clearTokenCookies(response)
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// writePasswordConfirmationError отвечает на ошибки действий, подтверждаемых текущим паролем
+func (api *API) writePasswordConfirmationError(response http.ResponseWriter, action string, err error) {
+	var policyErr *ports.PasswordPolicyError
+	var lockedErr *ports.LoginLockedError
+	switch {
+	case errors.As(err, &policyErr):
+		api.logger.Debugf("api auth, %s, password rejected: %v", action, err)
+		api.writePasswordRejected(response, policyErr)
+	case errors.As(err, &lockedErr):
+		api.logger.Debugf("api auth, %s, locked: %v", action, err)
+		writeLoginLocked(response, lockedErr)
+	case errors.Is(err, ports.ErrInvalidPassword), errors.Is(err, ports.ErrEmptyPassword):
+		api.logger.Debugf("api auth, %s, service response: %v", action, err)
+		response.WriteHeader(http.StatusForbidden)
+	case errors.Is(err, ports.ErrUserNotFound):
+		response.WriteHeader(http.StatusUnauthorized)
+	default:
+		api.logger.Errorf("api auth, %s, service response: %v", action, err)
+		response.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func writeLoginLocked(response http.ResponseWriter, lockedErr *ports.LoginLockedError) {
+	response.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(lockedErr.RetryAfter.Seconds())), 10))
+	response.WriteHeader(http.StatusTooManyRequests)
+}
+
+func (api *API) writePasswordRejected(response http.ResponseWriter, policyErr *ports.PasswordPolicyError) {
+	data, err := json.Marshal(passwordRejectedResponse{Error: "weak_password", Reasons: policyErr.Rejections})
+	if err != nil {
+		api.logger.Errorf("api auth, password rejected, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusBadRequest)
+	response.Write(data)
+}
+
+// writeTokens ставит cookie для браузера и возвращает те же токены в теле ответа
+func (api *API) writeTokens(response http.ResponseWriter, tokens *domain.TokenPair) {
+	data, err := json.Marshal(tokenResponse{
+		AccessToken:      tokens.AccessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(time.Until(tokens.AccessExpiresAt).Seconds()),
+		RefreshToken:     tokens.RefreshToken,
+		RefreshExpiresIn: int64(time.Until(tokens.RefreshExpiresAt).Seconds()),
+	})
+	if err != nil {
+		api.logger.Errorf("api auth, write tokens, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	api.setTokenCookies(response, tokens)
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.Write(data)
+}
+
+// JWKS отдаёт публичные ключи подписи access токенов для проверки в других сервисах
+func (api *API) JWKS(response http.ResponseWriter, request *http.Request) {
+	data, err := json.Marshal(api.authService.JWKS())
+	if err != nil {
+		api.logger.Errorf("api auth, jwks, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	http.SetCookie(response, jwtCookie)
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "public, max-age=300")
+	response.Write(data)
 }
@@ -4,14 +4,19 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/Svirex/gofermart-loyality/internal/adapters/accrual"
 	"github.com/Svirex/gofermart-loyality/internal/adapters/postgres"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/services"
 	"github.com/Svirex/gofermart-loyality/test/testdb"
 	"github.com/stretchr/testify/require"
@@ -39,19 +44,52 @@ func setupTest(t *testing.T) func() {
 
 func NewTestServer(t *testing.T) *httptest.Server {
 	authRepo := postgres.NewAuthRepository(testdb.GetPool())
-	auth, err := services.NewAuthService(authRepo, 80, 8, 10, "fake_secret")
+	auth, err := services.NewAuthService(authRepo, postgres.NewSessionRepository(testdb.GetPool(), testdb.GetLogger()),
+		postgres.NewRevocationRepository(testdb.GetPool(), testdb.GetLogger()),
+		postgres.NewLoginThrottleRepository(testdb.GetPool(), testdb.GetLogger()),
+		postgres.NewSecondFactorRepository(testdb.GetPool(), testdb.GetLogger()), services.AuthConfig{
+			MinPasswordEntropyBits: 80,
+			MinPasswordLength:      8,
+			BcryptCost:             10,
+			JWTSecretKey:           "fake_secret",
+			AccessTokenTTL:         15 * time.Minute,
+			RefreshTokenTTL:        time.Hour,
+			RevocationCacheTTL:     time.Second,
+			LoginThrottle: services.LoginThrottleConfig{
+				LoginFreeAttempts: 3,
+				BaseLockout:       time.Minute,
+				MaxLockout:        time.Minute,
+				Window:            time.Hour,
+			},
+		})
 	require.NoError(t, err)
 
 	ordersRepo := postgres.NewOrdersRepository(testdb.GetPool(), testdb.GetLogger())
-	orders, err := services.NewOrderService(testdb.GetPool(), ordersRepo, testdb.GetLogger(), 20, "http://mock_accrual:3000", 1*time.Second, 20, 2*time.Second)
+	orders, err := services.NewOrderService(ordersRepo, postgres.NewAccrualRepository(testdb.GetPool(), testdb.GetLogger()),
+		accrual.NewClient("http://mock_accrual:3000", http.DefaultClient, accrual.NewLimiter(60, 1)), testdb.GetLogger(), services.CheckAccrualConfig{
+			InstanceID:    "test",
+			Workers:       4,
+			PollInterval:  2 * time.Second,
+			BatchSize:     20,
+			LeaseDuration: 30 * time.Second,
+			Retry: services.RetryPolicy{
+				BaseDelay:  time.Second,
+				MaxDelay:   time.Second,
+				Multiplier: 1,
+			},
+		})
 
 	balanceRepo := postgres.NewBalanceRepository(testdb.GetPool(), testdb.GetLogger())
 	balance := services.NewBalanceService(balanceRepo)
 
 	withdrawRepo := postgres.NewWithdrawRepository(testdb.GetPool(), testdb.GetLogger())
-	withdraw := services.NewWithdrawService(withdrawRepo)
+	withdraw := services.NewWithdrawService(withdrawRepo, auth, time.Hour, 100_00)
 
-	api := NewAPI(auth, orders, balance, withdraw, testdb.GetLogger())
+	admin := services.NewAdminService(postgres.NewAdminRepository(testdb.GetPool(), testdb.GetLogger()), postgres.NewOutboxRepository(testdb.GetPool(), testdb.GetLogger()), auth)
+
+	api := NewAPI(auth, orders, balance, withdraw, admin, testdb.GetLogger(), "admin_token", "webhook_secret", CookieConfig{
+		SameSite: http.SameSiteLaxMode,
+	})
 
 	return httptest.NewServer(api.Routes())
 }
@@ -131,7 +169,7 @@ func TestRegisterGood(t *testing.T) {
 	body := `
 	{
 		"login": "svirex",
-		"password": "password"
+		"password": "Gopher-Mart-Loyal-42"
 	}
 	`
 
@@ -155,6 +193,28 @@ func TestRegisterGood(t *testing.T) {
 	require.True(t, foundJWTCookie)
 }
 
+func TestRegisterWeakPassword(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	body := `{"login": "svirex", "password": "svirex123"}`
+	resp, err := testServer.Client().Post(testServer.URL+"/api/user/register", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var rejected passwordRejectedResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rejected))
+	require.Equal(t, "weak_password", rejected.Error)
+	codes := make([]domain.PasswordRejectCode, 0, len(rejected.Reasons))
+	for _, reason := range rejected.Reasons {
+		require.NotEmpty(t, reason.Message)
+		codes = append(codes, reason.Code)
+	}
+	require.ElementsMatch(t, []domain.PasswordRejectCode{domain.PasswordLowEntropy, domain.PasswordSimilarToLogin}, codes)
+}
+
 func TestLoginInvalidContentType(t *testing.T) {
 	defer setupTest(t)()
 
@@ -230,7 +290,7 @@ func TestLoginGood(t *testing.T) {
 	body := `
 	{
 		"login": "svirex",
-		"password": "password"
+		"password": "Gopher-Mart-Loyal-42"
 	}
 	`
 
@@ -272,7 +332,7 @@ func TestLoginUserNotFound(t *testing.T) {
 	body := `
 	{
 		"login": "svirex",
-		"password": "password"
+		"password": "Gopher-Mart-Loyal-42"
 	}
 	`
 
@@ -289,7 +349,7 @@ func TestLoginUserNotFound(t *testing.T) {
 	body = `
 	{
 		"login": "tytyrtyrt",
-		"password": "password"
+		"password": "Gopher-Mart-Loyal-42"
 	}
 	`
 
@@ -313,7 +373,7 @@ func TestLoginInvalidPassword(t *testing.T) {
 	body := `
 	{
 		"login": "svirex",
-		"password": "password"
+		"password": "Gopher-Mart-Loyal-42"
 	}
 	`
 
@@ -345,3 +405,204 @@ func TestLoginInvalidPassword(t *testing.T) {
 	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 
 }
+
+func TestRefreshAndLogout(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+
+	resp, err := client.Post(testServer.URL+"/api/user/token/refresh", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Post(testServer.URL+"/api/user/logout", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(testServer.URL + "/api/user/orders")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = client.Post(testServer.URL+"/api/user/token/refresh", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestJWKS(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	resp, err := testServer.Client().Get(testServer.URL + "/.well-known/jwks.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	// тестовый сервер подписывает HS256, публичных ключей нет
+	var set domain.JWKSet
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	require.Empty(t, set.Keys)
+}
+
+func TestBearerAuth(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+	// клиент без cookiejar, токены только из тела ответа
+	client := &http.Client{}
+
+	body := `{"login": "svirex", "password": "Gopher-Mart-Loyal-42"}`
+	resp, err := client.Post(testServer.URL+"/api/user/register", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var tokens tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	resp.Body.Close()
+	require.NotEmpty(t, tokens.AccessToken)
+	require.NotEmpty(t, tokens.RefreshToken)
+	require.Equal(t, "Bearer", tokens.TokenType)
+	require.Positive(t, tokens.ExpiresIn)
+
+	for _, cookie := range resp.Cookies() {
+		require.True(t, cookie.HttpOnly)
+		require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		require.Positive(t, cookie.MaxAge)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/user/balance", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req.Header.Set("Authorization", "Basic "+tokens.AccessToken)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	body = `{"refresh_token": "` + tokens.RefreshToken + `"}`
+	resp, err = client.Post(testServer.URL+"/api/user/token/refresh", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	var refreshed tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&refreshed))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+
+	req, err = http.NewRequest(http.MethodPost, testServer.URL+"/api/user/logout", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+refreshed.AccessToken)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestLoginLockout(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+	client := &http.Client{}
+
+	good := `{"login": "svirex", "password": "Gopher-Mart-Loyal-42"}`
+	bad := `{"login": "svirex", "password": "Gopher-Mart-Loyal-43"}`
+
+	resp, err := client.Post(testServer.URL+"/api/user/register", "application/json", strings.NewReader(good))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// три попытки бесплатно, четвёртая блокирует вход
+	for i := 0; i < 4; i++ {
+		resp, err = client.Post(testServer.URL+"/api/user/login", "application/json", strings.NewReader(bad))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	resp, err = client.Post(testServer.URL+"/api/user/login", "application/json", strings.NewReader(good))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	require.InDelta(t, 60, retryAfter, 2)
+
+	resp = adminRequest(t, client, http.MethodGet, testServer.URL+"/api/admin/users?q=svirex", "", true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var users []domain.UserInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&users))
+	resp.Body.Close()
+	require.Len(t, users, 1)
+
+	resp = adminRequest(t, client, http.MethodDelete, testServer.URL+"/api/admin/users/0/lockout", "", true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = adminRequest(t, client, http.MethodDelete, fmt.Sprintf("%s/api/admin/users/%d/lockout", testServer.URL, users[0].ID+1), "", true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = adminRequest(t, client, http.MethodDelete, fmt.Sprintf("%s/api/admin/users/%d/lockout", testServer.URL, users[0].ID), "", true)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = client.Post(testServer.URL+"/api/user/login", "application/json", strings.NewReader(good))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestChangePasswordAndDeleteUser(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "svirex")
+
+	body := `{"old_password": "Gopher-Mart-Loyal-00", "new_password": "Another-Strong-Pass-7"}`
+	resp, err := client.Post(testServer.URL+"/api/user/password", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	body = `{"old_password": "Gopher-Mart-Loyal-42", "new_password": "svirex"}`
+	resp, err = client.Post(testServer.URL+"/api/user/password", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	body = `{"old_password": "Gopher-Mart-Loyal-42", "new_password": "Another-Strong-Pass-7"}`
+	resp, err = client.Post(testServer.URL+"/api/user/password", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodDelete, testServer.URL+"/api/user", strings.NewReader(`{"password": "Another-Strong-Pass-7"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	body = `{"login": "svirex", "password": "Another-Strong-Pass-7"}`
+	resp, err = client.Post(testServer.URL+"/api/user/login", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -2,37 +2,98 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"net/http"
 	"slices"
+	"strings"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 )
 
 type JWTKey string
 
-func (api *API) CookieAuth(whitelist []string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		fn := func(response http.ResponseWriter, request *http.Request) {
-			path := request.URL.Path
-			if path[0] != '/' {
-				path = "/" + path
-			}
-			if slices.Contains(whitelist, path) {
-				next.ServeHTTP(response, request)
-				return
-			}
-			jwtKey, err := request.Cookie("jwt")
-			if errors.Is(err, http.ErrNoCookie) {
+// TokenAuth принимает access токен из заголовка Authorization: Bearer, а без заголовка - из cookie
+func (api *API) TokenAuth(next http.Handler) http.Handler {
+	fn := func(response http.ResponseWriter, request *http.Request) {
+		token, ok := accessTokenFromRequest(request)
+		if !ok {
+			response.Header().Set("WWW-Authenticate", "Bearer")
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		principal, err := api.authService.Authenticate(request.Context(), token)
+		if err != nil {
+			if errors.Is(err, ports.ErrInvalidToken) {
+				api.logger.Debugf("token auth middlware, authenticate: %v", err)
 				response.WriteHeader(http.StatusUnauthorized)
 				return
 			}
-			uid, err := api.authService.GetUserGromJWT(request.Context(), jwtKey.Value)
+			api.logger.Errorf("token auth middlware, authenticate: %v", err)
+			response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(request.Context(), JWTKey("uid"), principal.UID)
+		ctx = context.WithValue(ctx, JWTKey("principal"), principal)
+		next.ServeHTTP(response, request.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+func accessTokenFromRequest(request *http.Request) (string, bool) {
+	if header := request.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return "", false
+		}
+		token = strings.TrimSpace(token)
+		return token, token != ""
+	}
+	cookie, err := request.Cookie(accessTokenCookie)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// StaffAuth пропускает сотрудников по access токену, права по ролям проверяет RequireRole.
+// Заголовок X-Admin-Token с настроенным токеном даёт права администратора без учётной записи:
+// так выдаётся первая роль admin и работают служебные скрипты.
+func (api *API) StaffAuth(next http.Handler) http.Handler {
+	tokenAuth := api.TokenAuth(next)
+	fn := func(response http.ResponseWriter, request *http.Request) {
+		token := request.Header.Get("X-Admin-Token")
+		if token == "" {
+			tokenAuth.ServeHTTP(response, request)
+			return
+		}
+		if api.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(api.adminToken)) != 1 {
+			response.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(request.Context(), JWTKey("principal"), &domain.Principal{Role: domain.RoleAdmin})
+		next.ServeHTTP(response, request.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// RequireRole отвечает 403, если роль пользователя не из roles
+func (api *API) RequireRole(roles ...domain.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(response http.ResponseWriter, request *http.Request) {
+			principal, err := getPrincipalFromRequest(request)
 			if err != nil {
-				api.logger.Errorf("cookie auth middlware, get user from jwt: %v", err)
-				response.WriteHeader(http.StatusUnauthorized)
+				api.logger.Errorf("require role middleware, get principal: %v", err)
+				response.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !slices.Contains(roles, principal.Role) {
+				api.logger.Debugf("require role middleware, uid %d has role %q, want %v", principal.UID, principal.Role, roles)
+				response.WriteHeader(http.StatusForbidden)
 				return
 			}
-			ctx := context.WithValue(request.Context(), JWTKey("uid"), uid)
-			next.ServeHTTP(response, request.WithContext(ctx))
+			next.ServeHTTP(response, request)
 		}
 		return http.HandlerFunc(fn)
 	}
@@ -27,3 +27,30 @@ func (api *API) GetBalance(response http.ResponseWriter, request *http.Request)
 	response.Header().Set("Content-Type", "application/json")
 	response.Write(body)
 }
+
+func (api *API) GetBalanceHistory(response http.ResponseWriter, request *http.Request) {
+	uid, err := getUIDFromRequest(request)
+	if err != nil {
+		api.logger.Debugf("api balance, get history, get uid: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	history, err := api.balanceService.GetHistory(request.Context(), uid)
+	if err != nil {
+		api.logger.Debugf("api balance, get history, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(history) == 0 {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	body, err := json.Marshal(history)
+	if err != nil {
+		api.logger.Debugf("api balance, get history, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(body)
+}
@@ -55,3 +55,20 @@ func TestGetBalanceGood(t *testing.T) {
 	err = json.Unmarshal(body, &balance)
 	require.NoError(t, err)
 }
+
+func TestGetBalanceHistoryNoContent(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/user/balance/history", nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
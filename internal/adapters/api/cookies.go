@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+)
+
+const (
+	accessTokenCookie  = "jwt"
+	refreshTokenCookie = "refresh_token"
+	// refresh токен нужен только эндпоинту обновления, остальным запросам его не отправляем
+	refreshTokenPath = "/api/user/token"
+)
+
+// CookieConfig - атрибуты cookie с токенами. Refresh cookie всегда HttpOnly и ограничена refreshTokenPath.
+type CookieConfig struct {
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// ParseSameSite разбирает значение SameSite из конфигурации: lax, strict, none или пустая строка
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return http.SameSiteDefaultMode, fmt.Errorf("parse same site, unknown value %q", value)
+}
+
+func (api *API) tokenCookie(name, value, path string, expiresAt time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   api.cookies.Domain,
+		Secure:   api.cookies.Secure,
+		SameSite: api.cookies.SameSite,
+		HttpOnly: true,
+	}
+	if expiresAt.IsZero() {
+		cookie.MaxAge = -1
+		return cookie
+	}
+	cookie.Expires = expiresAt.UTC().Truncate(time.Second)
+	cookie.MaxAge = int(time.Until(expiresAt).Seconds())
+	if cookie.MaxAge <= 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+func (api *API) setTokenCookies(response http.ResponseWriter, tokens *domain.TokenPair) {
+	http.SetCookie(response, api.tokenCookie(accessTokenCookie, tokens.AccessToken, "/", tokens.AccessExpiresAt))
+	http.SetCookie(response, api.tokenCookie(refreshTokenCookie, tokens.RefreshToken, refreshTokenPath, tokens.RefreshExpiresAt))
+}
+
+func (api *API) clearTokenCookies(response http.ResponseWriter) {
+	http.SetCookie(response, api.tokenCookie(accessTokenCookie, "", "/", time.Time{}))
+	http.SetCookie(response, api.tokenCookie(refreshTokenCookie, "", refreshTokenPath, time.Time{}))
+}
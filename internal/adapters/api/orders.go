@@ -1,12 +1,18 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/go-chi/chi"
 )
 
 func (api *API) CreateOrder(response http.ResponseWriter, request *http.Request) {
@@ -56,6 +62,110 @@ func (api *API) CreateOrder(response http.ResponseWriter, request *http.Request)
 	}
 }
 
+// maxOrdersBatchBody - с запасом на 1000 номеров в JSON
+const maxOrdersBatchBody = 1 << 17
+
+// orderBatchStatuses - результат пакетной загрузки по номеру, как коды ответа в CreateOrder
+var orderBatchStatuses = map[ports.Status]string{
+	ports.Ok:            "accepted",
+	ports.AlreadyAdded:  "already_added",
+	ports.NotOwnOrder:   "not_own_order",
+	ports.InvalidNumber: "invalid_number",
+}
+
+type orderBatchResult struct {
+	Number string `json:"number"`
+	Status string `json:"status"`
+}
+
+// CreateOrdersBatch принимает номера JSON массивом или text/plain по одному на строку
+func (api *API) CreateOrdersBatch(response http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(response, request.Body, maxOrdersBatchBody))
+	if err != nil {
+		api.logger.Debugf("api orders, create orders batch, read body: %v", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			response.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	orderNums, err := parseOrdersBatch(request.Header.Get("Content-Type"), body)
+	if err != nil || len(orderNums) == 0 {
+		api.logger.Debugf("api orders, create orders batch, parse body: %v", err)
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	uid, err := getUIDFromRequest(request)
+	if err != nil {
+		api.logger.Debugf("api orders, create orders batch, get uid: %v", err)
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	statuses, err := api.ordersService.CreateOrders(request.Context(), uid, orderNums)
+	if err != nil {
+		if errors.Is(err, ports.ErrOrdersBatchTooLarge) {
+			api.logger.Debugf("api orders, create orders batch, service response: %v", err)
+			response.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		api.logger.Errorf("api orders, create orders batch, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	results := make([]orderBatchResult, len(orderNums))
+	for i := range orderNums {
+		results[i] = orderBatchResult{Number: orderNums[i], Status: orderBatchStatuses[statuses[i]]}
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		api.logger.Debugf("api orders, create orders batch, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(data)
+}
+
+// parseOrdersBatch - в JSON номера строками или числами, в text/plain пустые строки пропускаются
+func parseOrdersBatch(contentType string, body []byte) ([]string, error) {
+	switch contentType {
+	case "application/json":
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var values []any
+		if err := decoder.Decode(&values); err != nil {
+			return nil, fmt.Errorf("parse orders batch, decode: %w", err)
+		}
+		orderNums := make([]string, 0, len(values))
+		for _, v := range values {
+			switch v := v.(type) {
+			case string:
+				// пробелы вокруг номера допустимы, как и в text/plain, пустой номер - ошибка запроса
+				if v = strings.TrimSpace(v); v == "" {
+					return nil, fmt.Errorf("parse orders batch, empty order number")
+				}
+				orderNums = append(orderNums, v)
+			case json.Number:
+				orderNums = append(orderNums, v.String())
+			default:
+				return nil, fmt.Errorf("parse orders batch, unexpected value %v", v)
+			}
+		}
+		return orderNums, nil
+	case "text/plain":
+		orderNums := make([]string, 0)
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				orderNums = append(orderNums, line)
+			}
+		}
+		return orderNums, nil
+	}
+	return nil, fmt.Errorf("parse orders batch, content type %q", contentType)
+}
+
 func (api *API) GetOrders(response http.ResponseWriter, request *http.Request) {
 	uid, err := getUIDFromRequest(request)
 	if err != nil {
@@ -63,17 +173,24 @@ func (api *API) GetOrders(response http.ResponseWriter, request *http.Request) {
 		response.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	orders, err := api.ordersService.GetOrders(request.Context(), uid)
+	query, err := parseOrdersQuery(request.URL.Query())
+	if err != nil {
+		api.logger.Debugf("api orders, get orders, parse query: %v", err)
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	page, err := api.ordersService.GetOrders(request.Context(), uid, *query)
 	if err != nil {
 		api.logger.Debugf("api orders, get orders, service response: %v", err)
 		response.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if len(orders) == 0 {
+	setNextCursor(response, page.Next)
+	if len(page.Orders) == 0 {
 		response.WriteHeader(http.StatusNoContent)
 		return
 	}
-	data, err := json.Marshal(orders)
+	data, err := json.Marshal(page.Orders)
 	if err != nil {
 		api.logger.Debugf("api orders, get orders, marshal: %v", err)
 		response.WriteHeader(http.StatusBadRequest)
@@ -82,3 +199,67 @@ func (api *API) GetOrders(response http.ResponseWriter, request *http.Request) {
 	response.Header().Add("Content-Type", "application/json")
 	response.Write(data)
 }
+
+func (api *API) GetOrder(response http.ResponseWriter, request *http.Request) {
+	order, ok := api.getOrder(response, request)
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(order)
+	if err != nil {
+		api.logger.Debugf("api orders, get order, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Add("Content-Type", "application/json")
+	response.Write(data)
+}
+
+// getOrder отдаёт заказ пользователя из контекста по номеру из пути, при ошибке ответ уже записан
+func (api *API) getOrder(response http.ResponseWriter, request *http.Request) (*domain.Order, bool) {
+	uid, err := getUIDFromRequest(request)
+	if err != nil {
+		api.logger.Debugf("api orders, get order, get uid: %v", err)
+		response.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	order, err := api.ordersService.GetOrder(request.Context(), uid, chi.URLParam(request, "number"))
+	if err != nil {
+		api.logger.Debugf("api orders, get order, service response: %v", err)
+		if errors.Is(err, ports.ErrOrderNotFound) {
+			response.WriteHeader(http.StatusNotFound)
+			return nil, false
+		}
+		if errors.Is(err, ports.ErrNotOwnOrder) {
+			response.WriteHeader(http.StatusForbidden)
+			return nil, false
+		}
+		response.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	return order, true
+}
+
+// parseOrdersQuery дополняет общие параметры списка фильтром status: повторяющимся параметром или через запятую
+func parseOrdersQuery(values url.Values) (*domain.OrdersQuery, error) {
+	page, err := parsePageQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	query := &domain.OrdersQuery{
+		From:  page.From,
+		To:    page.To,
+		After: page.After,
+		Limit: page.Limit,
+	}
+	for _, v := range values["status"] {
+		for _, s := range strings.Split(v, ",") {
+			status := domain.Status(strings.ToUpper(strings.TrimSpace(s)))
+			if !status.Valid() {
+				return nil, fmt.Errorf("%w: status %q", errInvalidPageQuery, s)
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+	return query, nil
+}
@@ -37,7 +37,7 @@ func RegisterTestUser(t *testing.T, server *httptest.Server, addr string, login
 	body := fmt.Sprintf(`
 	{
 		"login": "%s",
-		"password": "testpassword"
+		"password": "Gopher-Mart-Loyal-42"
 	}
 	`, login)
 	req, err := http.NewRequest(http.MethodPost, addr+"/api/user/register", strings.NewReader(body))
@@ -263,3 +263,162 @@ func TestGetOrdersGood(t *testing.T) {
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
 }
+
+func TestGetOrdersPagination(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+
+	for _, num := range []string{"67", "26", "18"} {
+		resp, err := client.Post(testServer.URL+"/api/user/orders", "text/plain", strings.NewReader(num))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	}
+
+	getOrders := func(query string) (*http.Response, []domain.Order) {
+		resp, err := client.Get(testServer.URL + "/api/user/orders" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var orders []domain.Order
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&orders))
+		}
+		return resp, orders
+	}
+
+	resp, orders := getOrders("?limit=2")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, orders, 2)
+	require.Equal(t, "18", orders[0].Number)
+	cursor := resp.Header.Get("X-Next-Cursor")
+	require.NotEmpty(t, cursor)
+
+	resp, orders = getOrders("?limit=2&after=" + cursor)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, orders, 1)
+	require.Equal(t, "67", orders[0].Number)
+	require.Empty(t, resp.Header.Get("X-Next-Cursor"))
+
+	// без параметров список целиком
+	resp, orders = getOrders("")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, orders, 3)
+	require.Empty(t, resp.Header.Get("X-Next-Cursor"))
+
+	resp, _ = getOrders("?status=invalid,processed")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = getOrders("?from=2000-01-01&to=2000-01-31")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	for _, query := range []string{"?limit=0", "?limit=abc", "?after=garbage", "?status=DONE", "?from=yesterday", "?from=2024-02-01&to=2024-01-01"} {
+		resp, _ = getOrders(query)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func TestGetOrder(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	// клиент общий для сервера, сначала загружает заказ другой пользователь
+	client := RegisterTestUser(t, testServer, testServer.URL, "other")
+	resp, err := client.Post(testServer.URL+"/api/user/orders", "text/plain", strings.NewReader("26"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	client = RegisterTestUser(t, testServer, testServer.URL, "test")
+	resp, err = client.Post(testServer.URL+"/api/user/orders", "text/plain", strings.NewReader("67"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	resp, err = client.Get(testServer.URL + "/api/user/orders/67")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var order domain.Order
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&order))
+	resp.Body.Close()
+	require.Equal(t, "67", order.Number)
+	require.Len(t, order.History, 1)
+	require.Equal(t, domain.New, order.History[0].Status)
+
+	resp, err = client.Get(testServer.URL + "/api/user/orders/26")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = client.Get(testServer.URL + "/api/user/orders/18")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestCreateOrdersBatch(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	// клиент общий для сервера, сначала загружает заказ другой пользователь
+	client := RegisterTestUser(t, testServer, testServer.URL, "other")
+	resp, err := client.Post(testServer.URL+"/api/user/orders", "text/plain", strings.NewReader("26"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	client = RegisterTestUser(t, testServer, testServer.URL, "test")
+	postBatch := func(contentType, body string) (*http.Response, map[string]string) {
+		resp, err := client.Post(testServer.URL+"/api/user/orders/batch", contentType, strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var results []struct {
+			Number string `json:"number"`
+			Status string `json:"status"`
+		}
+		statuses := make(map[string]string)
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+			for _, r := range results {
+				statuses[r.Number] = r.Status
+			}
+		}
+		return resp, statuses
+	}
+
+	resp, statuses := postBatch("application/json", `["67", "26", 18, "12"]`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, map[string]string{
+		"67": "accepted",
+		"26": "not_own_order",
+		"18": "accepted",
+		"12": "invalid_number",
+	}, statuses)
+
+	resp, statuses = postBatch("text/plain", "67\r\n\n18\n")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, map[string]string{"67": "already_added", "18": "already_added"}, statuses)
+
+	resp, err = client.Get(testServer.URL + "/api/user/orders")
+	require.NoError(t, err)
+	var orders []domain.Order
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&orders))
+	resp.Body.Close()
+	require.Len(t, orders, 2)
+
+	for _, c := range []struct{ contentType, body string }{
+		{"application/json", `[]`},
+		{"application/json", `{"number": "67"}`},
+		{"application/json", `[["67"]]`},
+		{"application/json", `[""]`},
+		{"application/json", `["67", "  "]`},
+		{"text/plain", "\n\n"},
+		{"application/xml", "<orders/>"},
+	} {
+		resp, _ = postBatch(c.contentType, c.body)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, c.body)
+	}
+}
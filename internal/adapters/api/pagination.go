@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+)
+
+// nextCursorHeader - курсор следующей страницы, передаётся обратно в параметре after. На последней странице заголовка нет.
+const nextCursorHeader = "X-Next-Cursor"
+
+var errInvalidPageQuery = errors.New("invalid page query")
+
+// pageQuery - общие параметры списков: limit, after, from и to. Без limit список отдаётся целиком.
+type pageQuery struct {
+	Limit int
+	After *domain.Cursor
+	From  time.Time
+	To    time.Time
+}
+
+func parsePageQuery(values url.Values) (*pageQuery, error) {
+	query := &pageQuery{}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("%w: limit %q", errInvalidPageQuery, v)
+		}
+		query.Limit = limit
+	}
+	if v := values.Get("after"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		query.After = cursor
+	}
+	var err error
+	if query.From, err = parseTimeParam(values.Get("from"), false); err != nil {
+		return nil, err
+	}
+	if query.To, err = parseTimeParam(values.Get("to"), true); err != nil {
+		return nil, err
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return nil, fmt.Errorf("%w: from %v is not before to %v", errInvalidPageQuery, query.From, query.To)
+	}
+	return query, nil
+}
+
+// parseTimeParam принимает RFC 3339 или дату. Дата - сутки по UTC, в to она означает конец этого дня,
+// чтобы to=2024-01-31 включал 31 число. Граница - момент времени, в часовой пояс колонок её переводит запрос.
+func parseTimeParam(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: time %q", errInvalidPageQuery, v)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// encodeCursor - время в микросекундах, с такой точностью его хранит postgres
+func encodeCursor(cursor *domain.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", cursor.At.UnixMicro(), cursor.ID)))
+}
+
+func decodeCursor(v string) (*domain.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cursor: %v", errInvalidPageQuery, err)
+	}
+	at, id, found := strings.Cut(string(data), ".")
+	if !found {
+		return nil, fmt.Errorf("%w: cursor %q", errInvalidPageQuery, v)
+	}
+	micros, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cursor time: %v", errInvalidPageQuery, err)
+	}
+	cursor := &domain.Cursor{At: time.UnixMicro(micros).UTC()}
+	if cursor.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: cursor id: %v", errInvalidPageQuery, err)
+	}
+	return cursor, nil
+}
+
+func setNextCursor(response http.ResponseWriter, cursor *domain.Cursor) {
+	if cursor != nil {
+		response.Header().Set(nextCursorHeader, encodeCursor(cursor))
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+// secondFactorHeader - код TOTP или код восстановления для действий, требующих второго фактора
+const secondFactorHeader = "X-OTP"
+
+type mfaRequiredResponse struct {
+	Error     string `json:"error"`
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+type secondFactorErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type loginSecondFactorData struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type enrollTOTPData struct {
+	Password string `json:"password"`
+}
+
+type confirmTOTPData struct {
+	Code string `json:"code"`
+}
+
+type disableTOTPData struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginSecondFactor завершает вход кодом второго фактора, mfa_token - из ответа /api/user/login
+func (api *API) LoginSecondFactor(response http.ResponseWriter, request *http.Request) {
+	var data loginSecondFactorData
+	if request.Header.Get("Content-Type") != "application/json" || json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data) != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	tokens, err := api.authService.LoginSecondFactor(request.Context(), data.MFAToken, data.Code, clientIP(request))
+	if err != nil {
+		var lockedErr *ports.LoginLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			api.logger.Debugf("api second factor, login, locked: %v", err)
+			writeLoginLocked(response, lockedErr)
+		case errors.Is(err, ports.ErrInvalidToken), errors.Is(err, ports.ErrInvalidSecondFactor), errors.Is(err, ports.ErrSecondFactorRequired):
+			api.logger.Debugf("api second factor, login, service response: %v", err)
+			response.WriteHeader(http.StatusUnauthorized)
+		default:
+			api.logger.Errorf("api second factor, login, service response: %v", err)
+			response.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	api.writeTokens(response, tokens)
+}
+
+// EnrollTOTP выдаёт новый секрет по текущему паролю, второй фактор заработает после подтверждения кодом в ConfirmTOTP
+func (api *API) EnrollTOTP(response http.ResponseWriter, request *http.Request) {
+	principal, err := getPrincipalFromRequest(request)
+	if err != nil {
+		api.logger.Errorf("api second factor, enroll, get principal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var data enrollTOTPData
+	if request.Header.Get("Content-Type") != "application/json" || json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data) != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	enrollment, err := api.authService.EnrollTOTP(request.Context(), principal, data.Password)
+	if err != nil {
+		api.writeSecondFactorError(response, "enroll", err)
+		return
+	}
+	api.writeNoStoreJSON(response, "enroll", enrollment)
+}
+
+// ConfirmTOTP включает второй фактор и один раз отдаёт коды восстановления
+func (api *API) ConfirmTOTP(response http.ResponseWriter, request *http.Request) {
+	principal, err := getPrincipalFromRequest(request)
+	if err != nil {
+		api.logger.Errorf("api second factor, verify, get principal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var data confirmTOTPData
+	if request.Header.Get("Content-Type") != "application/json" || json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data) != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	codes, err := api.authService.ConfirmTOTP(request.Context(), principal, data.Code)
+	if err != nil {
+		api.writeSecondFactorError(response, "verify", err)
+		return
+	}
+	api.writeNoStoreJSON(response, "verify", recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (api *API) DisableTOTP(response http.ResponseWriter, request *http.Request) {
+	principal, err := getPrincipalFromRequest(request)
+	if err != nil {
+		api.logger.Errorf("api second factor, disable, get principal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var data disableTOTPData
+	if request.Header.Get("Content-Type") != "application/json" || json.NewDecoder(io.LimitReader(request.Body, 4096)).Decode(&data) != nil {
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = api.authService.DisableTOTP(request.Context(), principal, data.Password, data.Code)
+	if err != nil {
+		api.writeSecondFactorError(response, "disable", err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// writeSecondFactorError отвечает на ошибки действий, подтверждаемых вторым фактором.
+// 403 с кодом ошибки в теле отличает отсутствующий код от неверного.
+func (api *API) writeSecondFactorError(response http.ResponseWriter, action string, err error) {
+	var lockedErr *ports.LoginLockedError
+	switch {
+	case errors.As(err, &lockedErr):
+		api.logger.Debugf("api second factor, %s, locked: %v", action, err)
+		writeLoginLocked(response, lockedErr)
+	case errors.Is(err, ports.ErrSecondFactorRequired):
+		api.logger.Debugf("api second factor, %s, service response: %v", action, err)
+		api.writeSecondFactorRejected(response, "second_factor_required")
+	case errors.Is(err, ports.ErrInvalidSecondFactor):
+		api.logger.Debugf("api second factor, %s, service response: %v", action, err)
+		api.writeSecondFactorRejected(response, "invalid_second_factor")
+	case errors.Is(err, ports.ErrInvalidPassword), errors.Is(err, ports.ErrEmptyPassword):
+		api.logger.Debugf("api second factor, %s, service response: %v", action, err)
+		response.WriteHeader(http.StatusForbidden)
+	case errors.Is(err, ports.ErrSecondFactorAlreadyEnabled), errors.Is(err, ports.ErrSecondFactorNotEnabled):
+		api.logger.Debugf("api second factor, %s, service response: %v", action, err)
+		response.WriteHeader(http.StatusConflict)
+	case errors.Is(err, ports.ErrUserNotFound):
+		response.WriteHeader(http.StatusUnauthorized)
+	default:
+		api.logger.Errorf("api second factor, %s, service response: %v", action, err)
+		response.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (api *API) writeSecondFactorRejected(response http.ResponseWriter, code string) {
+	data, err := json.Marshal(secondFactorErrorResponse{Error: code})
+	if err != nil {
+		api.logger.Errorf("api second factor, rejected, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusForbidden)
+	response.Write(data)
+}
+
+// writeMFARequired - ответ на верный пароль при включённом втором факторе, токены выдаст LoginSecondFactor
+func (api *API) writeMFARequired(response http.ResponseWriter, mfaErr *ports.MFARequiredError) {
+	data, err := json.Marshal(mfaRequiredResponse{
+		Error:     "mfa_required",
+		MFAToken:  mfaErr.Token,
+		ExpiresIn: int64(time.Until(mfaErr.ExpiresAt).Seconds()),
+	})
+	if err != nil {
+		api.logger.Errorf("api auth, mfa required, marshal: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(http.StatusUnauthorized)
+	response.Write(data)
+}
+
+// writeNoStoreJSON - для ответов с секретами, которые не должны оседать в кешах
+func (api *API) writeNoStoreJSON(response http.ResponseWriter, action string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		api.logger.Errorf("api second factor, %s, marshal: %v", action, err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.Write(data)
+}
@@ -0,0 +1,147 @@
+//go:build integration || integration_api
+// +build integration integration_api
+
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/test/testdb"
+	"github.com/stretchr/testify/require"
+)
+
+// testTOTPCode - код RFC 6238 для текущего времени
+func testTOTPCode(t *testing.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1_000_000)
+}
+
+func TestSecondFactor(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+	client := RegisterTestUser(t, testServer, testServer.URL, "svirex")
+
+	// без текущего пароля секрет не выдаётся
+	resp, err := client.Post(testServer.URL+"/api/user/2fa/enroll", "application/json", strings.NewReader(`{"password": "wrong"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = client.Post(testServer.URL+"/api/user/2fa/enroll", "application/json", strings.NewReader(`{"password": "Gopher-Mart-Loyal-42"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URL    string `json:"otpauth_url"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&enrollment))
+	resp.Body.Close()
+	require.True(t, strings.HasPrefix(enrollment.URL, "otpauth://totp/"))
+
+	resp, err = client.Post(testServer.URL+"/api/user/2fa/verify", "application/json", strings.NewReader(`{"code": "abc"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = client.Post(testServer.URL+"/api/user/2fa/verify", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"code": "%s"}`, testTOTPCode(t, enrollment.Secret))))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var recovery struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&recovery))
+	resp.Body.Close()
+	require.Len(t, recovery.RecoveryCodes, 10)
+
+	// при пустом балансе код не запрашивается и не тратится
+	withdraw := `{"order": "2377225624", "sum": 751}`
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/api/user/balance/withdraw", strings.NewReader(withdraw))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-OTP", recovery.RecoveryCodes[0])
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+
+	_, err = testdb.GetPool().Exec(context.Background(), "UPDATE balance SET current=1000;")
+	require.NoError(t, err)
+
+	// крупное списание без кода не проходит
+	resp, err = client.Post(testServer.URL+"/api/user/balance/withdraw", "application/json", strings.NewReader(withdraw))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	var rejected struct {
+		Error string `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rejected))
+	resp.Body.Close()
+	require.Equal(t, "second_factor_required", rejected.Error)
+
+	req, err = http.NewRequest(http.MethodPost, testServer.URL+"/api/user/balance/withdraw", strings.NewReader(withdraw))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-OTP", recovery.RecoveryCodes[0])
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	login := `{"login": "svirex", "password": "Gopher-Mart-Loyal-42"}`
+	resp, err = http.Post(testServer.URL+"/api/user/login", "application/json", strings.NewReader(login))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Empty(t, resp.Cookies())
+	var mfa struct {
+		Error    string `json:"error"`
+		MFAToken string `json:"mfa_token"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&mfa))
+	resp.Body.Close()
+	require.Equal(t, "mfa_required", mfa.Error)
+
+	resp, err = http.Post(testServer.URL+"/api/user/login/2fa", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"mfa_token": "%s", "code": "%s"}`, mfa.MFAToken, recovery.RecoveryCodes[0])))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = http.Post(testServer.URL+"/api/user/login/2fa", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"mfa_token": "%s", "code": "%s"}`, mfa.MFAToken, recovery.RecoveryCodes[1])))
+	require.NoError(t, err)
+	var tokens tokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEmpty(t, tokens.AccessToken)
+
+	resp, err = client.Post(testServer.URL+"/api/user/2fa/disable", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"password": "Gopher-Mart-Loyal-42", "code": "%s"}`, recovery.RecoveryCodes[2])))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Post(testServer.URL+"/api/user/login", "application/json", strings.NewReader(login))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
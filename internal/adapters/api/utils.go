@@ -2,7 +2,10 @@ package api
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 )
 
 func getUIDFromRequest(request *http.Request) (int64, error) {
@@ -12,3 +15,21 @@ func getUIDFromRequest(request *http.Request) (int64, error) {
 	}
 	return uid, nil
 }
+
+func getPrincipalFromRequest(request *http.Request) (*domain.Principal, error) {
+	principal, ok := request.Context().Value(JWTKey("principal")).(*domain.Principal)
+	if !ok {
+		return nil, fmt.Errorf("get principal from request context")
+	}
+	return principal, nil
+}
+
+// clientIP - адрес клиента для ограничения попыток входа. За прокси RemoteAddr
+// подменяется из X-Forwarded-For middleware.RealIP, если это включено в настройках.
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
@@ -3,11 +3,14 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/go-chi/chi"
 )
 
 func (api *API) Withdraw(response http.ResponseWriter, request *http.Request) {
@@ -39,9 +42,9 @@ func (api *API) Withdraw(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	err = api.withdrawService.Withdraw(request.Context(), uid, data)
+	err = api.withdrawService.Withdraw(request.Context(), uid, data, request.Header.Get(secondFactorHeader))
 	if err != nil {
-		if errors.Is(err, ports.ErrInvalidOrderNum) || errors.Is(err, ports.ErrDuplicateOrderNumber) {
+		if errors.Is(err, ports.ErrInvalidOrderNum) || errors.Is(err, ports.ErrDuplicateOrderNumber) || errors.Is(err, ports.ErrSumNotPositive) {
 			response.WriteHeader(http.StatusUnprocessableEntity)
 			return
 		}
@@ -49,6 +52,10 @@ func (api *API) Withdraw(response http.ResponseWriter, request *http.Request) {
 			response.WriteHeader(http.StatusPaymentRequired)
 			return
 		}
+		if errors.Is(err, ports.ErrSecondFactorRequired) || errors.Is(err, ports.ErrInvalidSecondFactor) || errors.Is(err, ports.ErrTooManyLoginAttempts) {
+			api.writeSecondFactorError(response, "withdraw", err)
+			return
+		}
 		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -61,17 +68,24 @@ func (api *API) GetWithdrawals(response http.ResponseWriter, request *http.Reque
 		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	data, err := api.withdrawService.GetWithdrawals(request.Context(), uid)
+	query, err := parseWithdrawalsQuery(request.URL.Query())
+	if err != nil {
+		api.logger.Debugf("api withdraw, get withdrawals, parse query: %v", err)
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	page, err := api.withdrawService.GetWithdrawals(request.Context(), uid, *query)
 	if err != nil {
 		api.logger.Debugf("api withdraw, get withdrawals, service response: %v", err)
 		response.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if len(data) == 0 {
+	setNextCursor(response, page.Next)
+	if len(page.Withdrawals) == 0 {
 		response.WriteHeader(http.StatusNoContent)
 		return
 	}
-	body, err := json.Marshal(data)
+	body, err := json.Marshal(page.Withdrawals)
 	if err != nil {
 		api.logger.Debugf("api withdraw, get withdrawals, service response: %v", err)
 		response.WriteHeader(http.StatusInternalServerError)
@@ -80,3 +94,62 @@ func (api *API) GetWithdrawals(response http.ResponseWriter, request *http.Reque
 	response.Header().Set("Content-Type", "application/json")
 	response.Write(body)
 }
+
+func (api *API) CancelWithdrawal(response http.ResponseWriter, request *http.Request) {
+	uid, err := getUIDFromRequest(request)
+	if err != nil {
+		api.logger.Debugf("api withdraw, cancel withdrawal, get uid: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = api.withdrawService.Cancel(request.Context(), uid, chi.URLParam(request, "order"))
+	if err != nil {
+		if errors.Is(err, ports.ErrWithdrawalNotFound) {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, ports.ErrWithdrawalNotCancellable) {
+			response.WriteHeader(http.StatusConflict)
+			return
+		}
+		api.logger.Errorf("api withdraw, cancel withdrawal, service response: %v", err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusOK)
+}
+
+// parseWithdrawalsQuery дополняет общие параметры списка границами суммы min_sum и max_sum
+func parseWithdrawalsQuery(values url.Values) (*domain.WithdrawalsQuery, error) {
+	page, err := parsePageQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	query := &domain.WithdrawalsQuery{
+		From:  page.From,
+		To:    page.To,
+		After: page.After,
+		Limit: page.Limit,
+	}
+	if query.MinSum, err = parseSumParam(values.Get("min_sum")); err != nil {
+		return nil, err
+	}
+	if query.MaxSum, err = parseSumParam(values.Get("max_sum")); err != nil {
+		return nil, err
+	}
+	if query.MinSum > 0 && query.MaxSum > 0 && query.MinSum > query.MaxSum {
+		return nil, fmt.Errorf("%w: min_sum %v is greater than max_sum %v", errInvalidPageQuery, query.MinSum, query.MaxSum)
+	}
+	return query, nil
+}
+
+func parseSumParam(v string) (domain.Money, error) {
+	if v == "" {
+		return 0, nil
+	}
+	sum, err := domain.ParseMoney(v)
+	if err != nil || sum <= 0 {
+		return 0, fmt.Errorf("%w: sum %q", errInvalidPageQuery, v)
+	}
+	return sum, nil
+}
@@ -4,7 +4,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -12,6 +14,7 @@ import (
 	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/test/testdb"
 	"github.com/stretchr/testify/require"
 )
 
@@ -60,11 +63,13 @@ func TestWithdrawDuplicate(t *testing.T) {
 	testServer := NewTestServer(t)
 
 	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+	_, err := testdb.GetPool().Exec(context.Background(), "UPDATE balance SET current=100;")
+	require.NoError(t, err)
 
 	body := `
 	{
 		"order": "67",
-		"sum": 0
+		"sum": 1
 	}
 	`
 
@@ -87,6 +92,31 @@ func TestWithdrawDuplicate(t *testing.T) {
 	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
 }
 
+func TestWithdrawSumNotPositive(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+	_, err := testdb.GetPool().Exec(context.Background(), "UPDATE balance SET current=100;")
+	require.NoError(t, err)
+
+	// сумма меньше копейки округляется до нуля
+	for _, sum := range []string{"-5", "0", "0.001"} {
+		body := fmt.Sprintf(`{"order": "67", "sum": %s}`, sum)
+		resp, err := client.Post(testServer.URL+"/api/user/balance/withdraw", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, sum)
+	}
+
+	// номер не занят отклонёнными списаниями
+	resp, err := client.Post(testServer.URL+"/api/user/balance/withdraw", "application/json", strings.NewReader(`{"order": "67", "sum": 1}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
 func TestWithdrawNotEnoughMoney(t *testing.T) {
 	defer setupTest(t)()
 
@@ -170,11 +200,13 @@ func TestGetWithdrawalsGood(t *testing.T) {
 	testServer := NewTestServer(t)
 
 	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+	_, err := testdb.GetPool().Exec(context.Background(), "UPDATE balance SET current=100;")
+	require.NoError(t, err)
 
 	body := `
 	{
 		"order": "67",
-		"sum": 0
+		"sum": 1
 	}
 	`
 
@@ -190,7 +222,7 @@ func TestGetWithdrawalsGood(t *testing.T) {
 	body = `
 	{
 		"order": "18",
-		"sum": 0
+		"sum": 2
 	}
 	`
 
@@ -221,3 +253,78 @@ func TestGetWithdrawalsGood(t *testing.T) {
 	resp.Body.Close()
 
 }
+
+func TestCancelWithdrawalNotFound(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+
+	req, err := http.NewRequest(http.MethodDelete, testServer.URL+"/api/user/withdrawals/2377225624", nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGetWithdrawalsPagination(t *testing.T) {
+	defer setupTest(t)()
+
+	testServer := NewTestServer(t)
+
+	client := RegisterTestUser(t, testServer, testServer.URL, "test")
+	_, err := testdb.GetPool().Exec(context.Background(), "UPDATE balance SET current=100;")
+	require.NoError(t, err)
+
+	for _, body := range []string{`{"order": "67", "sum": 5}`, `{"order": "26", "sum": 20}`, `{"order": "18", "sum": 50}`} {
+		resp, err := client.Post(testServer.URL+"/api/user/balance/withdraw", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	getWithdrawals := func(query string) (*http.Response, []domain.WithdrawData) {
+		resp, err := client.Get(testServer.URL + "/api/user/withdrawals" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var data []domain.WithdrawData
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&data))
+		}
+		return resp, data
+	}
+
+	resp, data := getWithdrawals("?limit=2")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, data, 2)
+	require.Equal(t, "18", data[0].OrderNum)
+	cursor := resp.Header.Get("X-Next-Cursor")
+	require.NotEmpty(t, cursor)
+
+	resp, data = getWithdrawals("?limit=2&after=" + cursor)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, data, 1)
+	require.Equal(t, "67", data[0].OrderNum)
+	require.Empty(t, resp.Header.Get("X-Next-Cursor"))
+
+	resp, data = getWithdrawals("?min_sum=10&max_sum=20.00")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, data, 1)
+	require.Equal(t, "26", data[0].OrderNum)
+
+	resp, data = getWithdrawals("")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, data, 3)
+
+	resp, _ = getWithdrawals("?to=2000-01-01")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	for _, query := range []string{"?min_sum=-1", "?max_sum=abc", "?min_sum=30&max_sum=20", "?limit=-5"} {
+		resp, _ = getWithdrawals(query)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
@@ -0,0 +1,279 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/common"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AccrualRepository struct {
+	db     *pgxpool.Pool
+	logger common.Logger
+}
+
+func NewAccrualRepository(db *pgxpool.Pool, logger common.Logger) *AccrualRepository {
+	return &AccrualRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+var _ ports.AccrualRepository = (*AccrualRepository)(nil)
+
+func (repo *AccrualRepository) ClaimJobs(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.AccrualJob, error) {
+	rows, _ := repo.db.Query(ctx, `UPDATE accrual_jobs SET locked_by=$1, locked_until=NOW() + $3 * INTERVAL '1 millisecond', attempts=attempts+1
+		WHERE order_num IN (
+			SELECT order_num FROM accrual_jobs
+			WHERE next_attempt_at<=NOW() AND (locked_until IS NULL OR locked_until<NOW())
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING order_num, attempts, created_at;`, owner, limit, lease.Milliseconds())
+	jobs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.AccrualJob, error) {
+		job := domain.AccrualJob{}
+		err := row.Scan(&job.OrderNum, &job.Attempts, &job.CreatedAt)
+		return job, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("accrual repo, claim jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func (repo *AccrualRepository) RescheduleJob(ctx context.Context, orderNum string, owner string, delay time.Duration) error {
+	_, err := repo.db.Exec(ctx, `UPDATE accrual_jobs SET locked_by=NULL, locked_until=NULL, next_attempt_at=NOW() + $3 * INTERVAL '1 millisecond'
+		WHERE order_num=$1 AND locked_by=$2;`, orderNum, owner, delay.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("accrual repo, reschedule job: %w", err)
+	}
+	return nil
+}
+
+func (repo *AccrualRepository) PostponeJob(ctx context.Context, orderNum string, owner string, delay time.Duration) error {
+	_, err := repo.db.Exec(ctx, `UPDATE accrual_jobs SET locked_by=NULL, locked_until=NULL, next_attempt_at=NOW() + $3 * INTERVAL '1 millisecond',
+		attempts=GREATEST(attempts-1, 0)
+		WHERE order_num=$1 AND locked_by=$2;`, orderNum, owner, delay.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("accrual repo, postpone job: %w", err)
+	}
+	return nil
+}
+
+func (repo *AccrualRepository) ReleaseJobs(ctx context.Context, owner string) error {
+	_, err := repo.db.Exec(ctx, "UPDATE accrual_jobs SET locked_by=NULL, locked_until=NULL WHERE locked_by=$1;", owner)
+	if err != nil {
+		return fmt.Errorf("accrual repo, release jobs: %w", err)
+	}
+	return nil
+}
+
+func (repo *AccrualRepository) DeferJobs(ctx context.Context, orderNums []string, delay time.Duration) error {
+	_, err := repo.db.Exec(ctx, `UPDATE accrual_jobs SET next_attempt_at=GREATEST(next_attempt_at, NOW() + $2 * INTERVAL '1 millisecond')
+		WHERE order_num=ANY($1) AND (locked_until IS NULL OR locked_until<NOW());`, orderNums, delay.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("accrual repo, defer jobs: %w", err)
+	}
+	return nil
+}
+
+func (repo *AccrualRepository) GetOrderStatus(ctx context.Context, orderNum string) (domain.Status, error) {
+	var status domain.Status
+	err := repo.db.QueryRow(ctx, "SELECT status FROM orders WHERE order_num=$1;", orderNum).Scan(&status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("%w: accrual repo, get order status %s", ports.ErrOrderNotFound, orderNum)
+		}
+		return "", fmt.Errorf("accrual repo, get order status: %w", err)
+	}
+	return status, nil
+}
+
+func (repo *AccrualRepository) FailJob(ctx context.Context, transition *domain.StatusTransition, owner string) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("accrual repo, fail job, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	tag, err := trx.Exec(ctx, "DELETE FROM accrual_jobs WHERE order_num=$1 AND locked_by=$2;", transition.OrderNum, owner)
+	if err != nil {
+		return fmt.Errorf("accrual repo, fail job, delete job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		// аренду перехватил другой экземпляр
+		return nil
+	}
+	if _, err = changeStatus(ctx, trx, transition); err != nil {
+		return fmt.Errorf("accrual repo, fail job: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("accrual repo, fail job, commit: %w", err)
+	}
+	return nil
+}
+
+func (repo *AccrualRepository) GetFailedOrders(ctx context.Context) ([]domain.Order, error) {
+	rows, _ := repo.db.Query(ctx, "SELECT order_num, status, accrual, uploaded_at FROM orders WHERE status='FAILED_CHECK' ORDER BY uploaded_at;")
+	orders, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Order, error) {
+		order := domain.Order{}
+		err := row.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+		return order, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("accrual repo, get failed orders: %w", err)
+	}
+	return orders, nil
+}
+
+func (repo *AccrualRepository) RequeueFailed(ctx context.Context, transition *domain.StatusTransition) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("accrual repo, requeue failed, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	if _, err = changeStatus(ctx, trx, transition); err != nil {
+		return fmt.Errorf("accrual repo, requeue failed: %w", err)
+	}
+	_, err = trx.Exec(ctx, "INSERT INTO accrual_jobs (order_num) VALUES ($1) ON CONFLICT DO NOTHING;", transition.OrderNum)
+	if err != nil {
+		return fmt.Errorf("accrual repo, requeue failed, insert job: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("accrual repo, requeue failed, commit: %w", err)
+	}
+	return nil
+}
+
+func (repo *AccrualRepository) WriteInvalid(ctx context.Context, transition *domain.StatusTransition) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("accrual repo, write invalid, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	if _, err = changeStatus(ctx, trx, transition); err != nil {
+		return fmt.Errorf("accrual repo, write invalid: %w", err)
+	}
+	if err = deleteJob(ctx, trx, transition.OrderNum); err != nil {
+		return fmt.Errorf("accrual repo, write invalid: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("accrual repo, write invalid, commit: %w", err)
+	}
+	return nil
+}
+
+func (repo *AccrualRepository) WriteProcessing(ctx context.Context, transition *domain.StatusTransition) error {
+	if _, err := changeStatus(ctx, repo.db, transition); err != nil {
+		return fmt.Errorf("accrual repo, write processing: %w", err)
+	}
+	return nil
+}
+
+func (repo *AccrualRepository) WriteProcessed(ctx context.Context, transition *domain.StatusTransition) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("accrual repo, write processed, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+
+	orderNum, accrual := transition.OrderNum, transition.Accrual
+	uid, err := changeStatus(ctx, trx, transition)
+	if err != nil {
+		return fmt.Errorf("accrual repo, write processed: %w", err)
+	}
+	if err = deleteJob(ctx, trx, orderNum); err != nil {
+		return fmt.Errorf("accrual repo, write processed: %w", err)
+	}
+	var frozen bool
+	err = trx.QueryRow(ctx, "SELECT frozen_at IS NOT NULL FROM balance WHERE uid=$1 FOR UPDATE;", uid).Scan(&frozen)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("accrual repo, write processed, select balance: %w", err)
+	}
+	if frozen {
+		// пользователь удалён, заказ закрывается без начисления и события
+		repo.logger.Infof("accrual repo, write processed, balance of uid %d is frozen, order %s is not credited", uid, orderNum)
+		return trx.Commit(ctx)
+	}
+	if accrual != 0 {
+		tag, err := trx.Exec(ctx, "INSERT INTO ledger (uid, order_num, kind, amount) VALUES ($1, $2, 'ACCRUAL', $3) ON CONFLICT (order_num) WHERE kind='ACCRUAL' DO NOTHING;", uid, orderNum, accrual)
+		if err != nil {
+			return fmt.Errorf("accrual repo, write processed, insert ledger posting: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			// начисление по этому заказу уже было проведено
+			return trx.Commit(ctx)
+		}
+		_, err = trx.Exec(ctx, "UPDATE balance SET current=current+$1 WHERE uid=$2;", accrual, uid)
+		if err != nil {
+			return fmt.Errorf("accrual repo, write processed, update balance: %w", err)
+		}
+	}
+	payload, err := json.Marshal(&domain.AccrualCredited{
+		OrderNum: orderNum,
+		UID:      uid,
+		Accrual:  accrual,
+	})
+	if err != nil {
+		return fmt.Errorf("accrual repo, write processed, marshal event: %w", err)
+	}
+	_, err = trx.Exec(ctx, "INSERT INTO outbox (event_type, aggregate_id, payload) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;", domain.AccrualCreditedEvent, orderNum, payload)
+	if err != nil {
+		return fmt.Errorf("accrual repo, write processed, insert outbox event: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("accrual repo, write processed, commit: %w", err)
+	}
+	return nil
+}
+
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// changeStatus переводит заказ из transition.From в transition.To и пишет переход в историю одним запросом,
+// возвращает владельца заказа. Накопленное начисление меняется только при переходе в PROCESSED.
+func changeStatus(ctx context.Context, db queryRower, transition *domain.StatusTransition) (int64, error) {
+	var accrual *domain.Money
+	if transition.To == domain.Processed {
+		accrual = &transition.Accrual
+	}
+	var source *domain.StatusSource
+	if transition.Source != "" {
+		source = &transition.Source
+	}
+	var uid int64
+	err := db.QueryRow(ctx, `WITH updated AS (
+		UPDATE orders SET status=$3, accrual=COALESCE($4::NUMERIC, accrual) WHERE order_num=$1 AND status=$2 RETURNING order_num, uid
+	), history AS (
+		INSERT INTO order_status_history (order_num, status, accrual, source, raw_response)
+		SELECT order_num, $3, $4::NUMERIC, $5::STATUS_SOURCE, $6::JSONB FROM updated
+	) SELECT uid FROM updated;`,
+		transition.OrderNum, transition.From, transition.To, accrual, source, transition.RawResponse).Scan(&uid)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, fmt.Errorf("%w: change status %s, %s -> %s", ports.ErrStatusChanged, transition.OrderNum, transition.From, transition.To)
+		}
+		return 0, fmt.Errorf("change status: %w", err)
+	}
+	return uid, nil
+}
+
+func deleteJob(ctx context.Context, trx pgx.Tx, orderNum string) error {
+	_, err := trx.Exec(ctx, "DELETE FROM accrual_jobs WHERE order_num=$1;", orderNum)
+	if err != nil {
+		return fmt.Errorf("delete job: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Svirex/gofermart-loyality/internal/common"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AdminRepository struct {
+	db     *pgxpool.Pool
+	logger common.Logger
+}
+
+func NewAdminRepository(db *pgxpool.Pool, logger common.Logger) *AdminRepository {
+	return &AdminRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+var _ ports.AdminRepository = (*AdminRepository)(nil)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (repo *AdminRepository) SearchUsers(ctx context.Context, query string, limit int) ([]domain.UserInfo, error) {
+	rows, _ := repo.db.Query(ctx, `SELECT id, COALESCE(login, ''), role, deleted_at IS NOT NULL FROM users
+		WHERE $1 = '' OR login ILIKE '%' || $1 || '%'
+		ORDER BY id DESC LIMIT $2;`, likeEscaper.Replace(query), limit)
+	users, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.UserInfo, error) {
+		var user domain.UserInfo
+		err := row.Scan(&user.ID, &user.Login, &user.Role, &user.Deleted)
+		return user, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("admin repo, search users: %w", err)
+	}
+	return users, nil
+}
+
+func (repo *AdminRepository) GetUser(ctx context.Context, uid int64) (*domain.UserInfo, error) {
+	user := &domain.UserInfo{}
+	err := repo.db.QueryRow(ctx, "SELECT id, COALESCE(login, ''), role, deleted_at IS NOT NULL FROM users WHERE id=$1;", uid).
+		Scan(&user.ID, &user.Login, &user.Role, &user.Deleted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: admin repo, get user, uid %d", ports.ErrUserNotFound, uid)
+		}
+		return nil, fmt.Errorf("admin repo, get user: %w", err)
+	}
+	return user, nil
+}
+
+func (repo *AdminRepository) SetRole(ctx context.Context, uid int64, role domain.Role, entry *domain.AuditEntry) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("admin repo, set role, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	tag, err := trx.Exec(ctx, "UPDATE users SET role=$2 WHERE id=$1 AND deleted_at IS NULL;", uid, role)
+	if err != nil {
+		return fmt.Errorf("admin repo, set role, update: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: admin repo, set role, uid %d", ports.ErrUserNotFound, uid)
+	}
+	if err = insertAuditEntry(ctx, trx, entry); err != nil {
+		return fmt.Errorf("admin repo, set role: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("admin repo, set role, commit: %w", err)
+	}
+	return nil
+}
+
+// AdjustBalance не трогает замороженный баланс удалённого пользователя
+func (repo *AdminRepository) AdjustBalance(ctx context.Context, uid int64, amount domain.Money, entry *domain.AuditEntry) (*domain.Balance, error) {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("admin repo, adjust balance, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	balance := &domain.Balance{}
+	err = trx.QueryRow(ctx, "UPDATE balance SET current=current+$2 WHERE uid=$1 AND frozen_at IS NULL RETURNING current, withdrawn;", uid, amount).
+		Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+			return nil, fmt.Errorf("%w: admin repo, adjust balance, update balance: %v", ports.ErrNotEnoughMoney, err)
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: admin repo, adjust balance, uid %d", ports.ErrUserNotFound, uid)
+		}
+		return nil, fmt.Errorf("admin repo, adjust balance, update balance: %w", err)
+	}
+	_, err = trx.Exec(ctx, "INSERT INTO ledger (uid, kind, amount) VALUES ($1, 'ADJUSTMENT', $2);", uid, amount)
+	if err != nil {
+		return nil, fmt.Errorf("admin repo, adjust balance, insert ledger posting: %w", err)
+	}
+	if err = insertAuditEntry(ctx, trx, entry); err != nil {
+		return nil, fmt.Errorf("admin repo, adjust balance: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("admin repo, adjust balance, commit: %w", err)
+	}
+	return balance, nil
+}
+
+func (repo *AdminRepository) GetAuditLog(ctx context.Context, targetUID int64, limit int) ([]domain.AuditEntry, error) {
+	rows, _ := repo.db.Query(ctx, `SELECT id, COALESCE(actor_uid, 0), action, target_uid, reason, details, created_at FROM audit_log
+		WHERE $1 = 0 OR target_uid = $1
+		ORDER BY created_at DESC, id DESC LIMIT $2;`, targetUID, limit)
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.AuditEntry, error) {
+		var entry domain.AuditEntry
+		err := row.Scan(&entry.ID, &entry.ActorUID, &entry.Action, &entry.TargetUID, &entry.Reason, &entry.Details, &entry.CreatedAt)
+		return entry, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("admin repo, get audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// insertAuditEntry пишет аудит в транзакции изменения, без записи в журнале изменение не применяется
+func insertAuditEntry(ctx context.Context, trx pgx.Tx, entry *domain.AuditEntry) error {
+	var actor *int64
+	if entry.ActorUID != 0 {
+		actor = &entry.ActorUID
+	}
+	err := trx.QueryRow(ctx, `INSERT INTO audit_log (actor_uid, action, target_uid, reason, details)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at;`,
+		actor, entry.Action, entry.TargetUID, entry.Reason, entry.Details).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert audit entry: %w", err)
+	}
+	return nil
+}
@@ -26,8 +26,11 @@ func NewAuthRepository(db *pgxpool.Pool) *AuthRepository {
 var _ ports.AuthRepository = (*AuthRepository)(nil)
 
 func (r *AuthRepository) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	if user.Role == "" {
+		user.Role = domain.RoleUser
+	}
 	var id int64
-	err := r.db.QueryRow(ctx, `INSERT INTO users (login, hash) VALUES ($1, $2) RETURNING id;`, user.Login, user.Hash).Scan(&id)
+	err := r.db.QueryRow(ctx, `INSERT INTO users (login, hash, role) VALUES ($1, $2, $3) RETURNING id;`, user.Login, user.Hash, user.Role).Scan(&id)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
@@ -45,7 +48,7 @@ func (r *AuthRepository) CreateUser(ctx context.Context, user *domain.User) (*do
 
 func (r *AuthRepository) GetUserByLogin(ctx context.Context, login string) (*domain.User, error) {
 	user := &domain.User{}
-	err := r.db.QueryRow(ctx, `SELECT id, login, hash FROM users WHERE login=$1`, login).Scan(&user.ID, &user.Login, &user.Hash)
+	err := r.db.QueryRow(ctx, `SELECT id, login, hash, role FROM users WHERE login=$1`, login).Scan(&user.ID, &user.Login, &user.Hash, &user.Role)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("%w: auth repository, get user by login, user not found: %v", ports.ErrUserNotFound, err)
@@ -54,3 +57,85 @@ func (r *AuthRepository) GetUserByLogin(ctx context.Context, login string) (*dom
 	}
 	return user, nil
 }
+
+func (r *AuthRepository) GetUserByID(ctx context.Context, uid int64) (*domain.User, error) {
+	user := &domain.User{}
+	err := r.db.QueryRow(ctx, `SELECT id, login, hash, role FROM users WHERE id=$1 AND deleted_at IS NULL`, uid).Scan(&user.ID, &user.Login, &user.Hash, &user.Role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: auth repository, get user by id, user not found: %v", ports.ErrUserNotFound, err)
+		}
+		return nil, fmt.Errorf("auth repository, get user by id: %w", err)
+	}
+	return user, nil
+}
+
+func (r *AuthRepository) ChangePassword(ctx context.Context, uid int64, hash string, keepSessionID string) error {
+	trx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("auth repository, change password, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	tag, err := trx.Exec(ctx, "UPDATE users SET hash=$2 WHERE id=$1 AND deleted_at IS NULL;", uid, hash)
+	if err != nil {
+		return fmt.Errorf("auth repository, change password, update hash: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: auth repository, change password, uid %d", ports.ErrUserNotFound, uid)
+	}
+	_, err = trx.Exec(ctx, "UPDATE sessions SET revoked_at=NOW() WHERE uid=$1 AND id<>$2 AND revoked_at IS NULL;", uid, keepSessionID)
+	if err != nil {
+		return fmt.Errorf("auth repository, change password, revoke sessions: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("auth repository, change password, commit: %w", err)
+	}
+	return nil
+}
+
+// UpdatePasswordHash не меняет хеш, если пароль успели сменить или пользователя удалить
+func (r *AuthRepository) UpdatePasswordHash(ctx context.Context, uid int64, oldHash, newHash string) error {
+	_, err := r.db.Exec(ctx, "UPDATE users SET hash=$3 WHERE id=$1 AND hash=$2 AND deleted_at IS NULL;", uid, oldHash, newHash)
+	if err != nil {
+		return fmt.Errorf("auth repository, update password hash: %w", err)
+	}
+	return nil
+}
+
+func (r *AuthRepository) DeleteUser(ctx context.Context, uid int64) error {
+	trx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("auth repository, delete user, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	// NULL в уникальном login не мешает новым регистрациям и другим удалённым пользователям
+	tag, err := trx.Exec(ctx, "UPDATE users SET login=NULL, hash=NULL, deleted_at=NOW() WHERE id=$1 AND deleted_at IS NULL;", uid)
+	if err != nil {
+		return fmt.Errorf("auth repository, delete user, anonymise: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: auth repository, delete user, uid %d", ports.ErrUserNotFound, uid)
+	}
+	_, err = trx.Exec(ctx, "UPDATE balance SET frozen_at=NOW() WHERE uid=$1;", uid)
+	if err != nil {
+		return fmt.Errorf("auth repository, delete user, freeze balance: %w", err)
+	}
+	_, err = trx.Exec(ctx, "UPDATE sessions SET revoked_at=NOW() WHERE uid=$1 AND revoked_at IS NULL;", uid)
+	if err != nil {
+		return fmt.Errorf("auth repository, delete user, revoke sessions: %w", err)
+	}
+	_, err = trx.Exec(ctx, "DELETE FROM recovery_codes WHERE uid=$1;", uid)
+	if err != nil {
+		return fmt.Errorf("auth repository, delete user, delete recovery codes: %w", err)
+	}
+	_, err = trx.Exec(ctx, "DELETE FROM user_totp WHERE uid=$1;", uid)
+	if err != nil {
+		return fmt.Errorf("auth repository, delete user, delete totp: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("auth repository, delete user, commit: %w", err)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/Svirex/gofermart-loyality/internal/common"
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -33,3 +34,20 @@ func (repo *BalanceRepository) GetBalance(ctx context.Context, uid int64) (*doma
 	}
 	return data, nil
 }
+
+func (repo *BalanceRepository) GetHistory(ctx context.Context, uid int64) ([]domain.Posting, error) {
+	rows, _ := repo.db.Query(ctx, `SELECT order_num, kind, amount, balance, created_at FROM (
+		SELECT id, COALESCE(order_num, '') AS order_num, kind, amount, SUM(amount) OVER (ORDER BY created_at, id) AS balance, created_at
+		FROM ledger WHERE uid=$1
+	) statement ORDER BY created_at DESC, id DESC;`, uid)
+	history, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Posting, error) {
+		posting := domain.Posting{}
+		err := row.Scan(&posting.OrderNum, &posting.Kind, &posting.Amount, &posting.Balance, &posting.CreatedAt)
+		return posting, err
+	})
+	if err != nil {
+		repo.logger.Errorf("balance repo, get history: %v", err)
+		return nil, fmt.Errorf("balance repo, get history: %w", err)
+	}
+	return history, nil
+}
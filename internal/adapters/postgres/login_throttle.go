@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/common"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LoginThrottleRepository хранит счётчики в базе, время берётся из базы, чтобы часы экземпляров не расходились
+type LoginThrottleRepository struct {
+	db     *pgxpool.Pool
+	logger common.Logger
+}
+
+func NewLoginThrottleRepository(db *pgxpool.Pool, logger common.Logger) *LoginThrottleRepository {
+	return &LoginThrottleRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+var _ ports.LoginThrottleRepository = (*LoginThrottleRepository)(nil)
+
+func (repo *LoginThrottleRepository) Attempt(ctx context.Context, key domain.ThrottleKey, window time.Duration, lockout func(failures int) time.Duration) (int, time.Duration, error) {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("login throttle repo, attempt, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	// счётчики без ошибок дольше window и без блокировки больше не нужны
+	_, err = trx.Exec(ctx, `DELETE FROM login_attempts
+		WHERE last_failure_at < (NOW() AT TIME ZONE 'UTC') - $1 * INTERVAL '1 millisecond'
+			AND (locked_until IS NULL OR locked_until < NOW() AT TIME ZONE 'UTC');`, window.Milliseconds())
+	if err != nil {
+		return 0, 0, fmt.Errorf("login throttle repo, attempt, delete stale: %w", err)
+	}
+	// строка остаётся заблокированной до конца транзакции, даже если ключ заблокирован и счётчик не меняется
+	var failures int
+	err = trx.QueryRow(ctx, `INSERT INTO login_attempts (scope, subject, failures, last_failure_at)
+		VALUES ($1, $2, 1, NOW() AT TIME ZONE 'UTC')
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures=CASE WHEN login_attempts.last_failure_at < (NOW() AT TIME ZONE 'UTC') - $3 * INTERVAL '1 millisecond'
+				THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at=NOW() AT TIME ZONE 'UTC'
+		WHERE login_attempts.locked_until IS NULL OR login_attempts.locked_until <= NOW() AT TIME ZONE 'UTC'
+		RETURNING failures;`, key.Scope, key.Subject, window.Milliseconds()).Scan(&failures)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var milliseconds int64
+		err = trx.QueryRow(ctx, `SELECT GREATEST(CEIL(EXTRACT(EPOCH FROM locked_until - (NOW() AT TIME ZONE 'UTC')) * 1000), 1)::BIGINT
+			FROM login_attempts WHERE scope=$1 AND subject=$2;`, key.Scope, key.Subject).Scan(&milliseconds)
+		if err != nil {
+			return 0, 0, fmt.Errorf("login throttle repo, attempt, locked for: %w", err)
+		}
+		return 0, time.Duration(milliseconds) * time.Millisecond, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("login throttle repo, attempt, upsert: %w", err)
+	}
+	if duration := lockout(failures); duration > 0 {
+		_, err = trx.Exec(ctx, `UPDATE login_attempts SET locked_until=(NOW() AT TIME ZONE 'UTC') + $3 * INTERVAL '1 millisecond'
+			WHERE scope=$1 AND subject=$2;`, key.Scope, key.Subject, duration.Milliseconds())
+		if err != nil {
+			return 0, 0, fmt.Errorf("login throttle repo, attempt, lock: %w", err)
+		}
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("login throttle repo, attempt, commit: %w", err)
+	}
+	return failures, 0, nil
+}
+
+func (repo *LoginThrottleRepository) Forgive(ctx context.Context, key domain.ThrottleKey, unlock bool) error {
+	_, err := repo.db.Exec(ctx, `UPDATE login_attempts
+		SET failures=GREATEST(failures - 1, 0), locked_until=CASE WHEN $3 THEN NULL ELSE locked_until END
+		WHERE scope=$1 AND subject=$2;`, key.Scope, key.Subject, unlock)
+	if err != nil {
+		return fmt.Errorf("login throttle repo, forgive: %w", err)
+	}
+	return nil
+}
+
+func (repo *LoginThrottleRepository) Reset(ctx context.Context, key domain.ThrottleKey) error {
+	_, err := repo.db.Exec(ctx, "DELETE FROM login_attempts WHERE scope=$1 AND subject=$2;", key.Scope, key.Subject)
+	if err != nil {
+		return fmt.Errorf("login throttle repo, reset: %w", err)
+	}
+	return nil
+}
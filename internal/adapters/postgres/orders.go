@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/common"
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -27,8 +29,13 @@ func NewOrdersRepository(db *pgxpool.Pool, logger common.Logger) *OrdersReposito
 
 var _ ports.OrdersRepository = (*OrdersRepository)(nil)
 
-func (repo *OrdersRepository) CreateOrder(ctx context.Context, uid int64, orderNum string) (*ports.UserOrder, error) {
-	_, err := repo.db.Exec(ctx, "INSERT INTO orders (uid, order_num) VALUES ($1, $2);", uid, orderNum)
+func (repo *OrdersRepository) CreateOrder(ctx context.Context, uid int64, orderNum string, checkDelay time.Duration) (*ports.UserOrder, error) {
+	_, err := repo.db.Exec(ctx, `WITH inserted AS (
+		INSERT INTO orders (uid, order_num) VALUES ($1, $2) RETURNING order_num
+	), history AS (
+		INSERT INTO order_status_history (order_num, status, source) SELECT order_num, 'NEW', 'user' FROM inserted
+	) INSERT INTO accrual_jobs (order_num, next_attempt_at)
+		SELECT order_num, NOW() + $3 * INTERVAL '1 millisecond' FROM inserted;`, uid, orderNum, checkDelay.Milliseconds())
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
@@ -52,25 +59,132 @@ func (repo *OrdersRepository) CreateOrder(ctx context.Context, uid int64, orderN
 	}, nil
 }
 
-func (repo *OrdersRepository) GetOrders(ctx context.Context, uid int64) ([]domain.Order, error) {
-	rows, _ := repo.db.Query(ctx, "SELECT order_num, status, accrual, uploaded_at FROM orders WHERE uid=$1 ORDER BY uploaded_at DESC;", uid)
-	if err := rows.Err(); err != nil {
-		repo.logger.Errorln("orders repo, get orders, select: %v", err)
-		return nil, fmt.Errorf("orders repo, get orders, select: %w", err)
-	}
-	orders := make([]domain.Order, 0)
-	for rows.Next() {
-		if err := rows.Err(); err != nil {
-			repo.logger.Errorln("orders repo, get orders, next row: %v", err)
-			return nil, fmt.Errorf("orders repo, get orders, next row: %w", err)
-		}
-		order := domain.Order{}
-		if err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt); err != nil {
-			repo.logger.Errorln("orders repo, get orders, next row, scan: %v", err)
-			return nil, fmt.Errorf("orders repo, get orders, next row, scan: %w", err)
+func (repo *OrdersRepository) CreateOrders(ctx context.Context, uid int64, orderNums []string, checkDelay time.Duration) (map[string]ports.UserOrder, error) {
+	// основной запрос видит orders до вставки, владельца новых заказов даёт inserted
+	rows, _ := repo.db.Query(ctx, `WITH input AS (
+		SELECT DISTINCT order_num FROM unnest($2::TEXT[]) AS order_num
+	), inserted AS (
+		INSERT INTO orders (uid, order_num) SELECT $1, order_num FROM input
+		ON CONFLICT (order_num) DO NOTHING RETURNING order_num
+	), history AS (
+		INSERT INTO order_status_history (order_num, status, source) SELECT order_num, 'NEW', 'user' FROM inserted
+	), jobs AS (
+		INSERT INTO accrual_jobs (order_num, next_attempt_at)
+		SELECT order_num, NOW() + $3 * INTERVAL '1 millisecond' FROM inserted
+	) SELECT input.order_num, inserted.order_num IS NOT NULL, orders.uid FROM input
+		LEFT JOIN inserted ON inserted.order_num = input.order_num
+		LEFT JOIN orders ON orders.order_num = input.order_num;`, uid, orderNums, checkDelay.Milliseconds())
+	type insertResult struct {
+		orderNum string
+		created  bool
+		ownerUID *int64
+	}
+	results, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (insertResult, error) {
+		var result insertResult
+		err := row.Scan(&result.orderNum, &result.created, &result.ownerUID)
+		return result, err
+	})
+	if err != nil {
+		repo.logger.Errorln("orders repo, create orders, insert: ", err)
+		return nil, fmt.Errorf("orders repo, create orders, insert: %w", err)
+	}
+	userOrders := make(map[string]ports.UserOrder, len(results))
+	var raced []string
+	for _, result := range results {
+		switch {
+		case result.created:
+			userOrders[result.orderNum] = ports.UserOrder{ID: uid, New: true}
+		case result.ownerUID != nil:
+			userOrders[result.orderNum] = ports.UserOrder{ID: *result.ownerUID, New: false}
+		default:
+			// заказ вставлен параллельным запросом уже после снимка
+			raced = append(raced, result.orderNum)
 		}
-		orders = append(orders, order)
+	}
+	if len(raced) == 0 {
+		return userOrders, nil
+	}
+	rows, _ = repo.db.Query(ctx, "SELECT order_num, uid FROM orders WHERE order_num=ANY($1);", raced)
+	owners, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (insertResult, error) {
+		result := insertResult{ownerUID: new(int64)}
+		err := row.Scan(&result.orderNum, result.ownerUID)
+		return result, err
+	})
+	if err != nil {
+		repo.logger.Errorln("orders repo, create orders, select uid: ", err)
+		return nil, fmt.Errorf("orders repo, create orders, select uid: %w", err)
+	}
+	for _, owner := range owners {
+		userOrders[owner.orderNum] = ports.UserOrder{ID: *owner.ownerUID, New: false}
+	}
+	return userOrders, nil
+}
 
+func (repo *OrdersRepository) GetOrders(ctx context.Context, uid int64, query domain.OrdersQuery) ([]domain.Order, error) {
+	var from, to, afterAt *time.Time
+	var afterID int64
+	var limit *int
+	if !query.From.IsZero() {
+		from = &query.From
+	}
+	if !query.To.IsZero() {
+		to = &query.To
+	}
+	if query.After != nil {
+		afterAt, afterID = &query.After.At, query.After.ID
+	}
+	if query.Limit > 0 {
+		limit = &query.Limit
+	}
+	statuses := make([]string, 0, len(query.Statuses))
+	for _, status := range query.Statuses {
+		statuses = append(statuses, string(status))
+	}
+	// LIMIT NULL - без ограничения.
+	// uploaded_at заполняет NOW() в часовом поясе сессии, поэтому from и to переводятся в него же.
+	// Курсор не переводится: его время прочитано из той же колонки.
+	rows, _ := repo.db.Query(ctx, `SELECT id, order_num, status, accrual, uploaded_at FROM orders
+		WHERE uid=$1
+			AND (cardinality($2::TEXT[]) = 0 OR status::TEXT = ANY($2))
+			AND ($3::TIMESTAMPTZ IS NULL OR uploaded_at >= $3::TIMESTAMPTZ::TIMESTAMP)
+			AND ($4::TIMESTAMPTZ IS NULL OR uploaded_at < $4::TIMESTAMPTZ::TIMESTAMP)
+			AND ($5::TIMESTAMP IS NULL OR (uploaded_at, id) < ($5, $6))
+		ORDER BY uploaded_at DESC, id DESC LIMIT $7;`, uid, statuses, from, to, afterAt, afterID, limit)
+	orders, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.Order, error) {
+		var order domain.Order
+		err := row.Scan(&order.ID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+		return order, err
+	})
+	if err != nil {
+		repo.logger.Errorf("orders repo, get orders: %v", err)
+		return nil, fmt.Errorf("orders repo, get orders: %w", err)
 	}
 	return orders, nil
 }
+
+func (repo *OrdersRepository) GetOrder(ctx context.Context, orderNum string) (*domain.Order, error) {
+	order := &domain.Order{}
+	err := repo.db.QueryRow(ctx, "SELECT id, uid, order_num, status, accrual, uploaded_at FROM orders WHERE order_num=$1;", orderNum).
+		Scan(&order.ID, &order.UID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: orders repo, get order %s", ports.ErrOrderNotFound, orderNum)
+		}
+		repo.logger.Errorf("orders repo, get order: %v", err)
+		return nil, fmt.Errorf("orders repo, get order: %w", err)
+	}
+	rows, _ := repo.db.Query(ctx, `SELECT status, accrual, COALESCE(source::TEXT, ''), raw_response, changed_at FROM order_status_history
+		WHERE order_num=$1 ORDER BY changed_at, id;`, orderNum)
+	order.History, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.OrderStatusChange, error) {
+		var change domain.OrderStatusChange
+		var raw []byte
+		err := row.Scan(&change.Status, &change.Accrual, &change.Source, &raw, &change.ChangedAt)
+		change.RawResponse = raw
+		return change, err
+	})
+	if err != nil {
+		repo.logger.Errorf("orders repo, get order, history: %v", err)
+		return nil, fmt.Errorf("orders repo, get order, history: %w", err)
+	}
+	return order, nil
+}
@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Svirex/gofermart-loyality/internal/common"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OutboxRepository struct {
+	db     *pgxpool.Pool
+	logger common.Logger
+}
+
+func NewOutboxRepository(db *pgxpool.Pool, logger common.Logger) *OutboxRepository {
+	return &OutboxRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+var _ ports.OutboxRepository = (*OutboxRepository)(nil)
+
+// GetEvents возвращает события в порядке записи. Потребитель хранит id последнего
+// обработанного события и передаёт его в afterID при следующем чтении.
+func (repo *OutboxRepository) GetEvents(ctx context.Context, afterID int64, limit int) ([]domain.OutboxEvent, error) {
+	rows, _ := repo.db.Query(ctx, "SELECT id, event_type, aggregate_id, payload, created_at FROM outbox WHERE id>$1 ORDER BY id LIMIT $2;", afterID, limit)
+	if err := rows.Err(); err != nil {
+		repo.logger.Errorf("outbox repo, get events, select: %v", err)
+		return nil, fmt.Errorf("outbox repo, get events, select: %w", err)
+	}
+	defer rows.Close()
+	events := make([]domain.OutboxEvent, 0)
+	for rows.Next() {
+		event := domain.OutboxEvent{}
+		if err := rows.Scan(&event.ID, &event.Type, &event.AggregateID, &event.Payload, &event.CreatedAt); err != nil {
+			repo.logger.Errorf("outbox repo, get events, scan: %v", err)
+			return nil, fmt.Errorf("outbox repo, get events, scan: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		repo.logger.Errorf("outbox repo, get events, next row: %v", err)
+		return nil, fmt.Errorf("outbox repo, get events, next row: %w", err)
+	}
+	return events, nil
+}
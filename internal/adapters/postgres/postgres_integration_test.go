@@ -6,7 +6,6 @@ package postgres
 import (
 	"context"
 	"fmt"
-	"math"
 	"os"
 	"testing"
 	"time"
@@ -135,7 +134,7 @@ func TestCreateOrderGood(t *testing.T) {
 
 	repo := NewOrdersTestRepo()
 
-	result, err := repo.CreateOrder(context.Background(), int64(user.ID), "4525634534")
+	result, err := repo.CreateOrder(context.Background(), int64(user.ID), "4525634534", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
@@ -171,14 +170,14 @@ func TestCreateOrderAlreadyExistsSameUser(t *testing.T) {
 
 	repo := NewOrdersTestRepo()
 
-	result, err := repo.CreateOrder(context.Background(), int64(user.ID), "4525634534")
+	result, err := repo.CreateOrder(context.Background(), int64(user.ID), "4525634534", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
 	require.Equal(t, int64(user.ID), result.ID)
 	require.True(t, result.New)
 
-	result, err = repo.CreateOrder(context.Background(), int64(user.ID), "4525634534")
+	result, err = repo.CreateOrder(context.Background(), int64(user.ID), "4525634534", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
@@ -210,11 +209,11 @@ func TestCreateOrdersAlreadyExistsAnotherUser(t *testing.T) {
 
 	repo := NewOrdersTestRepo()
 
-	result, err := repo.CreateOrder(context.Background(), int64(anotherUser.ID), "4525634534")
+	result, err := repo.CreateOrder(context.Background(), int64(anotherUser.ID), "4525634534", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
-	result, err = repo.CreateOrder(context.Background(), int64(user.ID), "4525634534")
+	result, err = repo.CreateOrder(context.Background(), int64(user.ID), "4525634534", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
@@ -225,10 +224,48 @@ func TestCreateOrdersAlreadyExistsAnotherUser(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestCreateOrdersBatch(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user, err := userRepo.CreateUser(context.Background(), &domain.User{Login: "svirex", Hash: string(hash)})
+	require.NoError(t, err)
+	anotherUser, err := userRepo.CreateUser(context.Background(), &domain.User{Login: "another", Hash: string(hash)})
+	require.NoError(t, err)
+
+	repo := NewOrdersTestRepo()
+	_, err = repo.CreateOrder(context.Background(), anotherUser.ID, "4525634534", 0)
+	require.NoError(t, err)
+	_, err = repo.CreateOrder(context.Background(), user.ID, "4525634535", 0)
+	require.NoError(t, err)
+
+	result, err := repo.CreateOrders(context.Background(), user.ID, []string{"4525634534", "4525634535", "4525634536", "4525634536"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]ports.UserOrder{
+		"4525634534": {ID: anotherUser.ID, New: false},
+		"4525634535": {ID: user.ID, New: false},
+		"4525634536": {ID: user.ID, New: true},
+	}, result)
+
+	order, err := repo.GetOrder(context.Background(), "4525634536")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, order.UID)
+	require.Len(t, order.History, 1)
+	require.Equal(t, domain.SourceUser, order.History[0].Source)
+
+	jobs, err := NewTestAccrualRepository().ClaimJobs(context.Background(), "first", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
 func TestGetOrdersNotFound(t *testing.T) {
 	repo := NewOrdersTestRepo()
 
-	orders, err := repo.GetOrders(context.Background(), 1)
+	orders, err := repo.GetOrders(context.Background(), 1, domain.OrdersQuery{})
 	require.NoError(t, err)
 	require.NotNil(t, orders)
 	require.Empty(t, orders)
@@ -251,11 +288,11 @@ func TestGetOrdersOneOrder(t *testing.T) {
 
 	repo := NewOrdersTestRepo()
 
-	result, err := repo.CreateOrder(context.Background(), int64(user.ID), "4525634534")
+	result, err := repo.CreateOrder(context.Background(), int64(user.ID), "4525634534", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
-	orders, err := repo.GetOrders(context.Background(), user.ID)
+	orders, err := repo.GetOrders(context.Background(), user.ID, domain.OrdersQuery{})
 	require.NoError(t, err)
 	require.NotNil(t, orders)
 	require.NotEmpty(t, orders)
@@ -268,6 +305,71 @@ func TestGetOrdersOneOrder(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestGetOrdersQuery(t *testing.T) {
+	userRepo := NewAuthRepo()
+	ctx := context.Background()
+
+	user, err := userRepo.CreateUser(ctx, &domain.User{Login: "svirex", Hash: "hash"})
+	require.NoError(t, err)
+	other, err := userRepo.CreateUser(ctx, &domain.User{Login: "other", Hash: "hash"})
+	require.NoError(t, err)
+
+	repo := NewOrdersTestRepo()
+	for _, num := range []string{"4525634534", "4525634535", "4525634536", "4525634537"} {
+		_, err = repo.CreateOrder(ctx, user.ID, num, 0)
+		require.NoError(t, err)
+	}
+	_, err = repo.CreateOrder(ctx, other.ID, "4525634538", 0)
+	require.NoError(t, err)
+	// у двух заказов одно время, порядок между ними задаёт id
+	_, err = testdb.GetPool().Exec(ctx, `UPDATE orders SET uploaded_at = CASE order_num
+		WHEN '4525634534' THEN TIMESTAMP '2024-01-01 10:00:00'
+		WHEN '4525634535' THEN TIMESTAMP '2024-01-02 10:00:00'
+		WHEN '4525634536' THEN TIMESTAMP '2024-01-02 10:00:00'
+		ELSE TIMESTAMP '2024-01-03 10:00:00' END,
+		status = CASE WHEN order_num IN ('4525634535', '4525634537') THEN 'PROCESSED'::STATUS ELSE status END;`)
+	require.NoError(t, err)
+
+	numbers := func(orders []domain.Order) []string {
+		nums := make([]string, 0, len(orders))
+		for _, o := range orders {
+			nums = append(nums, o.Number)
+		}
+		return nums
+	}
+
+	orders, err := repo.GetOrders(ctx, user.ID, domain.OrdersQuery{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"4525634537", "4525634536"}, numbers(orders))
+
+	after := &domain.Cursor{At: orders[1].UploadedAt, ID: orders[1].ID}
+	orders, err = repo.GetOrders(ctx, user.ID, domain.OrdersQuery{After: after})
+	require.NoError(t, err)
+	require.Equal(t, []string{"4525634535", "4525634534"}, numbers(orders))
+
+	orders, err = repo.GetOrders(ctx, user.ID, domain.OrdersQuery{Statuses: []domain.Status{domain.Processed}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"4525634537", "4525634535"}, numbers(orders))
+
+	orders, err = repo.GetOrders(ctx, user.ID, domain.OrdersQuery{
+		From: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"4525634536", "4525634535"}, numbers(orders))
+
+	// граница - момент времени, часовой пояс time.Time на результат не влияет
+	orders, err = repo.GetOrders(ctx, user.ID, domain.OrdersQuery{
+		From: time.Date(2024, 1, 2, 13, 0, 0, 0, time.FixedZone("MSK", 3*60*60)),
+		To:   time.Date(2024, 1, 3, 3, 0, 0, 0, time.FixedZone("MSK", 3*60*60)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"4525634536", "4525634535"}, numbers(orders))
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
 func TestGetOrdersOrderBy(t *testing.T) {
 	userRepo := NewAuthRepo()
 
@@ -282,19 +384,19 @@ func TestGetOrdersOrderBy(t *testing.T) {
 
 	repo := NewOrdersTestRepo()
 
-	result, err := repo.CreateOrder(context.Background(), int64(user.ID), "4525634534")
+	result, err := repo.CreateOrder(context.Background(), int64(user.ID), "4525634534", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
-	result, err = repo.CreateOrder(context.Background(), int64(user.ID), "4525634535")
+	result, err = repo.CreateOrder(context.Background(), int64(user.ID), "4525634535", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
-	result, err = repo.CreateOrder(context.Background(), int64(user.ID), "4525634536")
+	result, err = repo.CreateOrder(context.Background(), int64(user.ID), "4525634536", 0)
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
-	orders, err := repo.GetOrders(context.Background(), user.ID)
+	orders, err := repo.GetOrders(context.Background(), user.ID, domain.OrdersQuery{})
 	require.NoError(t, err)
 	require.NotNil(t, orders)
 	require.NotEmpty(t, orders)
@@ -330,14 +432,14 @@ func TestBalanceGetBalanceForNewUser(t *testing.T) {
 	data, err := repo.GetBalance(context.Background(), user.ID)
 	require.NoError(t, err)
 
-	require.True(t, math.Abs(0.0-data.Current) < 0.000001)
-	require.True(t, math.Abs(0.0-data.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(0), data.Current)
+	require.Equal(t, domain.Money(0), data.Withdrawn)
 
 	data, err = repo.GetBalance(context.Background(), user.ID)
 	require.NoError(t, err)
 
-	require.True(t, math.Abs(0.0-data.Current) < 0.000001)
-	require.True(t, math.Abs(0.0-data.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(0), data.Current)
+	require.Equal(t, domain.Money(0), data.Withdrawn)
 
 	err = testdb.Truncate()
 	require.NoError(t, err)
@@ -363,7 +465,7 @@ func TestWithdrawNotEnoughForNewUser(t *testing.T) {
 
 	data := &domain.WithdrawData{
 		OrderNum: "2323424",
-		Sum:      500,
+		Sum:      50000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data)
@@ -391,7 +493,7 @@ func TestWithdrawNotEnoughWithNonZeroBalance(t *testing.T) {
 
 	data := &domain.WithdrawData{
 		OrderNum: "2323424",
-		Sum:      500,
+		Sum:      50000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data)
@@ -419,7 +521,7 @@ func TestWithdrawGood(t *testing.T) {
 
 	data := &domain.WithdrawData{
 		OrderNum: "2323424",
-		Sum:      500,
+		Sum:      50000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data)
@@ -429,8 +531,8 @@ func TestWithdrawGood(t *testing.T) {
 
 	d, err := br.GetBalance(context.Background(), user.ID)
 
-	require.True(t, math.Abs(250-d.Current) < 0.000001)
-	require.True(t, math.Abs(500-d.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(25000), d.Current)
+	require.Equal(t, domain.Money(50000), d.Withdrawn)
 
 	err = testdb.Truncate()
 	require.NoError(t, err)
@@ -456,7 +558,7 @@ func TestWithdrawGoodWithdrawn(t *testing.T) {
 
 	data := &domain.WithdrawData{
 		OrderNum: "2323424",
-		Sum:      100,
+		Sum:      10000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data)
@@ -464,12 +566,12 @@ func TestWithdrawGoodWithdrawn(t *testing.T) {
 
 	d, err := br.GetBalance(context.Background(), user.ID)
 
-	require.True(t, math.Abs(650-d.Current) < 0.000001)
-	require.True(t, math.Abs(100-d.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(65000), d.Current)
+	require.Equal(t, domain.Money(10000), d.Withdrawn)
 
 	data1 := &domain.WithdrawData{
 		OrderNum: "23234245",
-		Sum:      50,
+		Sum:      5000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data1)
@@ -477,12 +579,12 @@ func TestWithdrawGoodWithdrawn(t *testing.T) {
 
 	d, err = br.GetBalance(context.Background(), user.ID)
 
-	require.True(t, math.Abs(600-d.Current) < 0.000001)
-	require.True(t, math.Abs(150-d.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(60000), d.Current)
+	require.Equal(t, domain.Money(15000), d.Withdrawn)
 
 	data2 := &domain.WithdrawData{
 		OrderNum: "2323429",
-		Sum:      0.05,
+		Sum:      5,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data2)
@@ -490,8 +592,8 @@ func TestWithdrawGoodWithdrawn(t *testing.T) {
 
 	d, err = br.GetBalance(context.Background(), user.ID)
 	fmt.Println(d)
-	require.True(t, math.Abs(600-0.05-d.Current) < 0.000001)
-	require.True(t, math.Abs(150+0.05-d.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(59995), d.Current)
+	require.Equal(t, domain.Money(15005), d.Withdrawn)
 
 	err = testdb.Truncate()
 	require.NoError(t, err)
@@ -517,7 +619,7 @@ func TestWithdrawNotChangeBalance(t *testing.T) {
 
 	data := &domain.WithdrawData{
 		OrderNum: "2323424",
-		Sum:      1000,
+		Sum:      100000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data)
@@ -525,12 +627,12 @@ func TestWithdrawNotChangeBalance(t *testing.T) {
 
 	d, err := br.GetBalance(context.Background(), user.ID)
 
-	require.True(t, math.Abs(750-d.Current) < 0.000001)
-	require.True(t, math.Abs(0-d.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(75000), d.Current)
+	require.Equal(t, domain.Money(0), d.Withdrawn)
 
 	data1 := &domain.WithdrawData{
 		OrderNum: "2323424",
-		Sum:      50,
+		Sum:      5000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data1)
@@ -538,12 +640,12 @@ func TestWithdrawNotChangeBalance(t *testing.T) {
 
 	d, err = br.GetBalance(context.Background(), user.ID)
 
-	require.True(t, math.Abs(700-d.Current) < 0.000001)
-	require.True(t, math.Abs(50-d.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(70000), d.Current)
+	require.Equal(t, domain.Money(5000), d.Withdrawn)
 
 	data2 := &domain.WithdrawData{
 		OrderNum: "2323424",
-		Sum:      500,
+		Sum:      50000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data2)
@@ -551,8 +653,39 @@ func TestWithdrawNotChangeBalance(t *testing.T) {
 
 	d, err = br.GetBalance(context.Background(), user.ID)
 	fmt.Println(d)
-	require.True(t, math.Abs(700-d.Current) < 0.000001)
-	require.True(t, math.Abs(50-d.Withdrawn) < 0.000001)
+	require.Equal(t, domain.Money(70000), d.Current)
+	require.Equal(t, domain.Money(5000), d.Withdrawn)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestWithdrawCheck(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user, err := userRepo.CreateUser(context.Background(), &domain.User{
+		Login: "svirex",
+		Hash:  string(hash),
+	})
+	require.NoError(t, err)
+
+	_, err = testdb.GetPool().Exec(context.Background(), "UPDATE balance SET current=current+750 WHERE uid=$1;", user.ID)
+	require.NoError(t, err)
+
+	repo := NewTestWithdrawRepository()
+
+	err = repo.CheckWithdraw(context.Background(), user.ID, &domain.WithdrawData{OrderNum: "2323424", Sum: 100000})
+	require.ErrorIs(t, err, ports.ErrNotEnoughMoney)
+	require.NoError(t, repo.CheckWithdraw(context.Background(), user.ID, &domain.WithdrawData{OrderNum: "2323424", Sum: 75000}))
+
+	require.NoError(t, repo.Withdraw(context.Background(), user.ID, &domain.WithdrawData{OrderNum: "2323424", Sum: 5000}))
+	err = repo.CheckWithdraw(context.Background(), user.ID, &domain.WithdrawData{OrderNum: "2323424", Sum: 5000})
+	require.ErrorIs(t, err, ports.ErrDuplicateOrderNumber)
+
+	err = repo.CheckWithdraw(context.Background(), user.ID+1, &domain.WithdrawData{OrderNum: "23234245", Sum: 5000})
+	require.ErrorIs(t, err, ports.ErrNotEnoughMoney)
 
 	err = testdb.Truncate()
 	require.NoError(t, err)
@@ -578,7 +711,7 @@ func TestWithdrawGetWithdrawals(t *testing.T) {
 
 	data := &domain.WithdrawData{
 		OrderNum: "2323424",
-		Sum:      20,
+		Sum:      2000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data)
@@ -586,7 +719,7 @@ func TestWithdrawGetWithdrawals(t *testing.T) {
 
 	data1 := &domain.WithdrawData{
 		OrderNum: "23234245",
-		Sum:      50,
+		Sum:      5000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data1)
@@ -594,25 +727,82 @@ func TestWithdrawGetWithdrawals(t *testing.T) {
 
 	data2 := &domain.WithdrawData{
 		OrderNum: "23234246",
-		Sum:      500,
+		Sum:      50000,
 	}
 
 	err = repo.Withdraw(context.Background(), user.ID, data2)
 	require.NoError(t, err)
 
-	w, err := repo.GetWithdrawals(context.Background(), user.ID)
+	w, err := repo.GetWithdrawals(context.Background(), user.ID, domain.WithdrawalsQuery{})
 	require.NoError(t, err)
 	require.NotNil(t, w)
 	require.Len(t, w, 3)
 
 	require.Equal(t, "23234246", w[0].OrderNum)
-	require.True(t, math.Abs(500-w[0].Sum) < 0.000001)
+	require.Equal(t, domain.Money(50000), w[0].Sum)
 
 	require.Equal(t, "23234245", w[1].OrderNum)
-	require.True(t, math.Abs(50-w[1].Sum) < 0.000001)
+	require.Equal(t, domain.Money(5000), w[1].Sum)
 
 	require.Equal(t, "2323424", w[2].OrderNum)
-	require.True(t, math.Abs(20-w[2].Sum) < 0.000001)
+	require.Equal(t, domain.Money(2000), w[2].Sum)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestWithdrawGetWithdrawalsQuery(t *testing.T) {
+	userRepo := NewAuthRepo()
+	ctx := context.Background()
+
+	user, err := userRepo.CreateUser(ctx, &domain.User{Login: "svirex", Hash: "hash"})
+	require.NoError(t, err)
+	_, err = testdb.GetPool().Exec(ctx, "UPDATE balance SET current=current+1000 WHERE uid=$1;", user.ID)
+	require.NoError(t, err)
+
+	repo := NewTestWithdrawRepository()
+	for _, w := range []*domain.WithdrawData{
+		{OrderNum: "2323424", Sum: 10_00},
+		{OrderNum: "23234245", Sum: 50_00},
+		{OrderNum: "23234246", Sum: 100_00},
+		{OrderNum: "23234247", Sum: 500_00},
+	} {
+		require.NoError(t, repo.Withdraw(ctx, user.ID, w))
+	}
+	_, err = testdb.GetPool().Exec(ctx, `UPDATE withdraws SET processed_at = CASE order_num
+		WHEN '2323424' THEN TIMESTAMP '2024-01-01 10:00:00'
+		WHEN '23234245' THEN TIMESTAMP '2024-01-02 10:00:00'
+		WHEN '23234246' THEN TIMESTAMP '2024-01-02 10:00:00'
+		ELSE TIMESTAMP '2024-01-03 10:00:00' END;`)
+	require.NoError(t, err)
+
+	orderNums := func(data []*domain.WithdrawData) []string {
+		nums := make([]string, 0, len(data))
+		for _, w := range data {
+			nums = append(nums, w.OrderNum)
+		}
+		return nums
+	}
+
+	w, err := repo.GetWithdrawals(ctx, user.ID, domain.WithdrawalsQuery{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"23234247", "23234246"}, orderNums(w))
+
+	// у двух списаний одно время, следующая страница начинается со второго из них
+	w, err = repo.GetWithdrawals(ctx, user.ID, domain.WithdrawalsQuery{After: &domain.Cursor{At: w[1].ProcessedAt, ID: w[1].ID}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"23234245", "2323424"}, orderNums(w))
+
+	w, err = repo.GetWithdrawals(ctx, user.ID, domain.WithdrawalsQuery{MinSum: 50_00, MaxSum: 100_00})
+	require.NoError(t, err)
+	require.Equal(t, []string{"23234246", "23234245"}, orderNums(w))
+
+	w, err = repo.GetWithdrawals(ctx, user.ID, domain.WithdrawalsQuery{
+		From:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		MinSum: 100_00,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"23234247", "23234246"}, orderNums(w))
 
 	err = testdb.Truncate()
 	require.NoError(t, err)
@@ -634,7 +824,7 @@ func TestWithdrawGetWithdrawalsEmpty(t *testing.T) {
 
 	repo := NewTestWithdrawRepository()
 
-	w, err := repo.GetWithdrawals(context.Background(), user.ID)
+	w, err := repo.GetWithdrawals(context.Background(), user.ID, domain.WithdrawalsQuery{})
 	require.NoError(t, err)
 	require.NotNil(t, w)
 	require.Len(t, w, 0)
@@ -642,3 +832,631 @@ func TestWithdrawGetWithdrawalsEmpty(t *testing.T) {
 	err = testdb.Truncate()
 	require.NoError(t, err)
 }
+
+func TestBalanceGetHistory(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &domain.User{
+		Login: "svirex",
+		Hash:  string(hash),
+	}
+	user, err = userRepo.CreateUser(context.Background(), user)
+	require.NoError(t, err)
+
+	repo := NewTestBalanceRepository()
+
+	history, err := repo.GetHistory(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, history)
+	require.Empty(t, history)
+
+	_, err = testdb.GetPool().Exec(context.Background(), "UPDATE balance SET current=current+750 WHERE uid=$1;", user.ID)
+	require.NoError(t, err)
+	_, err = testdb.GetPool().Exec(context.Background(), "INSERT INTO ledger (uid, order_num, kind, amount) VALUES ($1, '4525634534', 'ACCRUAL', 750);", user.ID)
+	require.NoError(t, err)
+
+	withdrawRepo := NewTestWithdrawRepository()
+
+	err = withdrawRepo.Withdraw(context.Background(), user.ID, &domain.WithdrawData{
+		OrderNum: "2323424",
+		Sum:      10000,
+	})
+	require.NoError(t, err)
+
+	history, err = repo.GetHistory(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+
+	require.Equal(t, "2323424", history[0].OrderNum)
+	require.Equal(t, domain.PostingWithdrawal, history[0].Kind)
+	require.Equal(t, domain.Money(-10000), history[0].Amount)
+	require.Equal(t, domain.Money(65000), history[0].Balance)
+
+	require.Equal(t, "4525634534", history[1].OrderNum)
+	require.Equal(t, domain.PostingAccrual, history[1].Kind)
+	require.Equal(t, domain.Money(75000), history[1].Balance)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestWithdrawCancel(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &domain.User{
+		Login: "svirex",
+		Hash:  string(hash),
+	}
+	user, err = userRepo.CreateUser(context.Background(), user)
+	require.NoError(t, err)
+
+	_, err = testdb.GetPool().Exec(context.Background(), "UPDATE balance SET current=current+750 WHERE uid=$1;", user.ID)
+	require.NoError(t, err)
+
+	repo := NewTestWithdrawRepository()
+
+	err = repo.Withdraw(context.Background(), user.ID, &domain.WithdrawData{
+		OrderNum: "2323424",
+		Sum:      10010,
+	})
+	require.NoError(t, err)
+
+	err = repo.Cancel(context.Background(), user.ID, "4525634534", time.Hour)
+	require.ErrorIs(t, err, ports.ErrWithdrawalNotFound)
+
+	err = repo.Cancel(context.Background(), user.ID, "2323424", time.Hour)
+	require.NoError(t, err)
+
+	err = repo.Cancel(context.Background(), user.ID, "2323424", time.Hour)
+	require.ErrorIs(t, err, ports.ErrWithdrawalNotCancellable)
+
+	d, err := NewTestBalanceRepository().GetBalance(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, domain.Money(75000), d.Current)
+	require.Equal(t, domain.Money(0), d.Withdrawn)
+
+	w, err := repo.GetWithdrawals(context.Background(), user.ID, domain.WithdrawalsQuery{})
+	require.NoError(t, err)
+	require.Len(t, w, 1)
+	require.Equal(t, domain.WithdrawCancelled, w[0].Status)
+	require.NotNil(t, w[0].CancelledAt)
+
+	history, err := NewTestBalanceRepository().GetHistory(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, domain.PostingReversal, history[0].Kind)
+	require.Equal(t, domain.Money(10010), history[0].Amount)
+
+	err = repo.Withdraw(context.Background(), user.ID, &domain.WithdrawData{
+		OrderNum: "23234245",
+		Sum:      100,
+	})
+	require.NoError(t, err)
+
+	err = repo.Cancel(context.Background(), user.ID, "23234245", 0)
+	require.ErrorIs(t, err, ports.ErrWithdrawalNotCancellable)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func NewTestAccrualRepository() *AccrualRepository {
+	return NewAccrualRepository(testdb.GetPool(), testdb.GetLogger())
+}
+
+func TestAccrualWriteProcessedIdempotent(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &domain.User{
+		Login: "svirex",
+		Hash:  string(hash),
+	}
+	user, err = userRepo.CreateUser(context.Background(), user)
+	require.NoError(t, err)
+
+	_, err = NewOrdersTestRepo().CreateOrder(context.Background(), user.ID, "4525634534", 0)
+	require.NoError(t, err)
+
+	repo := NewTestAccrualRepository()
+
+	transition := &domain.StatusTransition{OrderNum: "4525634534", From: domain.New, To: domain.Processed, Accrual: 72998, Source: domain.SourcePoller}
+	err = repo.WriteProcessed(context.Background(), transition)
+	require.NoError(t, err)
+	err = repo.WriteProcessed(context.Background(), transition)
+	require.ErrorIs(t, err, ports.ErrStatusChanged)
+
+	_, err = testdb.GetPool().Exec(context.Background(), "UPDATE orders SET status='PROCESSING' WHERE order_num='4525634534';")
+	require.NoError(t, err)
+
+	transition.From = domain.Processing
+	err = repo.WriteProcessed(context.Background(), transition)
+	require.NoError(t, err)
+
+	d, err := NewTestBalanceRepository().GetBalance(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, domain.Money(72998), d.Current)
+
+	events, err := NewOutboxRepository(testdb.GetPool(), testdb.GetLogger()).GetEvents(context.Background(), 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, domain.AccrualCreditedEvent, events[0].Type)
+	require.Equal(t, "4525634534", events[0].AggregateID)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestAccrualWriteProcessedFrozenBalance(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &domain.User{
+		Login: "svirex",
+		Hash:  string(hash),
+	}
+	user, err = userRepo.CreateUser(context.Background(), user)
+	require.NoError(t, err)
+
+	_, err = NewOrdersTestRepo().CreateOrder(context.Background(), user.ID, "4525634534", 0)
+	require.NoError(t, err)
+	require.NoError(t, userRepo.DeleteUser(context.Background(), user.ID))
+
+	repo := NewTestAccrualRepository()
+	err = repo.WriteProcessed(context.Background(), &domain.StatusTransition{OrderNum: "4525634534", From: domain.New, To: domain.Processed, Accrual: 72998, Source: domain.SourceWebhook})
+	require.NoError(t, err)
+
+	d, err := NewTestBalanceRepository().GetBalance(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, domain.Money(0), d.Current)
+
+	events, err := NewOutboxRepository(testdb.GetPool(), testdb.GetLogger()).GetEvents(context.Background(), 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	jobs, err := repo.ClaimJobs(context.Background(), "first", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestAccrualClaimJobsLease(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &domain.User{
+		Login: "svirex",
+		Hash:  string(hash),
+	}
+	user, err = userRepo.CreateUser(context.Background(), user)
+	require.NoError(t, err)
+
+	ordersRepo := NewOrdersTestRepo()
+	_, err = ordersRepo.CreateOrder(context.Background(), user.ID, "4525634534", 0)
+	require.NoError(t, err)
+	_, err = ordersRepo.CreateOrder(context.Background(), user.ID, "4525634535", 0)
+	require.NoError(t, err)
+	// задача заказа, ждущего callback, заводится сразу отложенной
+	_, err = ordersRepo.CreateOrder(context.Background(), user.ID, "4525634536", time.Hour)
+	require.NoError(t, err)
+
+	repo := NewTestAccrualRepository()
+
+	jobs, err := repo.ClaimJobs(context.Background(), "first", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.Equal(t, 1, jobs[0].Attempts)
+
+	jobs, err = repo.ClaimJobs(context.Background(), "second", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	// чужую аренду переназначить нельзя
+	err = repo.RescheduleJob(context.Background(), "4525634534", "second", 0)
+	require.NoError(t, err)
+	jobs, err = repo.ClaimJobs(context.Background(), "second", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	err = repo.RescheduleJob(context.Background(), "4525634534", "first", 0)
+	require.NoError(t, err)
+	jobs, err = repo.ClaimJobs(context.Background(), "second", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, "4525634534", jobs[0].OrderNum)
+	require.Equal(t, 2, jobs[0].Attempts)
+
+	// отказ по лимиту запросов попытку не расходует
+	err = repo.PostponeJob(context.Background(), "4525634534", "second", 0)
+	require.NoError(t, err)
+	jobs, err = repo.ClaimJobs(context.Background(), "second", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, 2, jobs[0].Attempts)
+
+	err = repo.WriteInvalid(context.Background(), &domain.StatusTransition{OrderNum: "4525634534", From: domain.New, To: domain.Invalid, Source: domain.SourcePoller})
+	require.NoError(t, err)
+
+	err = repo.ReleaseJobs(context.Background(), "first")
+	require.NoError(t, err)
+	jobs, err = repo.ClaimJobs(context.Background(), "second", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, "4525634535", jobs[0].OrderNum)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestAccrualFailJobAndRequeue(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &domain.User{
+		Login: "svirex",
+		Hash:  string(hash),
+	}
+	user, err = userRepo.CreateUser(context.Background(), user)
+	require.NoError(t, err)
+
+	_, err = NewOrdersTestRepo().CreateOrder(context.Background(), user.ID, "4525634534", 0)
+	require.NoError(t, err)
+
+	repo := NewTestAccrualRepository()
+
+	jobs, err := repo.ClaimJobs(context.Background(), "first", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	err = repo.FailJob(context.Background(), &domain.StatusTransition{OrderNum: "4525634534", From: domain.New, To: domain.FailedCheck, Source: domain.SourcePoller}, "first")
+	require.NoError(t, err)
+
+	failed, err := repo.GetFailedOrders(context.Background())
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.Equal(t, domain.FailedCheck, failed[0].Status)
+
+	jobs, err = repo.ClaimJobs(context.Background(), "first", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	requeue := &domain.StatusTransition{OrderNum: "4525634534", From: domain.FailedCheck, To: domain.New, Source: domain.SourceAdmin}
+	err = repo.RequeueFailed(context.Background(), requeue)
+	require.NoError(t, err)
+
+	err = repo.RequeueFailed(context.Background(), requeue)
+	require.ErrorIs(t, err, ports.ErrStatusChanged)
+
+	jobs, err = repo.ClaimJobs(context.Background(), "first", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, 1, jobs[0].Attempts)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestGetOrderHistory(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &domain.User{
+		Login: "svirex",
+		Hash:  string(hash),
+	}
+	user, err = userRepo.CreateUser(context.Background(), user)
+	require.NoError(t, err)
+
+	ordersRepo := NewOrdersTestRepo()
+	_, err = ordersRepo.GetOrder(context.Background(), "4525634534")
+	require.ErrorIs(t, err, ports.ErrOrderNotFound)
+
+	_, err = ordersRepo.CreateOrder(context.Background(), user.ID, "4525634534", 0)
+	require.NoError(t, err)
+
+	repo := NewTestAccrualRepository()
+	status, err := repo.GetOrderStatus(context.Background(), "4525634534")
+	require.NoError(t, err)
+	require.Equal(t, domain.New, status)
+	_, err = repo.GetOrderStatus(context.Background(), "4525634535")
+	require.ErrorIs(t, err, ports.ErrOrderNotFound)
+
+	processing := &domain.StatusTransition{OrderNum: "4525634534", From: domain.New, To: domain.Processing, Source: domain.SourcePoller}
+	require.NoError(t, repo.WriteProcessing(context.Background(), processing))
+	require.ErrorIs(t, repo.WriteProcessing(context.Background(), processing), ports.ErrStatusChanged)
+	raw := []byte(`{"order":"4525634534","status":"PROCESSED","accrual":729.98}`)
+	require.NoError(t, repo.WriteProcessed(context.Background(), &domain.StatusTransition{
+		OrderNum: "4525634534", From: domain.Processing, To: domain.Processed, Accrual: 72998, Source: domain.SourceWebhook, RawResponse: raw,
+	}))
+
+	order, err := ordersRepo.GetOrder(context.Background(), "4525634534")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, order.UID)
+	require.Equal(t, domain.Processed, order.Status)
+	require.Equal(t, domain.Money(72998), order.Accrual)
+	require.Len(t, order.History, 3)
+	require.Equal(t, domain.New, order.History[0].Status)
+	require.Equal(t, domain.SourceUser, order.History[0].Source)
+	require.Equal(t, domain.Processing, order.History[1].Status)
+	require.Equal(t, domain.Money(0), order.History[1].Accrual)
+	require.Nil(t, order.History[1].RawResponse)
+	require.Equal(t, domain.Processed, order.History[2].Status)
+	require.Equal(t, domain.Money(72998), order.History[2].Accrual)
+	require.Equal(t, domain.SourceWebhook, order.History[2].Source)
+	require.JSONEq(t, string(raw), string(order.History[2].RawResponse))
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestLoginThrottle(t *testing.T) {
+	repo := NewLoginThrottleRepository(testdb.GetPool(), testdb.GetLogger())
+	login := domain.ThrottleKey{Scope: domain.ThrottleScopeLogin, Subject: "svirex"}
+	ip := domain.ThrottleKey{Scope: domain.ThrottleScopeIP, Subject: "10.0.0.1"}
+	// четвёртая попытка блокирует ключ на минуту
+	lockout := func(failures int) time.Duration {
+		if failures > 3 {
+			return time.Minute
+		}
+		return 0
+	}
+
+	for i := 1; i <= 3; i++ {
+		failures, lockedFor, err := repo.Attempt(context.Background(), login, time.Hour, lockout)
+		require.NoError(t, err)
+		require.Equal(t, i, failures)
+		require.Zero(t, lockedFor)
+	}
+	failures, _, err := repo.Attempt(context.Background(), ip, time.Hour, lockout)
+	require.NoError(t, err)
+	require.Equal(t, 1, failures)
+
+	failures, lockedFor, err := repo.Attempt(context.Background(), login, time.Hour, lockout)
+	require.NoError(t, err)
+	require.Equal(t, 4, failures)
+	require.Zero(t, lockedFor)
+	// заблокированный ключ попытку не засчитывает
+	failures, lockedFor, err = repo.Attempt(context.Background(), login, time.Hour, lockout)
+	require.NoError(t, err)
+	require.Zero(t, failures)
+	require.InDelta(t, time.Minute, lockedFor, float64(5*time.Second))
+
+	// верная попытка снимает выставленную ею блокировку
+	err = repo.Forgive(context.Background(), login, true)
+	require.NoError(t, err)
+	failures, _, err = repo.Attempt(context.Background(), login, time.Hour, func(int) time.Duration { return 0 })
+	require.NoError(t, err)
+	require.Equal(t, 4, failures)
+
+	// счётчик без ошибок дольше окна начинается заново
+	_, err = testdb.GetPool().Exec(context.Background(), "UPDATE login_attempts SET last_failure_at=last_failure_at - INTERVAL '2 hours';")
+	require.NoError(t, err)
+	failures, _, err = repo.Attempt(context.Background(), login, time.Hour, lockout)
+	require.NoError(t, err)
+	require.Equal(t, 1, failures)
+
+	err = repo.Reset(context.Background(), login)
+	require.NoError(t, err)
+	failures, _, err = repo.Attempt(context.Background(), login, time.Hour, lockout)
+	require.NoError(t, err)
+	require.Equal(t, 1, failures)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestChangePasswordAndDeleteUser(t *testing.T) {
+	userRepo := NewAuthRepo()
+	sessions := NewSessionRepository(testdb.GetPool(), testdb.GetLogger())
+
+	user, err := userRepo.CreateUser(context.Background(), &domain.User{Login: "svirex", Hash: "old"})
+	require.NoError(t, err)
+	for _, id := range []string{"current", "other"} {
+		err = sessions.CreateSession(context.Background(), &domain.Session{
+			ID:          id,
+			UID:         user.ID,
+			RefreshHash: id + "_hash",
+			ExpiresAt:   time.Now().UTC().Add(time.Hour),
+		})
+		require.NoError(t, err)
+	}
+
+	err = userRepo.ChangePassword(context.Background(), user.ID, "new", "current")
+	require.NoError(t, err)
+	found, err := userRepo.GetUserByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, "new", found.Hash)
+	active, err := sessions.IsSessionActive(context.Background(), "current")
+	require.NoError(t, err)
+	require.True(t, active)
+	active, err = sessions.IsSessionActive(context.Background(), "other")
+	require.NoError(t, err)
+	require.False(t, active)
+
+	err = userRepo.DeleteUser(context.Background(), user.ID)
+	require.NoError(t, err)
+	err = userRepo.DeleteUser(context.Background(), user.ID)
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
+
+	_, err = userRepo.GetUserByID(context.Background(), user.ID)
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
+	_, err = userRepo.GetUserByLogin(context.Background(), "svirex")
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
+	active, err = sessions.IsSessionActive(context.Background(), "current")
+	require.NoError(t, err)
+	require.False(t, active)
+
+	var frozen bool
+	err = testdb.GetPool().QueryRow(context.Background(), "SELECT frozen_at IS NOT NULL FROM balance WHERE uid=$1;", user.ID).Scan(&frozen)
+	require.NoError(t, err)
+	require.True(t, frozen)
+
+	// логин снова свободен
+	_, err = userRepo.CreateUser(context.Background(), &domain.User{Login: "svirex", Hash: "hash"})
+	require.NoError(t, err)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestUpdatePasswordHash(t *testing.T) {
+	userRepo := NewAuthRepo()
+
+	user, err := userRepo.CreateUser(context.Background(), &domain.User{Login: "svirex", Hash: "weak"})
+	require.NoError(t, err)
+
+	err = userRepo.UpdatePasswordHash(context.Background(), user.ID, "weak", "strong")
+	require.NoError(t, err)
+	found, err := userRepo.GetUserByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, "strong", found.Hash)
+
+	// пароль сменили между проверкой и обновлением
+	err = userRepo.UpdatePasswordHash(context.Background(), user.ID, "weak", "rehashed")
+	require.NoError(t, err)
+	found, err = userRepo.GetUserByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Equal(t, "strong", found.Hash)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestSecondFactorRepository(t *testing.T) {
+	userRepo := NewAuthRepo()
+	repo := NewSecondFactorRepository(testdb.GetPool(), testdb.GetLogger())
+	ctx := context.Background()
+
+	user, err := userRepo.CreateUser(ctx, &domain.User{Login: "svirex", Hash: "hash"})
+	require.NoError(t, err)
+
+	_, err = repo.GetTOTP(ctx, user.ID)
+	require.ErrorIs(t, err, ports.ErrSecondFactorNotEnabled)
+
+	require.NoError(t, repo.SaveTOTPSecret(ctx, user.ID, "first"))
+	require.NoError(t, repo.SaveTOTPSecret(ctx, user.ID, "second"))
+	totp, err := repo.GetTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, "second", totp.Secret)
+	require.False(t, totp.Enabled)
+
+	// секрет заменили между проверкой кода и включением
+	err = repo.EnableTOTP(ctx, user.ID, "first", 10, []string{"a", "b"})
+	require.ErrorIs(t, err, ports.ErrInvalidSecondFactor)
+	require.NoError(t, repo.EnableTOTP(ctx, user.ID, "second", 10, []string{"a", "b"}))
+	err = repo.SaveTOTPSecret(ctx, user.ID, "third")
+	require.ErrorIs(t, err, ports.ErrSecondFactorAlreadyEnabled)
+
+	ok, err := repo.UseTOTPStep(ctx, user.ID, 10)
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = repo.UseTOTPStep(ctx, user.ID, 11)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = repo.UseRecoveryCode(ctx, user.ID, "a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = repo.UseRecoveryCode(ctx, user.ID, "a")
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = repo.UseRecoveryCode(ctx, user.ID, "c")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, repo.DisableTOTP(ctx, user.ID))
+	_, err = repo.GetTOTP(ctx, user.ID)
+	require.ErrorIs(t, err, ports.ErrSecondFactorNotEnabled)
+	ok, err = repo.UseRecoveryCode(ctx, user.ID, "b")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestAdminRepository(t *testing.T) {
+	userRepo := NewAuthRepo()
+	repo := NewAdminRepository(testdb.GetPool(), testdb.GetLogger())
+	ctx := context.Background()
+
+	admin, err := userRepo.CreateUser(ctx, &domain.User{Login: "admin", Hash: "hash", Role: domain.RoleAdmin})
+	require.NoError(t, err)
+	user, err := userRepo.CreateUser(ctx, &domain.User{Login: "svi_rex", Hash: "hash"})
+	require.NoError(t, err)
+	_, err = userRepo.CreateUser(ctx, &domain.User{Login: "svirex", Hash: "hash"})
+	require.NoError(t, err)
+
+	found, err := userRepo.GetUserByID(ctx, admin.ID)
+	require.NoError(t, err)
+	require.Equal(t, domain.RoleAdmin, found.Role)
+
+	// _ в запросе ищется буквально, а не как любой символ
+	users, err := repo.SearchUsers(ctx, "i_r", 10)
+	require.NoError(t, err)
+	require.Equal(t, []domain.UserInfo{{ID: user.ID, Login: "svi_rex", Role: domain.RoleUser}}, users)
+	users, err = repo.SearchUsers(ctx, "", 2)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+
+	err = repo.SetRole(ctx, user.ID, domain.RoleSupport, &domain.AuditEntry{
+		ActorUID: admin.ID, Action: domain.AuditRoleChanged, TargetUID: user.ID,
+		Details: map[string]string{"from": "user", "to": "support"},
+	})
+	require.NoError(t, err)
+	info, err := repo.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, domain.RoleSupport, info.Role)
+
+	balance, err := repo.AdjustBalance(ctx, user.ID, 150_00, &domain.AuditEntry{
+		Action: domain.AuditBalanceAdjusted, TargetUID: user.ID, Reason: "compensation",
+	})
+	require.NoError(t, err)
+	require.Equal(t, domain.Money(150_00), balance.Current)
+	_, err = repo.AdjustBalance(ctx, user.ID, -200_00, &domain.AuditEntry{
+		Action: domain.AuditBalanceAdjusted, TargetUID: user.ID, Reason: "fraud",
+	})
+	require.ErrorIs(t, err, ports.ErrNotEnoughMoney)
+
+	history, err := NewTestBalanceRepository().GetHistory(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, domain.PostingAdjustment, history[0].Kind)
+
+	// неудачная корректировка в журнал не попала
+	entries, err := repo.GetAuditLog(ctx, user.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, domain.AuditBalanceAdjusted, entries[0].Action)
+	require.Equal(t, int64(0), entries[0].ActorUID)
+	require.Equal(t, "compensation", entries[0].Reason)
+	require.Equal(t, domain.AuditRoleChanged, entries[1].Action)
+	require.Equal(t, admin.ID, entries[1].ActorUID)
+	require.Equal(t, "support", entries[1].Details["to"])
+
+	require.NoError(t, userRepo.DeleteUser(ctx, user.ID))
+	info, err = repo.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.True(t, info.Deleted)
+	require.Empty(t, info.Login)
+	_, err = repo.AdjustBalance(ctx, user.ID, 10_00, &domain.AuditEntry{
+		Action: domain.AuditBalanceAdjusted, TargetUID: user.ID, Reason: "late",
+	})
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
+	_, err = repo.GetUser(ctx, 1000)
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/common"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type RevocationRepository struct {
+	db     *pgxpool.Pool
+	logger common.Logger
+}
+
+func NewRevocationRepository(db *pgxpool.Pool, logger common.Logger) *RevocationRepository {
+	return &RevocationRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+var _ ports.RevocationRepository = (*RevocationRepository)(nil)
+
+func (repo *RevocationRepository) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("revocation repo, revoke token, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	_, err = trx.Exec(ctx, "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT DO NOTHING;", tokenID, expiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("revocation repo, revoke token, insert: %w", err)
+	}
+	// истёкшие токены и так не пройдут проверку
+	_, err = trx.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at<(NOW() AT TIME ZONE 'UTC');")
+	if err != nil {
+		return fmt.Errorf("revocation repo, revoke token, delete expired: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("revocation repo, revoke token, commit: %w", err)
+	}
+	return nil
+}
+
+func (repo *RevocationRepository) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+	err := repo.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti=$1);", tokenID).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("revocation repo, is token revoked: %w", err)
+	}
+	return revoked, nil
+}
+
+func (repo *RevocationRepository) RevokeUserTokens(ctx context.Context, uid int64, validAfter time.Time) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("revocation repo, revoke user tokens, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	tag, err := trx.Exec(ctx, "UPDATE users SET tokens_valid_after=$2 WHERE id=$1;", uid, validAfter.UTC())
+	if err != nil {
+		return fmt.Errorf("revocation repo, revoke user tokens, update user: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: revocation repo, revoke user tokens, uid %d", ports.ErrUserNotFound, uid)
+	}
+	_, err = trx.Exec(ctx, "UPDATE sessions SET revoked_at=NOW() WHERE uid=$1 AND revoked_at IS NULL;", uid)
+	if err != nil {
+		return fmt.Errorf("revocation repo, revoke user tokens, revoke sessions: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("revocation repo, revoke user tokens, commit: %w", err)
+	}
+	return nil
+}
+
+func (repo *RevocationRepository) TokensValidAfter(ctx context.Context, uid int64) (time.Time, error) {
+	var validAfter *time.Time
+	err := repo.db.QueryRow(ctx, "SELECT tokens_valid_after FROM users WHERE id=$1;", uid).Scan(&validAfter)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, fmt.Errorf("%w: revocation repo, tokens valid after, uid %d", ports.ErrUserNotFound, uid)
+		}
+		return time.Time{}, fmt.Errorf("revocation repo, tokens valid after: %w", err)
+	}
+	if validAfter == nil {
+		return time.Time{}, nil
+	}
+	// колонка без часового пояса хранит время в UTC
+	return time.Date(validAfter.Year(), validAfter.Month(), validAfter.Day(),
+		validAfter.Hour(), validAfter.Minute(), validAfter.Second(), validAfter.Nanosecond(), time.UTC), nil
+}
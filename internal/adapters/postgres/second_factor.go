@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Svirex/gofermart-loyality/internal/common"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SecondFactorRepository struct {
+	db     *pgxpool.Pool
+	logger common.Logger
+}
+
+func NewSecondFactorRepository(db *pgxpool.Pool, logger common.Logger) *SecondFactorRepository {
+	return &SecondFactorRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+var _ ports.SecondFactorRepository = (*SecondFactorRepository)(nil)
+
+func (repo *SecondFactorRepository) SaveTOTPSecret(ctx context.Context, uid int64, secret string) error {
+	tag, err := repo.db.Exec(ctx, `INSERT INTO user_totp (uid, secret) VALUES ($1, $2)
+		ON CONFLICT (uid) DO UPDATE SET secret=EXCLUDED.secret, last_used_step=0, created_at=NOW()
+		WHERE user_totp.enabled_at IS NULL;`, uid, secret)
+	if err != nil {
+		return fmt.Errorf("second factor repo, save totp secret: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: second factor repo, save totp secret, uid %d", ports.ErrSecondFactorAlreadyEnabled, uid)
+	}
+	return nil
+}
+
+func (repo *SecondFactorRepository) GetTOTP(ctx context.Context, uid int64) (*domain.TOTP, error) {
+	totp := &domain.TOTP{UID: uid}
+	err := repo.db.QueryRow(ctx, "SELECT secret, enabled_at IS NOT NULL, last_used_step FROM user_totp WHERE uid=$1;", uid).
+		Scan(&totp.Secret, &totp.Enabled, &totp.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: second factor repo, get totp, uid %d", ports.ErrSecondFactorNotEnabled, uid)
+		}
+		return nil, fmt.Errorf("second factor repo, get totp: %w", err)
+	}
+	return totp, nil
+}
+
+func (repo *SecondFactorRepository) EnableTOTP(ctx context.Context, uid int64, secret string, step int64, recoveryCodeHashes []string) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("second factor repo, enable totp, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	tag, err := trx.Exec(ctx, `UPDATE user_totp SET enabled_at=NOW(), last_used_step=$3
+		WHERE uid=$1 AND secret=$2 AND enabled_at IS NULL;`, uid, secret, step)
+	if err != nil {
+		return fmt.Errorf("second factor repo, enable totp, update: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: second factor repo, enable totp, secret of uid %d changed", ports.ErrInvalidSecondFactor, uid)
+	}
+	_, err = trx.Exec(ctx, "DELETE FROM recovery_codes WHERE uid=$1;", uid)
+	if err != nil {
+		return fmt.Errorf("second factor repo, enable totp, delete recovery codes: %w", err)
+	}
+	_, err = trx.Exec(ctx, "INSERT INTO recovery_codes (uid, code_hash) SELECT $1, UNNEST($2::TEXT[]);", uid, recoveryCodeHashes)
+	if err != nil {
+		return fmt.Errorf("second factor repo, enable totp, insert recovery codes: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("second factor repo, enable totp, commit: %w", err)
+	}
+	return nil
+}
+
+func (repo *SecondFactorRepository) UseTOTPStep(ctx context.Context, uid int64, step int64) (bool, error) {
+	tag, err := repo.db.Exec(ctx, `UPDATE user_totp SET last_used_step=$2
+		WHERE uid=$1 AND enabled_at IS NOT NULL AND last_used_step < $2;`, uid, step)
+	if err != nil {
+		return false, fmt.Errorf("second factor repo, use totp step: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (repo *SecondFactorRepository) UseRecoveryCode(ctx context.Context, uid int64, codeHash string) (bool, error) {
+	tag, err := repo.db.Exec(ctx, `UPDATE recovery_codes SET used_at=NOW()
+		WHERE uid=$1 AND code_hash=$2 AND used_at IS NULL;`, uid, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("second factor repo, use recovery code: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (repo *SecondFactorRepository) DisableTOTP(ctx context.Context, uid int64) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("second factor repo, disable totp, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	_, err = trx.Exec(ctx, "DELETE FROM recovery_codes WHERE uid=$1;", uid)
+	if err != nil {
+		return fmt.Errorf("second factor repo, disable totp, delete recovery codes: %w", err)
+	}
+	_, err = trx.Exec(ctx, "DELETE FROM user_totp WHERE uid=$1;", uid)
+	if err != nil {
+		return fmt.Errorf("second factor repo, disable totp, delete secret: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("second factor repo, disable totp, commit: %w", err)
+	}
+	return nil
+}
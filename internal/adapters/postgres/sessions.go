@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/common"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SessionRepository struct {
+	db     *pgxpool.Pool
+	logger common.Logger
+}
+
+func NewSessionRepository(db *pgxpool.Pool, logger common.Logger) *SessionRepository {
+	return &SessionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+var _ ports.SessionRepository = (*SessionRepository)(nil)
+
+func (repo *SessionRepository) CreateSession(ctx context.Context, session *domain.Session) error {
+	_, err := repo.db.Exec(ctx, "INSERT INTO sessions (id, uid, refresh_hash, expires_at) VALUES ($1, $2, $3, $4);",
+		session.ID, session.UID, session.RefreshHash, session.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("session repo, create session: %w", err)
+	}
+	return nil
+}
+
+func (repo *SessionRepository) RotateSession(ctx context.Context, refreshHash string, newRefreshHash string, expiresAt time.Time) (*domain.Session, error) {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("session repo, rotate session, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	session := &domain.Session{
+		RefreshHash: newRefreshHash,
+		ExpiresAt:   expiresAt,
+	}
+	err = trx.QueryRow(ctx, `UPDATE sessions SET previous_hash=refresh_hash, refresh_hash=$2, expires_at=$3
+		WHERE refresh_hash=$1 AND revoked_at IS NULL AND expires_at>(NOW() AT TIME ZONE 'UTC')
+		RETURNING id, uid;`, refreshHash, newRefreshHash, expiresAt.UTC()).Scan(&session.ID, &session.UID)
+	if err == nil {
+		if err = trx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("session repo, rotate session, commit: %w", err)
+		}
+		return session, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("session repo, rotate session, update: %w", err)
+	}
+	tag, err := trx.Exec(ctx, "UPDATE sessions SET revoked_at=NOW() WHERE previous_hash=$1 AND revoked_at IS NULL;", refreshHash)
+	if err != nil {
+		return nil, fmt.Errorf("session repo, rotate session, revoke reused: %w", err)
+	}
+	if err = trx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("session repo, rotate session, commit revoke: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		repo.logger.Infof("session repo, rotate session, refresh token reused, session revoked")
+		return nil, fmt.Errorf("%w: session repo, rotate session, refresh token reused", ports.ErrInvalidToken)
+	}
+	return nil, fmt.Errorf("%w: session repo, rotate session, unknown or expired refresh token", ports.ErrInvalidToken)
+}
+
+func (repo *SessionRepository) RevokeSession(ctx context.Context, sessionID string) error {
+	_, err := repo.db.Exec(ctx, "UPDATE sessions SET revoked_at=NOW() WHERE id=$1 AND revoked_at IS NULL;", sessionID)
+	if err != nil {
+		return fmt.Errorf("session repo, revoke session: %w", err)
+	}
+	return nil
+}
+
+func (repo *SessionRepository) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+	err := repo.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM sessions WHERE id=$1 AND revoked_at IS NULL AND expires_at>(NOW() AT TIME ZONE 'UTC'));", sessionID).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("session repo, is session active: %w", err)
+	}
+	return active, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/common"
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
@@ -50,6 +51,10 @@ func (repo *WithdrawRepository) Withdraw(ctx context.Context, uid int64, data *d
 		}
 		return fmt.Errorf("withdraw repo, Withdraw, insert withdraw record: %w", err)
 	}
+	_, err = trx.Exec(ctx, "INSERT INTO ledger (uid, order_num, kind, amount) VALUES ($1, $2, 'WITHDRAWAL', -$3::NUMERIC);", uid, data.OrderNum, data.Sum)
+	if err != nil {
+		return fmt.Errorf("withdraw repo, Withdraw, insert ledger posting: %w", err)
+	}
 	err = trx.Commit(ctx)
 	if err != nil {
 		return fmt.Errorf("withdraw repo, Withdraw, commit: %w", err)
@@ -57,25 +62,105 @@ func (repo *WithdrawRepository) Withdraw(ctx context.Context, uid int64, data *d
 	return nil
 }
 
-func (repo *WithdrawRepository) GetWithdrawals(ctx context.Context, uid int64) ([]*domain.WithdrawData, error) {
-	rows, _ := repo.db.Query(ctx, "SELECT order_num, sum, processed_at FROM withdraws WHERE uid=$1 ORDER BY processed_at DESC;", uid)
-	if err := rows.Err(); err != nil {
-		repo.logger.Errorln("withdraw repo, get withdrawals, select: %v", err)
-		return nil, fmt.Errorf("withdraw repo, get withdrawals, select: %w", err)
-	}
-	data := make([]*domain.WithdrawData, 0)
-	for rows.Next() {
-		if err := rows.Err(); err != nil {
-			repo.logger.Errorln("withdraw repo, get withdrawals, next row: %v", err)
-			return nil, fmt.Errorf("withdraw repo, get withdrawals, next row: %w", err)
-		}
-		order := &domain.WithdrawData{}
-		if err := rows.Scan(&order.OrderNum, &order.Sum, &order.ProcessedAt); err != nil {
-			repo.logger.Errorln("withdraw repo, get withdrawals, scan: %v", err)
-			return nil, fmt.Errorf("withdraw repo, get withdrawals, scan: %w", err)
+func (repo *WithdrawRepository) CheckWithdraw(ctx context.Context, uid int64, data *domain.WithdrawData) error {
+	var enough, duplicate bool
+	err := repo.db.QueryRow(ctx, `SELECT current >= $2, EXISTS(SELECT 1 FROM withdraws WHERE order_num=$3) FROM balance WHERE uid=$1;`,
+		uid, data.Sum, data.OrderNum).Scan(&enough, &duplicate)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w: withdraw repo, CheckWithdraw, no balance", ports.ErrNotEnoughMoney)
 		}
-		data = append(data, order)
+		return fmt.Errorf("withdraw repo, CheckWithdraw: %w", err)
+	}
+	if duplicate {
+		return fmt.Errorf("%w: withdraw repo, CheckWithdraw", ports.ErrDuplicateOrderNumber)
+	}
+	if !enough {
+		return fmt.Errorf("%w: withdraw repo, CheckWithdraw", ports.ErrNotEnoughMoney)
+	}
+	return nil
+}
 
+func (repo *WithdrawRepository) GetWithdrawals(ctx context.Context, uid int64, query domain.WithdrawalsQuery) ([]*domain.WithdrawData, error) {
+	var from, to, afterAt *time.Time
+	var minSum, maxSum *domain.Money
+	var afterID int64
+	var limit *int
+	if !query.From.IsZero() {
+		from = &query.From
+	}
+	if !query.To.IsZero() {
+		to = &query.To
+	}
+	if query.MinSum > 0 {
+		minSum = &query.MinSum
+	}
+	if query.MaxSum > 0 {
+		maxSum = &query.MaxSum
+	}
+	if query.After != nil {
+		afterAt, afterID = &query.After.At, query.After.ID
+	}
+	if query.Limit > 0 {
+		limit = &query.Limit
+	}
+	// LIMIT NULL - без ограничения.
+	// processed_at заполняет NOW() в часовом поясе сессии, from и to переводятся в него, как и для заказов
+	rows, _ := repo.db.Query(ctx, `SELECT id, order_num, sum, status, processed_at, cancelled_at FROM withdraws
+		WHERE uid=$1
+			AND ($2::TIMESTAMPTZ IS NULL OR processed_at >= $2::TIMESTAMPTZ::TIMESTAMP)
+			AND ($3::TIMESTAMPTZ IS NULL OR processed_at < $3::TIMESTAMPTZ::TIMESTAMP)
+			AND ($4::NUMERIC IS NULL OR sum >= $4)
+			AND ($5::NUMERIC IS NULL OR sum <= $5)
+			AND ($6::TIMESTAMP IS NULL OR (processed_at, id) < ($6, $7))
+		ORDER BY processed_at DESC, id DESC LIMIT $8;`, uid, from, to, minSum, maxSum, afterAt, afterID, limit)
+	data, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*domain.WithdrawData, error) {
+		w := &domain.WithdrawData{}
+		err := row.Scan(&w.ID, &w.OrderNum, &w.Sum, &w.Status, &w.ProcessedAt, &w.CancelledAt)
+		return w, err
+	})
+	if err != nil {
+		repo.logger.Errorf("withdraw repo, get withdrawals: %v", err)
+		return nil, fmt.Errorf("withdraw repo, get withdrawals: %w", err)
 	}
 	return data, nil
 }
+
+func (repo *WithdrawRepository) Cancel(ctx context.Context, uid int64, orderNum string, window time.Duration) error {
+	trx, err := repo.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("withdraw repo, cancel, start trx: %w", err)
+	}
+	defer trx.Rollback(ctx)
+	var sum domain.Money
+	err = trx.QueryRow(ctx, `UPDATE withdraws SET status='CANCELLED', cancelled_at=NOW()
+		WHERE uid=$1 AND order_num=$2 AND status='PROCESSED' AND processed_at > NOW() - $3 * INTERVAL '1 millisecond'
+		RETURNING sum;`, uid, orderNum, window.Milliseconds()).Scan(&sum)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			var exists bool
+			err = trx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM withdraws WHERE uid=$1 AND order_num=$2);", uid, orderNum).Scan(&exists)
+			if err != nil {
+				return fmt.Errorf("withdraw repo, cancel, select withdrawal: %w", err)
+			}
+			if !exists {
+				return fmt.Errorf("%w: withdraw repo, cancel, order %s", ports.ErrWithdrawalNotFound, orderNum)
+			}
+			return fmt.Errorf("%w: withdraw repo, cancel, order %s", ports.ErrWithdrawalNotCancellable, orderNum)
+		}
+		return fmt.Errorf("withdraw repo, cancel, update withdrawal: %w", err)
+	}
+	_, err = trx.Exec(ctx, "UPDATE balance SET current=current+$1, withdrawn=withdrawn-$1 WHERE uid=$2;", sum, uid)
+	if err != nil {
+		return fmt.Errorf("withdraw repo, cancel, update balance: %w", err)
+	}
+	_, err = trx.Exec(ctx, "INSERT INTO ledger (uid, order_num, kind, amount) VALUES ($1, $2, 'REVERSAL', $3);", uid, orderNum, sum)
+	if err != nil {
+		return fmt.Errorf("withdraw repo, cancel, insert ledger posting: %w", err)
+	}
+	err = trx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("withdraw repo, cancel, commit: %w", err)
+	}
+	return nil
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"flag"
 	"fmt"
+	"math"
+	"os"
+	"time"
 
 	"github.com/caarlos0/env/v10"
 )
@@ -12,6 +15,55 @@ type Config struct {
 	DatabaseURI          string `env:"DATABASE_URI"`
 	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
 	SecretKey            string `env:"SECRET_KEY"`
+	AdminToken           string `env:"ADMIN_TOKEN"`
+
+	PasswordMinLength      int     `env:"PASSWORD_MIN_LENGTH"`
+	PasswordMinEntropyBits float64 `env:"PASSWORD_MIN_ENTROPY_BITS"`
+	PasswordBlocklistFile  string  `env:"PASSWORD_BLOCKLIST_FILE"`
+
+	PasswordHasher  string `env:"PASSWORD_HASHER"`
+	BcryptCost      int    `env:"BCRYPT_COST"`
+	Argon2Time      uint32 `env:"ARGON2_TIME"`
+	Argon2MemoryKiB uint32 `env:"ARGON2_MEMORY_KIB"`
+	Argon2Threads   uint8  `env:"ARGON2_THREADS"`
+
+	LoginFreeAttempts   int           `env:"LOGIN_FREE_ATTEMPTS"`
+	LoginIPFreeAttempts int           `env:"LOGIN_IP_FREE_ATTEMPTS"`
+	LoginLockoutBase    time.Duration `env:"LOGIN_LOCKOUT_BASE"`
+	LoginLockoutMax     time.Duration `env:"LOGIN_LOCKOUT_MAX"`
+	LoginFailureWindow  time.Duration `env:"LOGIN_FAILURE_WINDOW"`
+	TrustProxyHeaders   bool          `env:"TRUST_PROXY_HEADERS"`
+
+	CookieDomain   string `env:"COOKIE_DOMAIN"`
+	CookieSecure   bool   `env:"COOKIE_SECURE"`
+	CookieSameSite string `env:"COOKIE_SAME_SITE"`
+
+	JWTKeys         string `env:"JWT_KEYS"`
+	JWTSigningKeyID string `env:"JWT_SIGNING_KEY_ID"`
+	JWTDefaultKeyID string `env:"JWT_DEFAULT_KEY_ID"`
+
+	AccrualRateLimit      int           `env:"ACCRUAL_RATE_LIMIT"`
+	AccrualWorkers        int           `env:"ACCRUAL_WORKERS"`
+	AccrualRequestTimeout time.Duration `env:"ACCRUAL_REQUEST_TIMEOUT"`
+
+	AccrualRetryBaseDelay   time.Duration `env:"ACCRUAL_RETRY_BASE_DELAY"`
+	AccrualRetryMaxDelay    time.Duration `env:"ACCRUAL_RETRY_MAX_DELAY"`
+	AccrualRetryMaxAttempts int           `env:"ACCRUAL_RETRY_MAX_ATTEMPTS"`
+	AccrualRetryMaxAge      time.Duration `env:"ACCRUAL_RETRY_MAX_AGE"`
+
+	AccrualWebhookSecret  string        `env:"ACCRUAL_WEBHOOK_SECRET"`
+	AccrualCallbackWindow time.Duration `env:"ACCRUAL_CALLBACK_WINDOW"`
+
+	WithdrawCancelWindow time.Duration `env:"WITHDRAW_CANCEL_WINDOW"`
+	// WithdrawSecondFactorThreshold - списания больше этой суммы подтверждаются вторым фактором
+	WithdrawSecondFactorThreshold float64 `env:"WITHDRAW_SECOND_FACTOR_THRESHOLD"`
+
+	TOTPIssuer string `env:"TOTP_ISSUER"`
+
+	AccessTokenTTL  time.Duration `env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL"`
+
+	RevocationCacheTTL time.Duration `env:"REVOCATION_CACHE_TTL"`
 }
 
 func ParseEnv() (*Config, error) {
@@ -28,7 +80,47 @@ func ParseFlags() (*Config, error) {
 	flag.StringVar(&cfg.DatabaseURI, "d", "", "DATABASE_URI")
 	flag.StringVar(&cfg.AccrualSystemAddress, "r", "", "ACCRUAL_SYSTEM_ADDRESS")
 	flag.StringVar(&cfg.SecretKey, "k", "fake_secret_key", "secret key for auth")
+	flag.StringVar(&cfg.AdminToken, "admin-token", "", "token with admin rights for /api/admin without an account, disabled if empty")
+	flag.IntVar(&cfg.PasswordMinLength, "password-min-length", 8, "min password length in characters")
+	flag.Float64Var(&cfg.PasswordMinEntropyBits, "password-min-entropy-bits", 80, "min estimated password entropy, 0 - not checked")
+	flag.StringVar(&cfg.PasswordBlocklistFile, "password-blocklist-file", "", "file with common and breached passwords, one per line, empty - no blocklist")
+	flag.StringVar(&cfg.PasswordHasher, "password-hasher", "bcrypt", "hash of new passwords: bcrypt or argon2id, weaker hashes are replaced on login")
+	flag.IntVar(&cfg.BcryptCost, "bcrypt-cost", 10, "bcrypt cost")
+	argon2Time := flag.Uint("argon2-time", 3, "argon2id iterations")
+	argon2Memory := flag.Uint("argon2-memory-kib", 64*1024, "argon2id memory in KiB")
+	argon2Threads := flag.Uint("argon2-threads", 2, "argon2id parallelism")
+	flag.IntVar(&cfg.LoginFreeAttempts, "login-free-attempts", 5, "failed logins to one account before it is locked, 0 - unlimited")
+	flag.IntVar(&cfg.LoginIPFreeAttempts, "login-ip-free-attempts", 20, "failed logins from one ip before it is locked, 0 - unlimited")
+	flag.DurationVar(&cfg.LoginLockoutBase, "login-lockout-base", time.Second, "first login lockout, doubled by every next failure")
+	flag.DurationVar(&cfg.LoginLockoutMax, "login-lockout-max", 15*time.Minute, "max login lockout")
+	flag.DurationVar(&cfg.LoginFailureWindow, "login-failure-window", time.Hour, "failed login counters reset after this long without failures")
+	flag.BoolVar(&cfg.TrustProxyHeaders, "trust-proxy-headers", false, "take client ip from X-Forwarded-For and X-Real-IP, enable only behind a proxy")
+	flag.StringVar(&cfg.CookieDomain, "cookie-domain", "", "domain attribute of auth cookies, empty - host of the request")
+	flag.BoolVar(&cfg.CookieSecure, "cookie-secure", false, "send auth cookies over https only")
+	flag.StringVar(&cfg.CookieSameSite, "cookie-same-site", "lax", "SameSite of auth cookies: lax, strict or none (requires cookie-secure)")
+	flag.StringVar(&cfg.JWTKeys, "jwt-keys", "", "jwt key ring kid:ALG:path,... with ALG HS256, RS256 or EdDSA, empty - HS256 with the secret key")
+	flag.StringVar(&cfg.JWTSigningKeyID, "jwt-signing-key-id", "", "kid from jwt-keys used to sign new tokens")
+	flag.StringVar(&cfg.JWTDefaultKeyID, "jwt-default-key-id", "", "kid from jwt-keys that verifies tokens without kid, empty - such tokens are rejected")
+	flag.IntVar(&cfg.AccrualRateLimit, "accrual-rate-limit", 600, "max requests per minute to the accrual system, adapts to its 429 answers")
+	flag.IntVar(&cfg.AccrualWorkers, "accrual-workers", 4, "parallel requests to the accrual system")
+	flag.DurationVar(&cfg.AccrualRequestTimeout, "accrual-request-timeout", 5*time.Second, "timeout of a single request to the accrual system")
+	flag.DurationVar(&cfg.AccrualRetryBaseDelay, "accrual-retry-base-delay", time.Second, "delay before the second accrual check of an order")
+	flag.DurationVar(&cfg.AccrualRetryMaxDelay, "accrual-retry-max-delay", 10*time.Minute, "max delay between accrual checks of an order")
+	flag.IntVar(&cfg.AccrualRetryMaxAttempts, "accrual-retry-max-attempts", 0, "accrual checks before FAILED_CHECK, 0 - unlimited")
+	flag.DurationVar(&cfg.AccrualRetryMaxAge, "accrual-retry-max-age", 72*time.Hour, "order age after which accrual checks stop with FAILED_CHECK, 0 - unlimited")
+	flag.StringVar(&cfg.AccrualWebhookSecret, "accrual-webhook-secret", "", "HMAC secret of accrual system callbacks, callbacks are disabled if empty")
+	flag.DurationVar(&cfg.AccrualCallbackWindow, "accrual-callback-window", 0, "wait for a callback this long before polling the accrual system, 0 - poll at once")
+	flag.DurationVar(&cfg.WithdrawCancelWindow, "withdraw-cancel-window", 24*time.Hour, "how long a withdrawal can be cancelled, 0 - cancellation is disabled")
+	flag.Float64Var(&cfg.WithdrawSecondFactorThreshold, "withdraw-second-factor-threshold", 1000, "withdrawals above this sum require a totp code from users with 2fa, 0 - never")
+	flag.StringVar(&cfg.TOTPIssuer, "totp-issuer", "Gophermart", "service name shown in authenticator apps")
+	flag.DurationVar(&cfg.AccessTokenTTL, "access-token-ttl", 15*time.Minute, "lifetime of the access token in the jwt cookie")
+	flag.DurationVar(&cfg.RefreshTokenTTL, "refresh-token-ttl", 30*24*time.Hour, "lifetime of the refresh token, prolonged on every refresh")
+	flag.DurationVar(&cfg.RevocationCacheTTL, "revocation-cache-ttl", 10*time.Second, "how long token revocations made by other instances may stay unnoticed")
 	flag.Parse()
+	if *argon2Time > math.MaxUint32 || *argon2Memory > math.MaxUint32 || *argon2Threads > math.MaxUint8 {
+		return nil, fmt.Errorf("parse flags, argon2 params out of range")
+	}
+	cfg.Argon2Time, cfg.Argon2MemoryKiB, cfg.Argon2Threads = uint32(*argon2Time), uint32(*argon2Memory), uint8(*argon2Threads)
 	return cfg, nil
 }
 
@@ -47,10 +139,46 @@ func Parse() (*Config, error) {
 
 func mergeConf(envCfg *Config, flagConfig *Config) *Config {
 	cfg := &Config{
-		RunAddress:           envCfg.RunAddress,
-		DatabaseURI:          envCfg.DatabaseURI,
-		AccrualSystemAddress: envCfg.AccrualSystemAddress,
-		SecretKey:            envCfg.SecretKey,
+		RunAddress:                    envCfg.RunAddress,
+		DatabaseURI:                   envCfg.DatabaseURI,
+		AccrualSystemAddress:          envCfg.AccrualSystemAddress,
+		SecretKey:                     envCfg.SecretKey,
+		AdminToken:                    envCfg.AdminToken,
+		PasswordMinLength:             envCfg.PasswordMinLength,
+		PasswordMinEntropyBits:        envCfg.PasswordMinEntropyBits,
+		PasswordBlocklistFile:         envCfg.PasswordBlocklistFile,
+		PasswordHasher:                envCfg.PasswordHasher,
+		BcryptCost:                    envCfg.BcryptCost,
+		Argon2Time:                    envCfg.Argon2Time,
+		Argon2MemoryKiB:               envCfg.Argon2MemoryKiB,
+		Argon2Threads:                 envCfg.Argon2Threads,
+		LoginFreeAttempts:             envCfg.LoginFreeAttempts,
+		LoginIPFreeAttempts:           envCfg.LoginIPFreeAttempts,
+		LoginLockoutBase:              envCfg.LoginLockoutBase,
+		LoginLockoutMax:               envCfg.LoginLockoutMax,
+		LoginFailureWindow:            envCfg.LoginFailureWindow,
+		TrustProxyHeaders:             envCfg.TrustProxyHeaders,
+		CookieDomain:                  envCfg.CookieDomain,
+		CookieSecure:                  envCfg.CookieSecure,
+		CookieSameSite:                envCfg.CookieSameSite,
+		JWTKeys:                       envCfg.JWTKeys,
+		JWTSigningKeyID:               envCfg.JWTSigningKeyID,
+		JWTDefaultKeyID:               envCfg.JWTDefaultKeyID,
+		AccrualRateLimit:              envCfg.AccrualRateLimit,
+		AccrualWorkers:                envCfg.AccrualWorkers,
+		AccrualRequestTimeout:         envCfg.AccrualRequestTimeout,
+		AccrualRetryBaseDelay:         envCfg.AccrualRetryBaseDelay,
+		AccrualRetryMaxDelay:          envCfg.AccrualRetryMaxDelay,
+		AccrualRetryMaxAttempts:       envCfg.AccrualRetryMaxAttempts,
+		AccrualRetryMaxAge:            envCfg.AccrualRetryMaxAge,
+		AccrualWebhookSecret:          envCfg.AccrualWebhookSecret,
+		AccrualCallbackWindow:         envCfg.AccrualCallbackWindow,
+		WithdrawCancelWindow:          envCfg.WithdrawCancelWindow,
+		WithdrawSecondFactorThreshold: envCfg.WithdrawSecondFactorThreshold,
+		TOTPIssuer:                    envCfg.TOTPIssuer,
+		AccessTokenTTL:                envCfg.AccessTokenTTL,
+		RefreshTokenTTL:               envCfg.RefreshTokenTTL,
+		RevocationCacheTTL:            envCfg.RevocationCacheTTL,
 	}
 	if cfg.RunAddress == "" {
 		cfg.RunAddress = flagConfig.RunAddress
@@ -64,5 +192,121 @@ func mergeConf(envCfg *Config, flagConfig *Config) *Config {
 	if cfg.SecretKey == "" {
 		cfg.SecretKey = flagConfig.SecretKey
 	}
+	if cfg.AdminToken == "" {
+		cfg.AdminToken = flagConfig.AdminToken
+	}
+	if cfg.PasswordMinLength == 0 {
+		cfg.PasswordMinLength = flagConfig.PasswordMinLength
+	}
+	if !envSet("PASSWORD_MIN_ENTROPY_BITS") {
+		cfg.PasswordMinEntropyBits = flagConfig.PasswordMinEntropyBits
+	}
+	if cfg.PasswordBlocklistFile == "" {
+		cfg.PasswordBlocklistFile = flagConfig.PasswordBlocklistFile
+	}
+	if cfg.PasswordHasher == "" {
+		cfg.PasswordHasher = flagConfig.PasswordHasher
+	}
+	if cfg.BcryptCost == 0 {
+		cfg.BcryptCost = flagConfig.BcryptCost
+	}
+	if cfg.Argon2Time == 0 {
+		cfg.Argon2Time = flagConfig.Argon2Time
+	}
+	if cfg.Argon2MemoryKiB == 0 {
+		cfg.Argon2MemoryKiB = flagConfig.Argon2MemoryKiB
+	}
+	if cfg.Argon2Threads == 0 {
+		cfg.Argon2Threads = flagConfig.Argon2Threads
+	}
+	if !envSet("LOGIN_FREE_ATTEMPTS") {
+		cfg.LoginFreeAttempts = flagConfig.LoginFreeAttempts
+	}
+	if !envSet("LOGIN_IP_FREE_ATTEMPTS") {
+		cfg.LoginIPFreeAttempts = flagConfig.LoginIPFreeAttempts
+	}
+	if !envSet("LOGIN_LOCKOUT_BASE") {
+		cfg.LoginLockoutBase = flagConfig.LoginLockoutBase
+	}
+	if !envSet("LOGIN_LOCKOUT_MAX") {
+		cfg.LoginLockoutMax = flagConfig.LoginLockoutMax
+	}
+	if !envSet("LOGIN_FAILURE_WINDOW") {
+		cfg.LoginFailureWindow = flagConfig.LoginFailureWindow
+	}
+	if !envSet("TRUST_PROXY_HEADERS") {
+		cfg.TrustProxyHeaders = flagConfig.TrustProxyHeaders
+	}
+	if cfg.CookieDomain == "" {
+		cfg.CookieDomain = flagConfig.CookieDomain
+	}
+	if !envSet("COOKIE_SECURE") {
+		cfg.CookieSecure = flagConfig.CookieSecure
+	}
+	if cfg.CookieSameSite == "" {
+		cfg.CookieSameSite = flagConfig.CookieSameSite
+	}
+	if cfg.JWTKeys == "" {
+		cfg.JWTKeys = flagConfig.JWTKeys
+	}
+	if cfg.JWTSigningKeyID == "" {
+		cfg.JWTSigningKeyID = flagConfig.JWTSigningKeyID
+	}
+	if cfg.JWTDefaultKeyID == "" {
+		cfg.JWTDefaultKeyID = flagConfig.JWTDefaultKeyID
+	}
+	if cfg.AccrualRateLimit == 0 {
+		cfg.AccrualRateLimit = flagConfig.AccrualRateLimit
+	}
+	if cfg.AccrualWorkers == 0 {
+		cfg.AccrualWorkers = flagConfig.AccrualWorkers
+	}
+	if cfg.AccrualRequestTimeout == 0 {
+		cfg.AccrualRequestTimeout = flagConfig.AccrualRequestTimeout
+	}
+	if !envSet("ACCRUAL_RETRY_BASE_DELAY") {
+		cfg.AccrualRetryBaseDelay = flagConfig.AccrualRetryBaseDelay
+	}
+	if !envSet("ACCRUAL_RETRY_MAX_DELAY") {
+		cfg.AccrualRetryMaxDelay = flagConfig.AccrualRetryMaxDelay
+	}
+	if !envSet("ACCRUAL_RETRY_MAX_ATTEMPTS") {
+		cfg.AccrualRetryMaxAttempts = flagConfig.AccrualRetryMaxAttempts
+	}
+	if !envSet("ACCRUAL_RETRY_MAX_AGE") {
+		cfg.AccrualRetryMaxAge = flagConfig.AccrualRetryMaxAge
+	}
+	if cfg.AccrualWebhookSecret == "" {
+		cfg.AccrualWebhookSecret = flagConfig.AccrualWebhookSecret
+	}
+	if cfg.AccrualCallbackWindow == 0 {
+		cfg.AccrualCallbackWindow = flagConfig.AccrualCallbackWindow
+	}
+	if !envSet("WITHDRAW_CANCEL_WINDOW") {
+		cfg.WithdrawCancelWindow = flagConfig.WithdrawCancelWindow
+	}
+	// 0 в окружении выключает подтверждение, поэтому проверяется наличие переменной, а не значение
+	if !envSet("WITHDRAW_SECOND_FACTOR_THRESHOLD") {
+		cfg.WithdrawSecondFactorThreshold = flagConfig.WithdrawSecondFactorThreshold
+	}
+	if cfg.TOTPIssuer == "" {
+		cfg.TOTPIssuer = flagConfig.TOTPIssuer
+	}
+	if cfg.AccessTokenTTL == 0 {
+		cfg.AccessTokenTTL = flagConfig.AccessTokenTTL
+	}
+	if cfg.RefreshTokenTTL == 0 {
+		cfg.RefreshTokenTTL = flagConfig.RefreshTokenTTL
+	}
+	if cfg.RevocationCacheTTL == 0 {
+		cfg.RevocationCacheTTL = flagConfig.RevocationCacheTTL
+	}
 	return cfg
 }
+
+// envSet отличает переменную окружения, заданную нулём, от незаданной.
+// Нужна настройкам, у которых 0 или false - осмысленное значение, а не "по умолчанию".
+func envSet(key string) bool {
+	_, ok := os.LookupEnv(key)
+	return ok
+}
@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type AccrualJob struct {
+	OrderNum  string
+	Attempts  int
+	CreatedAt time.Time
+}
+
+type AccrualStatus int
+
+func (status *AccrualStatus) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("unmarshal status: %w", err)
+	}
+	switch s {
+	case "REGISTERED":
+		*status = AccrualRegistered
+	case "INVALID":
+		*status = AccrualInvalid
+	case "PROCESSING":
+		*status = AccrualProcessing
+	case "PROCESSED":
+		*status = AccrualProcessed
+	default:
+		return fmt.Errorf("unmarshal status, unknown status")
+	}
+	return nil
+}
+
+func (status AccrualStatus) MarshalJSON() ([]byte, error) {
+	var s string
+	switch status {
+	case AccrualRegistered:
+		s = "REGISTERED"
+	case AccrualInvalid:
+		s = "INVALID"
+	case AccrualProcessing:
+		s = "PROCESSING"
+	case AccrualProcessed:
+		s = "PROCESSED"
+	}
+	return json.Marshal(s)
+}
+
+const (
+	AccrualRegistered AccrualStatus = iota
+	AccrualInvalid
+	AccrualProcessing
+	AccrualProcessed
+)
+
+type AccrualResponse struct {
+	OrderNum string        `json:"order"`
+	Status   AccrualStatus `json:"status"`
+	Accrual  Money         `json:"accrual"`
+	// Raw - тело ответа как его прислала система расчёта, сохраняется в истории статусов
+	Raw json.RawMessage `json:"-"`
+}
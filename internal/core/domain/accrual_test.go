@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccrualResponseUnmarshal(t *testing.T) {
+	j := `
+	{
+        "order": "367347345",
+        "status": "PROCESSED",
+        "accrual": 500
+    }
+	`
+	var ar AccrualResponse
+	err := json.Unmarshal([]byte(j), &ar)
+	require.NoError(t, err)
+
+	require.Equal(t, "367347345", ar.OrderNum)
+	require.Equal(t, AccrualProcessed, ar.Status)
+	require.Equal(t, Money(50000), ar.Accrual)
+}
+
+func TestAccrualResponseUnmarshalWithoutAccrual(t *testing.T) {
+	j := `
+	{
+        "order": "367347345",
+        "status": "PROCESSED"
+    }
+	`
+	var ar AccrualResponse
+	err := json.Unmarshal([]byte(j), &ar)
+	require.NoError(t, err)
+
+	require.Equal(t, "367347345", ar.OrderNum)
+	require.Equal(t, AccrualProcessed, ar.Status)
+	require.Equal(t, Money(0), ar.Accrual)
+}
+
+func TestAccrualResponseMarshal(t *testing.T) {
+	ar := AccrualResponse{
+		OrderNum: "367347345",
+		Status:   AccrualProcessing,
+		Accrual:  Money(14),
+	}
+	b, err := json.Marshal(ar)
+	require.NoError(t, err)
+	expected := `{"order":"367347345","status":"PROCESSING","accrual":0.14}`
+	require.Equal(t, expected, string(b))
+}
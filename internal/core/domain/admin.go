@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+type Role string
+
+const (
+	RoleUser Role = "user"
+	// RoleSupport видит пользователей, их заказы, баланс и списания, но ничего не меняет
+	RoleSupport Role = "support"
+	RoleAdmin   Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	return r == RoleUser || r == RoleSupport || r == RoleAdmin
+}
+
+// UserInfo - пользователь в админском API, у удалённого пользователя нет логина
+type UserInfo struct {
+	ID      int64  `json:"id"`
+	Login   string `json:"login,omitempty"`
+	Role    Role   `json:"role"`
+	Deleted bool   `json:"deleted"`
+}
+
+// BalanceAdjustment - ручная корректировка баланса: положительная начисляет баллы, отрицательная списывает
+type BalanceAdjustment struct {
+	Amount Money  `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+type AuditAction string
+
+const (
+	AuditRoleChanged     AuditAction = "ROLE_CHANGED"
+	AuditBalanceAdjusted AuditAction = "BALANCE_ADJUSTED"
+)
+
+// AuditEntry - действие сотрудника. ActorUID 0 - действие по X-Admin-Token без учётной записи.
+type AuditEntry struct {
+	ID        int64             `json:"id"`
+	ActorUID  int64             `json:"actor_uid,omitempty"`
+	Action    AuditAction       `json:"action"`
+	TargetUID int64             `json:"target_uid"`
+	Reason    string            `json:"reason,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
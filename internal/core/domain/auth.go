@@ -1,7 +1,79 @@
 package domain
 
+import "time"
+
 type User struct {
 	ID    int64
 	Login string
 	Hash  string
+	Role  Role
+}
+
+// Session - сессия пользователя, refresh токен хранится только в виде хеша
+type Session struct {
+	ID          string
+	UID         int64
+	RefreshHash string
+	ExpiresAt   time.Time
+}
+
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// Principal - пользователь и сессия, извлечённые из access токена
+type Principal struct {
+	UID       int64
+	SessionID string
+	TokenID   string
+	Role      Role
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// JWK - публичный ключ подписи в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type ThrottleScope string
+
+const (
+	ThrottleScopeLogin ThrottleScope = "LOGIN"
+	ThrottleScopeIP    ThrottleScope = "IP"
+)
+
+// ThrottleKey - счётчик неудачных попыток входа: по логину или по IP клиента
+type ThrottleKey struct {
+	Scope   ThrottleScope
+	Subject string
+}
+
+// TOTP - второй фактор пользователя. Пока секрет не подтверждён кодом, Enabled false и вход кода не требует.
+type TOTP struct {
+	UID     int64
+	Secret  string
+	Enabled bool
+	// LastUsedStep - шаг последнего принятого кода, коды этого и более ранних шагов повторно не принимаются
+	LastUsedStep int64
+}
+
+// TOTPEnrollment - секрет для приложения-аутентификатора, URL обычно показывается QR-кодом
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"otpauth_url"`
 }
@@ -1,6 +1,27 @@
 package domain
 
+import "time"
+
 type Balance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   Money `json:"current"`
+	Withdrawn Money `json:"withdrawn"`
+}
+
+type PostingKind string
+
+const (
+	PostingAccrual    PostingKind = "ACCRUAL"
+	PostingWithdrawal PostingKind = "WITHDRAWAL"
+	PostingReversal   PostingKind = "REVERSAL"
+	PostingAdjustment PostingKind = "ADJUSTMENT"
+)
+
+// Posting - проводка в журнале баланса. Начисления положительные, списания отрицательные,
+// Balance - остаток после проводки.
+type Posting struct {
+	OrderNum  string      `json:"order,omitempty"`
+	Kind      PostingKind `json:"kind"`
+	Amount    Money       `json:"amount"`
+	Balance   Money       `json:"balance"`
+	CreatedAt time.Time   `json:"created_at"`
 }
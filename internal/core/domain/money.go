@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+
+	"github.com/shopspring/decimal"
+)
+
+// Money - денежная сумма в копейках. Все суммы округляются до копеек половиной от нуля
+// (0.005 -> 0.01, -0.005 -> -0.01) при разборе JSON, чтении из базы и конвертации из float64,
+// дальше арифметика только целочисленная.
+// В JSON - число без лишних нулей (500, 0.1, 729.98), в базе - NUMERIC.
+type Money int64
+
+func NewMoneyFromDecimal(d decimal.Decimal) (Money, error) {
+	kopecks := d.Round(2).Shift(2)
+	if kopecks.GreaterThan(decimal.NewFromInt(math.MaxInt64)) || kopecks.LessThan(decimal.NewFromInt(math.MinInt64)) {
+		return 0, fmt.Errorf("money out of range: %s", d.String())
+	}
+	return Money(kopecks.IntPart()), nil
+}
+
+func ParseMoney(s string) (Money, error) {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse money: %w", err)
+	}
+	return NewMoneyFromDecimal(d)
+}
+
+// NewMoneyFromFloat берёт кратчайшее десятичное представление float64, поэтому 0.1 остаётся 0.1
+func NewMoneyFromFloat(f float64) (Money, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("money from float: %v", f)
+	}
+	return NewMoneyFromDecimal(decimal.NewFromFloat(f))
+}
+
+func (m Money) Decimal() decimal.Decimal {
+	return decimal.New(int64(m), -2)
+}
+
+func (m Money) String() string {
+	return m.Decimal().String()
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	v, err := ParseMoney(string(b))
+	if err != nil {
+		return fmt.Errorf("unmarshal money: %w", err)
+	}
+	*m = v
+	return nil
+}
+
+// Scan implements the database/sql Scanner interface.
+func (m *Money) Scan(src any) error {
+	var (
+		v   Money
+		err error
+	)
+	switch src := src.(type) {
+	case nil:
+		v = 0
+	case string:
+		v, err = ParseMoney(src)
+	case []byte:
+		v, err = ParseMoney(string(src))
+	case int64:
+		v, err = NewMoneyFromDecimal(decimal.NewFromInt(src))
+	case float64:
+		v, err = NewMoneyFromFloat(src)
+	default:
+		err = fmt.Errorf("unsupported type %T", src)
+	}
+	if err != nil {
+		return fmt.Errorf("scan money: %w", err)
+	}
+	*m = v
+	return nil
+}
+
+// Value implements the database/sql/driver Valuer interface.
+// Без него pgx закодировал бы Money как int64, то есть в копейках.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
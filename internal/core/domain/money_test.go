@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoneyRounding(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"0", 0},
+		{"500", 50000},
+		{"729.98", 72998},
+		{"0.005", 1},
+		{"0.0049999", 0},
+		{"-0.005", -1},
+		{"1.2350000000", 124},
+		{"1e2", 10000},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		require.NoError(t, err, tt.in)
+		require.Equal(t, tt.want, got, tt.in)
+	}
+
+	_, err := ParseMoney("abc")
+	require.Error(t, err)
+	_, err = ParseMoney("1e30")
+	require.Error(t, err)
+}
+
+func TestMoneyFromFloatNoDrift(t *testing.T) {
+	a, err := NewMoneyFromFloat(0.1)
+	require.NoError(t, err)
+	b, err := NewMoneyFromFloat(0.2)
+	require.NoError(t, err)
+	require.Equal(t, Money(30), a+b)
+	require.Equal(t, "0.3", (a + b).String())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	b, err := json.Marshal(Balance{Current: 50000, Withdrawn: 10})
+	require.NoError(t, err)
+	require.Equal(t, `{"current":500,"withdrawn":0.1}`, string(b))
+
+	b, err = json.Marshal(Posting{Kind: PostingWithdrawal, Amount: -72998, Balance: 1})
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"amount":-729.98,"balance":0.01`)
+
+	data := WithdrawData{}
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":751.555}`), &data))
+	require.Equal(t, Money(75156), data.Sum)
+
+	require.Error(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":"751"}`), &data))
+}
+
+func TestMoneyPostgresNumeric(t *testing.T) {
+	m := pgtype.NewMap()
+	for _, format := range []int16{pgtype.TextFormatCode, pgtype.BinaryFormatCode} {
+		buf, err := m.Encode(pgtype.NumericOID, format, Money(-72998), nil)
+		require.NoError(t, err)
+
+		var n pgtype.Numeric
+		require.NoError(t, m.Scan(pgtype.NumericOID, format, buf, &n))
+		f, err := n.Float64Value()
+		require.NoError(t, err)
+		require.Equal(t, -729.98, f.Float64)
+
+		var got Money
+		require.NoError(t, m.Scan(pgtype.NumericOID, format, buf, &got))
+		require.Equal(t, Money(-72998), got)
+	}
+
+	var got Money = 1
+	require.NoError(t, got.Scan(nil))
+	require.Equal(t, Money(0), got)
+	require.NoError(t, got.Scan("0.1000000000"))
+	require.Equal(t, Money(10), got)
+}
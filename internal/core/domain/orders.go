@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type OrderNum string
 
@@ -11,11 +14,81 @@ const (
 	Processing Status = "PROCESSING"
 	Invalid    Status = "INVALID"
 	Processed  Status = "PROCESSED"
+	// FailedCheck - система расчёта так и не дала ответ за отведённое число попыток
+	FailedCheck Status = "FAILED_CHECK"
 )
 
 type Order struct {
+	ID         int64     `json:"-"`
 	Number     string    `json:"number"`
 	Status     Status    `json:"status"`
-	Accrual    float64   `json:"accrual,omitempty"`
+	Accrual    Money     `json:"accrual,omitempty"`
 	UploadedAt time.Time `json:"uploaded_at"`
+	// UID - владелец, заполняется только при поиске заказа по номеру
+	UID int64 `json:"-"`
+	// History заполняется только при поиске заказа по номеру, от старых переходов к новым
+	History []OrderStatusChange `json:"history,omitempty"`
+}
+
+// OrderStatusChange - переход заказа в статус. Accrual есть только у PROCESSED.
+// У записей, перенесённых из заказов до появления истории, Source пустой.
+// RawResponse - ответ системы расчёта, пользователю не отдаётся, только сотрудникам.
+type OrderStatusChange struct {
+	Status      Status          `json:"status"`
+	Accrual     Money           `json:"accrual,omitempty"`
+	Source      StatusSource    `json:"source,omitempty"`
+	RawResponse json.RawMessage `json:"-"`
+	ChangedAt   time.Time       `json:"changed_at"`
+}
+
+// StatusSource - кто перевёл заказ в статус
+type StatusSource string
+
+const (
+	// SourceUser - загрузка заказа пользователем
+	SourceUser    StatusSource = "user"
+	SourcePoller  StatusSource = "poller"
+	SourceWebhook StatusSource = "webhook"
+	SourceAdmin   StatusSource = "admin"
+)
+
+// StatusTransition - смена статуса заказа. From - статус, для которого сервис проверил переход,
+// репозиторий применяет смену, только если заказ всё ещё в нём.
+type StatusTransition struct {
+	OrderNum    string
+	From        Status
+	To          Status
+	Accrual     Money
+	Source      StatusSource
+	RawResponse json.RawMessage
+}
+
+// Cursor - позиция в списке, отсортированном от новых записей к старым. ID различает записи с одним временем.
+type Cursor struct {
+	At time.Time
+	ID int64
+}
+
+// OrdersQuery - фильтр списка заказов. From включительно, To не включительно, нулевое время - без границы.
+// Limit 0 - все подходящие заказы одним списком.
+type OrdersQuery struct {
+	Statuses []Status
+	From     time.Time
+	To       time.Time
+	After    *Cursor
+	Limit    int
+}
+
+// OrdersPage - страница заказов, Next nil на последней странице
+type OrdersPage struct {
+	Orders []Order
+	Next   *Cursor
+}
+
+func (status Status) Valid() bool {
+	switch status {
+	case New, Processing, Invalid, Processed, FailedCheck:
+		return true
+	}
+	return false
 }
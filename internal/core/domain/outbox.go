@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const AccrualCreditedEvent = "accrual.credited"
+
+type OutboxEvent struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type AccrualCredited struct {
+	OrderNum string `json:"order"`
+	UID      int64  `json:"uid"`
+	Accrual  Money  `json:"accrual"`
+}
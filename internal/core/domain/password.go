@@ -0,0 +1,17 @@
+package domain
+
+// PasswordRejectCode - причина отклонения пароля, отдаётся клиенту как есть
+type PasswordRejectCode string
+
+const (
+	PasswordTooShort       PasswordRejectCode = "too_short"
+	PasswordTooLong        PasswordRejectCode = "too_long"
+	PasswordLowEntropy     PasswordRejectCode = "low_entropy"
+	PasswordCommon         PasswordRejectCode = "common_password"
+	PasswordSimilarToLogin PasswordRejectCode = "similar_to_login"
+)
+
+type PasswordRejection struct {
+	Code    PasswordRejectCode `json:"code"`
+	Message string             `json:"message"`
+}
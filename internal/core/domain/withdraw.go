@@ -2,8 +2,36 @@ package domain
 
 import "time"
 
+type WithdrawStatus string
+
+const (
+	WithdrawProcessed WithdrawStatus = "PROCESSED"
+	// WithdrawCancelled - списание отменено, баллы вернулись на баланс
+	WithdrawCancelled WithdrawStatus = "CANCELLED"
+)
+
 type WithdrawData struct {
-	OrderNum    string    `json:"order"`
-	Sum         float64   `json:"sum"`
-	ProcessedAt time.Time `json:"processed_at"`
+	ID          int64          `json:"-"`
+	OrderNum    string         `json:"order"`
+	Sum         Money          `json:"sum"`
+	Status      WithdrawStatus `json:"status,omitempty"`
+	ProcessedAt time.Time      `json:"processed_at"`
+	CancelledAt *time.Time     `json:"cancelled_at,omitempty"`
+}
+
+// WithdrawalsQuery - фильтр списка списаний. From включительно, To не включительно, нулевое время - без границы.
+// MinSum и MaxSum включительно, 0 - без границы. Limit 0 - все подходящие списания одним списком.
+type WithdrawalsQuery struct {
+	From   time.Time
+	To     time.Time
+	MinSum Money
+	MaxSum Money
+	After  *Cursor
+	Limit  int
+}
+
+// WithdrawalsPage - страница списаний, Next nil на последней странице
+type WithdrawalsPage struct {
+	Withdrawals []*WithdrawData
+	Next        *Cursor
 }
@@ -0,0 +1,70 @@
+package ports
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+)
+
+type AccrualRepository interface {
+	// ClaimJobs берёт в аренду до limit задач, время попытки которых наступило.
+	// Задача, захваченная одним экземпляром, не видна другим до истечения аренды.
+	ClaimJobs(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.AccrualJob, error)
+	RescheduleJob(ctx context.Context, orderNum string, owner string, delay time.Duration) error
+	// PostponeJob откладывает задачу и снимает аренду, не засчитывая попытку:
+	// заказ не проверялся, система расчёта отказала по лимиту запросов
+	PostponeJob(ctx context.Context, orderNum string, owner string, delay time.Duration) error
+	ReleaseJobs(ctx context.Context, owner string) error
+	// DeferJobs откладывает следующую проверку заказов, задачи в аренде не трогает
+	DeferJobs(ctx context.Context, orderNums []string, delay time.Duration) error
+	// GetOrderStatus отдаёт текущий статус заказа, неизвестный номер - ErrOrderNotFound
+	GetOrderStatus(ctx context.Context, orderNum string) (domain.Status, error)
+	// Методы смены статуса пишут переход в историю и возвращают ErrStatusChanged,
+	// если заказ уже не в статусе transition.From. Допустимость перехода проверяет сервис.
+
+	// FailJob удаляет задачу и переводит заказ в FAILED_CHECK, RequeueFailed возвращает его в очередь
+	FailJob(ctx context.Context, transition *domain.StatusTransition, owner string) error
+	GetFailedOrders(ctx context.Context) ([]domain.Order, error)
+	RequeueFailed(ctx context.Context, transition *domain.StatusTransition) error
+	// WriteInvalid и WriteProcessed переводят заказ в конечный статус и удаляют задачу из очереди
+	WriteInvalid(ctx context.Context, transition *domain.StatusTransition) error
+	WriteProcessing(ctx context.Context, transition *domain.StatusTransition) error
+	// WriteProcessed атомарно переводит заказ в PROCESSED, начисляет transition.Accrual и пишет событие в outbox.
+	// Начисление по заказу проводится не больше одного раза, замороженный баланс удалённого пользователя не пополняется.
+	WriteProcessed(ctx context.Context, transition *domain.StatusTransition) error
+}
+
+type OutboxRepository interface {
+	GetEvents(ctx context.Context, afterID int64, limit int) ([]domain.OutboxEvent, error)
+}
+
+var ErrStatusChanged = errors.New("order status changed concurrently")
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+var ErrAccrualNotRegistered = errors.New("order is not registered in accrual system")
+var ErrAccrualRateLimited = errors.New("accrual system rate limit")
+
+// AccrualRateLimitError - ответ 429, errors.Is(err, ErrAccrualRateLimited) для него истинно.
+// RetryAfter - когда система расчёта снова примет запрос, 0 - неизвестно.
+type AccrualRateLimitError struct {
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (err *AccrualRateLimitError) Error() string {
+	return ErrAccrualRateLimited.Error() + ": " + err.Message
+}
+
+func (err *AccrualRateLimitError) Unwrap() error {
+	return ErrAccrualRateLimited
+}
+
+// AccrualClient - доступ к системе расчёта начислений
+type AccrualClient interface {
+	// GetOrderAccrual возвращает ErrAccrualNotRegistered, если система расчёта ещё не знает о заказе,
+	// и ErrAccrualRateLimited, если превышен лимит запросов.
+	// Таймаут самого запроса задаёт реализация, ctx только отменяет вызов.
+	GetOrderAccrual(ctx context.Context, orderNum string) (*domain.AccrualResponse, error)
+}
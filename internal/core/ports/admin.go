@@ -0,0 +1,40 @@
+package ports
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+)
+
+var ErrInvalidRole = errors.New("invalid role")
+var ErrInvalidAdjustment = errors.New("invalid balance adjustment")
+var ErrSelfDemotion = errors.New("admin can not demote themselves")
+
+// AdminService - действия сотрудников над чужими учётными записями. actor - кто действует,
+// UID 0 - запрос по X-Admin-Token. Изменения пишутся в журнал аудита в той же транзакции.
+type AdminService interface {
+	// SearchUsers ищет по подстроке логина, пустой query возвращает последних зарегистрированных
+	SearchUsers(ctx context.Context, query string, limit int) ([]domain.UserInfo, error)
+	GetUser(ctx context.Context, uid int64) (*domain.UserInfo, error)
+	// SetRole меняет роль и отзывает токены пользователя, чтобы новая роль действовала сразу.
+	// ErrSelfDemotion - admin снимает роль admin с себя.
+	SetRole(ctx context.Context, actor *domain.Principal, uid int64, role domain.Role) error
+	// AdjustBalance начисляет или списывает баллы вне заказов. ErrNotEnoughMoney - баланс ушёл бы в минус.
+	AdjustBalance(ctx context.Context, actor *domain.Principal, uid int64, adjustment domain.BalanceAdjustment) (*domain.Balance, error)
+	// GetAuditLog возвращает записи от новых к старым, targetUID 0 - по всем пользователям
+	GetAuditLog(ctx context.Context, targetUID int64, limit int) ([]domain.AuditEntry, error)
+	// GetOutboxEvents отдаёт события outbox после afterID в порядке записи.
+	// Потребитель хранит id последнего обработанного события и передаёт его при следующем чтении.
+	GetOutboxEvents(ctx context.Context, afterID int64, limit int) ([]domain.OutboxEvent, error)
+}
+
+type AdminRepository interface {
+	SearchUsers(ctx context.Context, query string, limit int) ([]domain.UserInfo, error)
+	// GetUser возвращает и удалённых пользователей, ErrUserNotFound - пользователя не было
+	GetUser(ctx context.Context, uid int64) (*domain.UserInfo, error)
+	SetRole(ctx context.Context, uid int64, role domain.Role, entry *domain.AuditEntry) error
+	// AdjustBalance меняет баланс только у неудалённого пользователя и добавляет проводку ADJUSTMENT
+	AdjustBalance(ctx context.Context, uid int64, amount domain.Money, entry *domain.AuditEntry) (*domain.Balance, error)
+	GetAuditLog(ctx context.Context, targetUID int64, limit int) ([]domain.AuditEntry, error)
+}
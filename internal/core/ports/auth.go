@@ -3,6 +3,9 @@ package ports
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 )
@@ -15,14 +18,175 @@ var ErrUserNotFound = errors.New("user not found")
 var ErrPasswordTooShort = errors.New("the password is too short")
 var ErrPasswordTooLong = errors.New("the password is too long")
 var ErrInvalidPassword = errors.New("invalid password")
+var ErrInvalidToken = errors.New("invalid token")
+var ErrTooManyLoginAttempts = errors.New("too many login attempts")
+var ErrCommonPassword = errors.New("the password is too common")
+var ErrPasswordSimilarToLogin = errors.New("the password is similar to the login")
+var ErrSecondFactorRequired = errors.New("second factor required")
+var ErrInvalidSecondFactor = errors.New("invalid second factor code")
+var ErrSecondFactorAlreadyEnabled = errors.New("second factor is already enabled")
+var ErrSecondFactorNotEnabled = errors.New("second factor is not enabled")
+
+// MFARequiredError - пароль верный, но у пользователя включён второй фактор. Token - частичная сессия,
+// которая вместе с кодом обменивается на токены через LoginSecondFactor до ExpiresAt.
+type MFARequiredError struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (e *MFARequiredError) Error() string {
+	return fmt.Sprintf("%v, partial session expires at %v", ErrSecondFactorRequired, e.ExpiresAt)
+}
+
+func (e *MFARequiredError) Unwrap() error {
+	return ErrSecondFactorRequired
+}
+
+// LoginLockedError - вход временно заблокирован после неудачных попыток, повторить можно через RetryAfter
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrTooManyLoginAttempts, e.RetryAfter)
+}
+
+func (e *LoginLockedError) Unwrap() error {
+	return ErrTooManyLoginAttempts
+}
+
+// PasswordPolicyError перечисляет все нарушенные правила пароля. errors.Is находит
+// по нему ErrPasswordTooShort, ErrPasswordTooLong, ErrLowPasswordStrength и остальные ошибки пароля.
+type PasswordPolicyError struct {
+	Rejections []domain.PasswordRejection
+}
+
+func (e *PasswordPolicyError) Error() string {
+	codes := make([]string, 0, len(e.Rejections))
+	for _, r := range e.Rejections {
+		codes = append(codes, string(r.Code))
+	}
+	return "password policy: " + strings.Join(codes, ", ")
+}
+
+func (e *PasswordPolicyError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Rejections))
+	for _, r := range e.Rejections {
+		switch r.Code {
+		case domain.PasswordTooShort:
+			errs = append(errs, ErrPasswordTooShort)
+		case domain.PasswordTooLong:
+			errs = append(errs, ErrPasswordTooLong)
+		case domain.PasswordLowEntropy:
+			errs = append(errs, ErrLowPasswordStrength)
+		case domain.PasswordCommon:
+			errs = append(errs, ErrCommonPassword)
+		case domain.PasswordSimilarToLogin:
+			errs = append(errs, ErrPasswordSimilarToLogin)
+		}
+	}
+	return errs
+}
 
 type AuthService interface {
-	Register(ctx context.Context, login, password string) (string, error)
-	Login(ctx context.Context, login, password string) (string, error)
-	GetUserGromJWT(ctx context.Context, jwt string) (int64, error)
+	Register(ctx context.Context, login, password string) (*domain.TokenPair, error)
+	// Login считает неудачные попытки по логину и clientIP и возвращает LoginLockedError, пока вход заблокирован.
+	// Если включён второй фактор, токены не выдаются, а возвращается MFARequiredError.
+	Login(ctx context.Context, login, password, clientIP string) (*domain.TokenPair, error)
+	// LoginSecondFactor завершает вход по частичной сессии из MFARequiredError и коду TOTP или коду восстановления
+	LoginSecondFactor(ctx context.Context, mfaToken, code, clientIP string) (*domain.TokenPair, error)
+	// EnrollTOTP после подтверждения паролем создаёт новый секрет,
+	// второй фактор включается после подтверждения кодом в ConfirmTOTP
+	EnrollTOTP(ctx context.Context, principal *domain.Principal, password string) (*domain.TOTPEnrollment, error)
+	// ConfirmTOTP включает второй фактор и возвращает одноразовые коды восстановления, они показываются один раз
+	ConfirmTOTP(ctx context.Context, principal *domain.Principal, code string) ([]string, error)
+	// DisableTOTP выключает второй фактор после подтверждения паролем и кодом
+	DisableTOTP(ctx context.Context, principal *domain.Principal, password, code string) error
+	SecondFactorVerifier
+	// ChangePassword проверяет старый пароль и отзывает все сессии пользователя, кроме текущей
+	ChangePassword(ctx context.Context, principal *domain.Principal, oldPassword, newPassword string) error
+	// DeleteUser обезличивает пользователя и замораживает баланс, история заказов и списаний остаётся
+	DeleteUser(ctx context.Context, principal *domain.Principal, password string) error
+	// UnlockLogin сбрасывает счётчик неудачных попыток входа и блокировку логина пользователя uid
+	UnlockLogin(ctx context.Context, uid int64) error
+	// Refresh выдаёт новую пару токенов, предъявленный refresh токен больше не действует
+	Refresh(ctx context.Context, refreshToken string) (*domain.TokenPair, error)
+	// Logout отзывает сессию и предъявленный access токен
+	Logout(ctx context.Context, principal *domain.Principal) error
+	// RevokeUserSessions отзывает все сессии и все выданные до этого момента токены пользователя
+	RevokeUserSessions(ctx context.Context, uid int64) error
+	SessionRevoker
+	// Authenticate возвращает ErrInvalidToken для просроченного токена или токена отозванной сессии
+	Authenticate(ctx context.Context, accessToken string) (*domain.Principal, error)
+	// JWKS возвращает публичные ключи, которыми другие сервисы проверяют access токены
+	JWKS() *domain.JWKSet
+}
+
+// SecondFactorVerifier подтверждает действия вторым фактором
+type SecondFactorVerifier interface {
+	// RequireSecondFactor проверяет код, если у пользователя включён второй фактор, иначе ничего не делает.
+	// ErrSecondFactorRequired - код не передан, ErrInvalidSecondFactor - код неверный или уже использован.
+	RequireSecondFactor(ctx context.Context, uid int64, code string) error
+}
+
+// SessionRevoker отзывает сессии пользователя по его id, например после смены роли
+type SessionRevoker interface {
+	RevokeUserSessionsByID(ctx context.Context, uid int64) error
 }
 
 type AuthRepository interface {
 	CreateUser(ctx context.Context, user *domain.User) (*domain.User, error)
 	GetUserByLogin(ctx context.Context, login string) (*domain.User, error)
+	GetUserByID(ctx context.Context, uid int64) (*domain.User, error)
+	// ChangePassword меняет хеш пароля и отзывает сессии пользователя, кроме keepSessionID
+	ChangePassword(ctx context.Context, uid int64, hash string, keepSessionID string) error
+	// UpdatePasswordHash заменяет хеш тем же паролем, если он не изменился с oldHash, сессии не трогает
+	UpdatePasswordHash(ctx context.Context, uid int64, oldHash, newHash string) error
+	// DeleteUser обнуляет логин и хеш, замораживает баланс, отзывает все сессии и удаляет второй фактор
+	DeleteUser(ctx context.Context, uid int64) error
+}
+
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *domain.Session) error
+	// RotateSession заменяет refresh токен сессии. Повторное предъявление уже заменённого токена
+	// означает его утечку: сессия отзывается и возвращается ErrInvalidToken.
+	RotateSession(ctx context.Context, refreshHash string, newRefreshHash string, expiresAt time.Time) (*domain.Session, error)
+	RevokeSession(ctx context.Context, sessionID string) error
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+type SecondFactorRepository interface {
+	// SaveTOTPSecret заменяет неподтверждённый секрет, ErrSecondFactorAlreadyEnabled - второй фактор уже включён
+	SaveTOTPSecret(ctx context.Context, uid int64, secret string) error
+	// GetTOTP возвращает и неподтверждённый секрет, ErrSecondFactorNotEnabled - секрета нет
+	GetTOTP(ctx context.Context, uid int64) (*domain.TOTP, error)
+	// EnableTOTP включает второй фактор, если секрет всё ещё secret и не подтверждён, и заменяет коды восстановления.
+	// step - шаг кода, которым подтверждён секрет. ErrInvalidSecondFactor - секрет успели заменить.
+	EnableTOTP(ctx context.Context, uid int64, secret string, step int64, recoveryCodeHashes []string) error
+	// UseTOTPStep принимает шаг, только если он позже последнего принятого, false - код уже использован
+	UseTOTPStep(ctx context.Context, uid int64, step int64) (bool, error)
+	// UseRecoveryCode помечает код использованным, false - кода нет или он уже использован
+	UseRecoveryCode(ctx context.Context, uid int64, codeHash string) (bool, error)
+	// DisableTOTP удаляет секрет и коды восстановления
+	DisableTOTP(ctx context.Context, uid int64) error
+}
+
+type LoginThrottleRepository interface {
+	// Attempt засчитывает попытку до проверки пароля или кода и в той же транзакции блокирует ключ
+	// на lockout(номер попытки), параллельная попытка дожидается её и видит блокировку.
+	// Счётчик без попыток дольше window начинается заново. Заблокированный ключ попытку не засчитывает,
+	// тогда возвращается 0 и оставшееся время блокировки.
+	Attempt(ctx context.Context, key domain.ThrottleKey, window time.Duration, lockout func(failures int) time.Duration) (int, time.Duration, error)
+	// Forgive отменяет засчитанную попытку, unlock снимает выставленную ею блокировку
+	Forgive(ctx context.Context, key domain.ThrottleKey, unlock bool) error
+	Reset(ctx context.Context, key domain.ThrottleKey) error
+}
+
+type RevocationRepository interface {
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+	// RevokeUserTokens отзывает все сессии пользователя, токены выпущенные раньше validAfter перестают действовать
+	RevokeUserTokens(ctx context.Context, uid int64, validAfter time.Time) error
+	// TokensValidAfter возвращает нулевое время, если токены пользователя не отзывались
+	TokensValidAfter(ctx context.Context, uid int64) (time.Time, error)
 }
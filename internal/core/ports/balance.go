@@ -8,8 +8,10 @@ import (
 
 type BalanceService interface {
 	GetBalance(ctx context.Context, uid int64) (*domain.Balance, error)
+	GetHistory(ctx context.Context, uid int64) ([]domain.Posting, error)
 }
 
 type BalanceRepository interface {
 	GetBalance(ctx context.Context, uid int64) (*domain.Balance, error)
+	GetHistory(ctx context.Context, uid int64) ([]domain.Posting, error)
 }
@@ -3,6 +3,7 @@ package ports
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 )
@@ -14,6 +15,8 @@ const (
 	Ok
 	NotOwnOrder
 	Err
+	// InvalidNumber - номер не прошёл проверку Луна, только в результатах пакетной загрузки
+	InvalidNumber
 )
 
 type UserOrder struct {
@@ -23,13 +26,33 @@ type UserOrder struct {
 
 var ErrInvalidOrderNum = errors.New("invalid orders num")
 var ErrInternalError = errors.New("internal error")
+var ErrOrderNotFound = errors.New("order not found")
+var ErrNotOwnOrder = errors.New("order uploaded by another user")
+var ErrOrdersBatchTooLarge = errors.New("too many orders in batch")
 
 type OrdersService interface {
 	CreateOrder(ctx context.Context, uid int64, orderNum string) (Status, error)
-	GetOrders(ctx context.Context, uid int64) ([]domain.Order, error)
+	// CreateOrders загружает пачку номеров, статус на каждый номер в порядке запроса.
+	// Повтор номера в пачке - AlreadyAdded.
+	CreateOrders(ctx context.Context, uid int64, orderNums []string) ([]Status, error)
+	// GetOrders отдаёт заказы от новых к старым, Limit больше допустимого уменьшается
+	GetOrders(ctx context.Context, uid int64, query domain.OrdersQuery) (*domain.OrdersPage, error)
+	// GetOrder отдаёт заказ пользователя с историей статусов, чужой заказ - ErrNotOwnOrder
+	GetOrder(ctx context.Context, uid int64, orderNum string) (*domain.Order, error)
+	GetFailedOrders(ctx context.Context) ([]domain.Order, error)
+	RequeueOrder(ctx context.Context, orderNum string) error
+	// ApplyAccrual применяет статус, присланный системой расчёта в callback
+	ApplyAccrual(ctx context.Context, ar *domain.AccrualResponse) error
 }
 
 type OrdersRepository interface {
-	CreateOrder(ctx context.Context, uid int64, orderNum string) (*UserOrder, error)
-	GetOrders(ctx context.Context, uid int64) ([]domain.Order, error)
+	// CreateOrder и CreateOrders вместе с заказом ставят задачу проверки начисления,
+	// первая проверка - не раньше чем через checkDelay
+	CreateOrder(ctx context.Context, uid int64, orderNum string, checkDelay time.Duration) (*UserOrder, error)
+	// CreateOrders вставляет все новые номера одним запросом, результат по каждому различному номеру
+	CreateOrders(ctx context.Context, uid int64, orderNums []string, checkDelay time.Duration) (map[string]UserOrder, error)
+	// GetOrders отдаёт не больше query.Limit заказов, 0 - без ограничения
+	GetOrders(ctx context.Context, uid int64, query domain.OrdersQuery) ([]domain.Order, error)
+	// GetOrder отдаёт заказ с владельцем и историей статусов, неизвестный номер - ErrOrderNotFound
+	GetOrder(ctx context.Context, orderNum string) (*domain.Order, error)
 }
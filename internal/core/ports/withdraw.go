@@ -3,20 +3,32 @@ package ports
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 )
 
 var ErrNotEnoughMoney = errors.New("not enough money")
 var ErrDuplicateOrderNumber = errors.New("duplicate order number")
-var ErrSumIsNegative = errors.New("sum is negative")
+var ErrSumNotPositive = errors.New("sum must be positive")
+var ErrWithdrawalNotFound = errors.New("withdrawal not found")
+var ErrWithdrawalNotCancellable = errors.New("withdrawal can not be cancelled")
 
 type WithdrawService interface {
-	Withdraw(ctx context.Context, uid int64, data *domain.WithdrawData) error
-	GetWithdrawals(ctx context.Context, uid int64) ([]*domain.WithdrawData, error)
+	// Withdraw проверяет secondFactorCode для крупных списаний, см. SecondFactorVerifier
+	Withdraw(ctx context.Context, uid int64, data *domain.WithdrawData, secondFactorCode string) error
+	// GetWithdrawals отдаёт списания от новых к старым, Limit больше допустимого уменьшается
+	GetWithdrawals(ctx context.Context, uid int64, query domain.WithdrawalsQuery) (*domain.WithdrawalsPage, error)
+	Cancel(ctx context.Context, uid int64, orderNum string) error
 }
 
 type WithdrawRepository interface {
 	Withdraw(ctx context.Context, uid int64, data *domain.WithdrawData) error
-	GetWithdrawals(ctx context.Context, uid int64) ([]*domain.WithdrawData, error)
+	// CheckWithdraw без блокировки проверяет, что списание сейчас прошло бы: ErrNotEnoughMoney, ErrDuplicateOrderNumber
+	CheckWithdraw(ctx context.Context, uid int64, data *domain.WithdrawData) error
+	// GetWithdrawals отдаёт не больше query.Limit списаний, 0 - без ограничения
+	GetWithdrawals(ctx context.Context, uid int64, query domain.WithdrawalsQuery) ([]*domain.WithdrawData, error)
+	// Cancel помечает списание CANCELLED и возвращает баллы на баланс, если с момента списания прошло не больше window.
+	// ErrWithdrawalNotCancellable - списание уже отменено или окно истекло.
+	Cancel(ctx context.Context, uid int64, orderNum string, window time.Duration) error
 }
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+const (
+	defaultAdminListLimit = 50
+	maxAdminListLimit     = 200
+	maxAdjustmentReason   = 500
+)
+
+type AdminService struct {
+	repository ports.AdminRepository
+	outbox     ports.OutboxRepository
+	sessions   ports.SessionRevoker
+}
+
+func NewAdminService(repository ports.AdminRepository, outbox ports.OutboxRepository, sessions ports.SessionRevoker) *AdminService {
+	return &AdminService{
+		repository: repository,
+		outbox:     outbox,
+		sessions:   sessions,
+	}
+}
+
+var _ ports.AdminService = (*AdminService)(nil)
+
+func (service *AdminService) SearchUsers(ctx context.Context, query string, limit int) ([]domain.UserInfo, error) {
+	users, err := service.repository.SearchUsers(ctx, strings.TrimSpace(query), adminListLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("admin service, search users: %w", err)
+	}
+	return users, nil
+}
+
+func (service *AdminService) GetUser(ctx context.Context, uid int64) (*domain.UserInfo, error) {
+	user, err := service.repository.GetUser(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("admin service, get user: %w", err)
+	}
+	return user, nil
+}
+
+func (service *AdminService) SetRole(ctx context.Context, actor *domain.Principal, uid int64, role domain.Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("%w: admin service, set role, %q", ports.ErrInvalidRole, role)
+	}
+	// понижать можно только других: тот, кто понижает, сам остаётся admin, и без admin система не останется
+	if actor.UID == uid && role != domain.RoleAdmin {
+		return fmt.Errorf("%w: admin service, set role, uid %d", ports.ErrSelfDemotion, uid)
+	}
+	user, err := service.repository.GetUser(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("admin service, set role: %w", err)
+	}
+	if user.Deleted {
+		return fmt.Errorf("%w: admin service, set role, uid %d is deleted", ports.ErrUserNotFound, uid)
+	}
+	if user.Role == role {
+		return nil
+	}
+	entry := &domain.AuditEntry{
+		ActorUID:  actor.UID,
+		Action:    domain.AuditRoleChanged,
+		TargetUID: uid,
+		Details:   map[string]string{"from": string(user.Role), "to": string(role)},
+	}
+	if err = service.repository.SetRole(ctx, uid, role, entry); err != nil {
+		return fmt.Errorf("admin service, set role: %w", err)
+	}
+	// роль зашита в access токены, без отзыва понижение вступило бы в силу только по истечении токенов
+	if err = service.sessions.RevokeUserSessionsByID(ctx, uid); err != nil {
+		return fmt.Errorf("admin service, set role: %w", err)
+	}
+	return nil
+}
+
+func (service *AdminService) AdjustBalance(ctx context.Context, actor *domain.Principal, uid int64, adjustment domain.BalanceAdjustment) (*domain.Balance, error) {
+	reason := strings.TrimSpace(adjustment.Reason)
+	if adjustment.Amount == 0 {
+		return nil, fmt.Errorf("%w: admin service, adjust balance, zero amount", ports.ErrInvalidAdjustment)
+	}
+	if reason == "" || len(reason) > maxAdjustmentReason {
+		return nil, fmt.Errorf("%w: admin service, adjust balance, reason length %d", ports.ErrInvalidAdjustment, len(reason))
+	}
+	entry := &domain.AuditEntry{
+		ActorUID:  actor.UID,
+		Action:    domain.AuditBalanceAdjusted,
+		TargetUID: uid,
+		Reason:    reason,
+		Details:   map[string]string{"amount": adjustment.Amount.String()},
+	}
+	balance, err := service.repository.AdjustBalance(ctx, uid, adjustment.Amount, entry)
+	if err != nil {
+		return nil, fmt.Errorf("admin service, adjust balance: %w", err)
+	}
+	return balance, nil
+}
+
+func (service *AdminService) GetAuditLog(ctx context.Context, targetUID int64, limit int) ([]domain.AuditEntry, error) {
+	entries, err := service.repository.GetAuditLog(ctx, targetUID, adminListLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("admin service, get audit log: %w", err)
+	}
+	return entries, nil
+}
+
+func (service *AdminService) GetOutboxEvents(ctx context.Context, afterID int64, limit int) ([]domain.OutboxEvent, error) {
+	if afterID < 0 {
+		afterID = 0
+	}
+	events, err := service.outbox.GetEvents(ctx, afterID, adminListLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("admin service, get outbox events: %w", err)
+	}
+	return events, nil
+}
+
+func adminListLimit(limit int) int {
+	if limit <= 0 {
+		return defaultAdminListLimit
+	}
+	return min(limit, maxAdminListLimit)
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAdminRepository struct {
+	users    map[int64]*domain.UserInfo
+	balances map[int64]domain.Money
+	audit    []domain.AuditEntry
+	limit    int
+}
+
+var _ ports.AdminRepository = (*fakeAdminRepository)(nil)
+
+func newFakeAdminRepository(users ...domain.UserInfo) *fakeAdminRepository {
+	repo := &fakeAdminRepository{users: make(map[int64]*domain.UserInfo), balances: make(map[int64]domain.Money)}
+	for _, u := range users {
+		u := u
+		repo.users[u.ID] = &u
+	}
+	return repo
+}
+
+func (repo *fakeAdminRepository) SearchUsers(ctx context.Context, query string, limit int) ([]domain.UserInfo, error) {
+	repo.limit = limit
+	return nil, nil
+}
+
+func (repo *fakeAdminRepository) GetUser(ctx context.Context, uid int64) (*domain.UserInfo, error) {
+	u, ok := repo.users[uid]
+	if !ok {
+		return nil, ports.ErrUserNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (repo *fakeAdminRepository) SetRole(ctx context.Context, uid int64, role domain.Role, entry *domain.AuditEntry) error {
+	repo.users[uid].Role = role
+	repo.audit = append(repo.audit, *entry)
+	return nil
+}
+
+func (repo *fakeAdminRepository) AdjustBalance(ctx context.Context, uid int64, amount domain.Money, entry *domain.AuditEntry) (*domain.Balance, error) {
+	if repo.balances[uid]+amount < 0 {
+		return nil, ports.ErrNotEnoughMoney
+	}
+	repo.balances[uid] += amount
+	repo.audit = append(repo.audit, *entry)
+	return &domain.Balance{Current: repo.balances[uid]}, nil
+}
+
+func (repo *fakeAdminRepository) GetAuditLog(ctx context.Context, targetUID int64, limit int) ([]domain.AuditEntry, error) {
+	repo.limit = limit
+	return repo.audit, nil
+}
+
+type fakeOutboxRepository struct {
+	events []domain.OutboxEvent
+	limit  int
+}
+
+func (repo *fakeOutboxRepository) GetEvents(ctx context.Context, afterID int64, limit int) ([]domain.OutboxEvent, error) {
+	repo.limit = limit
+	events := make([]domain.OutboxEvent, 0)
+	for _, event := range repo.events {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+type fakeSessionRevoker struct {
+	revoked []int64
+}
+
+func (r *fakeSessionRevoker) RevokeUserSessionsByID(ctx context.Context, uid int64) error {
+	r.revoked = append(r.revoked, uid)
+	return nil
+}
+
+func TestAdminSetRole(t *testing.T) {
+	repo := newFakeAdminRepository(
+		domain.UserInfo{ID: 1, Login: "svirex", Role: domain.RoleUser},
+		domain.UserInfo{ID: 2, Role: domain.RoleUser, Deleted: true},
+	)
+	revoker := &fakeSessionRevoker{}
+	service := NewAdminService(repo, &fakeOutboxRepository{}, revoker)
+	actor := &domain.Principal{UID: 7, Role: domain.RoleAdmin}
+	ctx := context.Background()
+
+	err := service.SetRole(ctx, actor, 1, "root")
+	require.ErrorIs(t, err, ports.ErrInvalidRole)
+	err = service.SetRole(ctx, actor, 2, domain.RoleSupport)
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
+	err = service.SetRole(ctx, actor, 3, domain.RoleSupport)
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
+
+	require.NoError(t, service.SetRole(ctx, actor, 1, domain.RoleSupport))
+	require.Equal(t, []int64{1}, revoker.revoked)
+	require.Equal(t, []domain.AuditEntry{{
+		ActorUID:  7,
+		Action:    domain.AuditRoleChanged,
+		TargetUID: 1,
+		Details:   map[string]string{"from": "user", "to": "support"},
+	}}, repo.audit)
+
+	// та же роль ничего не меняет и не разлогинивает пользователя
+	require.NoError(t, service.SetRole(ctx, actor, 1, domain.RoleSupport))
+	require.Len(t, revoker.revoked, 1)
+	require.Len(t, repo.audit, 1)
+}
+
+func TestAdminSetRoleSelfDemotion(t *testing.T) {
+	repo := newFakeAdminRepository(domain.UserInfo{ID: 7, Login: "root", Role: domain.RoleAdmin})
+	revoker := &fakeSessionRevoker{}
+	service := NewAdminService(repo, &fakeOutboxRepository{}, revoker)
+	actor := &domain.Principal{UID: 7, Role: domain.RoleAdmin}
+	ctx := context.Background()
+
+	require.ErrorIs(t, service.SetRole(ctx, actor, 7, domain.RoleSupport), ports.ErrSelfDemotion)
+	require.ErrorIs(t, service.SetRole(ctx, actor, 7, domain.RoleUser), ports.ErrSelfDemotion)
+	require.Empty(t, revoker.revoked)
+	require.Empty(t, repo.audit)
+	require.NoError(t, service.SetRole(ctx, actor, 7, domain.RoleAdmin))
+
+	// по X-Admin-Token действует не пользователь, понизить можно любого
+	require.NoError(t, service.SetRole(ctx, &domain.Principal{Role: domain.RoleAdmin}, 7, domain.RoleSupport))
+	require.Equal(t, []int64{7}, revoker.revoked)
+}
+
+func TestAdminAdjustBalance(t *testing.T) {
+	repo := newFakeAdminRepository(domain.UserInfo{ID: 1, Login: "svirex", Role: domain.RoleUser})
+	service := NewAdminService(repo, &fakeOutboxRepository{}, &fakeSessionRevoker{})
+	actor := &domain.Principal{Role: domain.RoleAdmin}
+	ctx := context.Background()
+
+	_, err := service.AdjustBalance(ctx, actor, 1, domain.BalanceAdjustment{Amount: 0, Reason: "nothing"})
+	require.ErrorIs(t, err, ports.ErrInvalidAdjustment)
+	_, err = service.AdjustBalance(ctx, actor, 1, domain.BalanceAdjustment{Amount: 100, Reason: "  "})
+	require.ErrorIs(t, err, ports.ErrInvalidAdjustment)
+
+	balance, err := service.AdjustBalance(ctx, actor, 1, domain.BalanceAdjustment{Amount: 150_50, Reason: " lost order "})
+	require.NoError(t, err)
+	require.Equal(t, domain.Money(150_50), balance.Current)
+	_, err = service.AdjustBalance(ctx, actor, 1, domain.BalanceAdjustment{Amount: -200_00, Reason: "fraud"})
+	require.ErrorIs(t, err, ports.ErrNotEnoughMoney)
+
+	require.Len(t, repo.audit, 1)
+	require.Equal(t, "lost order", repo.audit[0].Reason)
+	require.Equal(t, "150.5", repo.audit[0].Details["amount"])
+	require.Equal(t, int64(0), repo.audit[0].ActorUID)
+}
+
+func TestAdminListLimit(t *testing.T) {
+	repo := newFakeAdminRepository()
+	service := NewAdminService(repo, &fakeOutboxRepository{}, &fakeSessionRevoker{})
+
+	_, err := service.SearchUsers(context.Background(), "", 0)
+	require.NoError(t, err)
+	require.Equal(t, defaultAdminListLimit, repo.limit)
+	_, err = service.GetAuditLog(context.Background(), 0, 10_000)
+	require.NoError(t, err)
+	require.Equal(t, maxAdminListLimit, repo.limit)
+}
+
+func TestAdminGetOutboxEvents(t *testing.T) {
+	outbox := &fakeOutboxRepository{events: []domain.OutboxEvent{
+		{ID: 1, Type: domain.AccrualCreditedEvent, AggregateID: "18"},
+		{ID: 2, Type: domain.AccrualCreditedEvent, AggregateID: "26"},
+		{ID: 3, Type: domain.AccrualCreditedEvent, AggregateID: "67"},
+	}}
+	service := NewAdminService(newFakeAdminRepository(), outbox, &fakeSessionRevoker{})
+
+	events, err := service.GetOutboxEvents(context.Background(), 1, 0)
+	require.NoError(t, err)
+	require.Equal(t, defaultAdminListLimit, outbox.limit)
+	require.Len(t, events, 2)
+	require.Equal(t, "26", events[0].AggregateID)
+
+	events, err = service.GetOutboxEvents(context.Background(), -5, 1)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, int64(1), events[0].ID)
+}
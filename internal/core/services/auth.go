@@ -4,113 +4,420 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/Svirex/gofermart-loyality/internal/common"
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
+type AuthConfig struct {
+	MinPasswordEntropyBits float64
+	MinPasswordLength      int
+	// PasswordBlocklist - утёкшие и популярные пароли, см. LoadPasswordBlocklist
+	PasswordBlocklist []string
+	// PasswordHasher хеширует новые пароли, по умолчанию bcrypt с BcryptCost.
+	// Хеши других алгоритмов и более слабые хеши заменяются при входе.
+	PasswordHasher PasswordHasher
+	BcryptCost     int
+	// JWTSecretKey - секрет HS256, используется если KeyRing не задан
+	JWTSecretKey    string
+	KeyRing         *KeyRing
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// RevocationCacheTTL - как долго экземпляр может не видеть отзыв токенов, сделанный другим экземпляром
+	RevocationCacheTTL time.Duration
+	LoginThrottle      LoginThrottleConfig
+	// TOTPIssuer - название сервиса в приложении-аутентификаторе
+	TOTPIssuer string
+	// Logger - для ошибок, которые не мешают ответу, по умолчанию ничего не пишется
+	Logger common.Logger
+}
+
 type AuthService struct {
-	repo                   ports.AuthRepository
-	minPasswordEntropyBits float64
-	minPasswordLength      int
-	bcryptCost             int
-	jwtSecretKey           string
+	repo            ports.AuthRepository
+	sessions        ports.SessionRepository
+	revocations     *revocationCache
+	throttle        *loginThrottle
+	secondFactor    ports.SecondFactorRepository
+	totpIssuer      string
+	passwords       *passwordPolicy
+	hashers         *passwordHashers
+	keys            *KeyRing
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	logger          common.Logger
+	now             func() time.Time
 }
 
 var _ ports.AuthService = (*AuthService)(nil)
 
-func NewAuthService(repo ports.AuthRepository, minPasswordEntropyBits float64, minPasswordLength int, bcryptCost int, jwtSecretKey string) (*AuthService, error) {
-	return &AuthService{
-		// TODO убрать параметры в Config
-		repo:                   repo,
-		minPasswordEntropyBits: minPasswordEntropyBits,
-		minPasswordLength:      minPasswordLength,
-		bcryptCost:             bcryptCost,
-		jwtSecretKey:           jwtSecretKey,
-	}, nil
+func NewAuthService(repo ports.AuthRepository, sessions ports.SessionRepository, revocations ports.RevocationRepository,
+	throttle ports.LoginThrottleRepository, secondFactor ports.SecondFactorRepository, cfg AuthConfig) (*AuthService, error) {
+	if cfg.AccessTokenTTL <= 0 || cfg.RefreshTokenTTL <= 0 {
+		return nil, fmt.Errorf("new auth service, token ttl must be positive: access %v, refresh %v", cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	}
+	lt := cfg.LoginThrottle
+	if (lt.LoginFreeAttempts > 0 || lt.IPFreeAttempts > 0) && (lt.BaseLockout <= 0 || lt.MaxLockout < lt.BaseLockout || lt.Window <= 0) {
+		return nil, fmt.Errorf("new auth service, invalid login throttle: base lockout %v, max lockout %v, window %v", lt.BaseLockout, lt.MaxLockout, lt.Window)
+	}
+	s := &AuthService{
+		repo:            repo,
+		sessions:        sessions,
+		throttle:        newLoginThrottle(throttle, cfg.LoginThrottle),
+		secondFactor:    secondFactor,
+		totpIssuer:      cfg.TOTPIssuer,
+		passwords:       newPasswordPolicy(cfg.MinPasswordLength, cfg.MinPasswordEntropyBits, cfg.PasswordBlocklist),
+		keys:            cfg.KeyRing,
+		accessTokenTTL:  cfg.AccessTokenTTL,
+		refreshTokenTTL: cfg.RefreshTokenTTL,
+		logger:          cfg.Logger,
+		now:             time.Now,
+	}
+	current := cfg.PasswordHasher
+	if current == nil {
+		current = BcryptHasher{Cost: cfg.BcryptCost}
+	}
+	// параметры проверки берутся из самого хеша, поэтому известные алгоритмы подходят с нулевыми настройками
+	s.hashers = newPasswordHashers(current, BcryptHasher{}, Argon2idHasher{})
+	if s.totpIssuer == "" {
+		s.totpIssuer = defaultTOTPIssuer
+	}
+	if s.logger == nil {
+		s.logger = zap.NewNop().Sugar()
+	}
+	if s.keys == nil {
+		s.keys = NewHMACKeyRing(cfg.JWTSecretKey)
+	}
+	// now берётся через s, чтобы тесты могли подменить время и для кеша
+	s.revocations = newRevocationCache(revocations, sessions, cfg.RevocationCacheTTL, func() time.Time { return s.now() })
+	return s, nil
 }
 
-func (s *AuthService) Register(ctx context.Context, login, password string) (string, error) {
+func (s *AuthService) Register(ctx context.Context, login, password string) (*domain.TokenPair, error) {
 	if login == "" {
-		return "", fmt.Errorf("auth service register, empty login: %w", ports.ErrEmptyLogin)
+		return nil, fmt.Errorf("auth service register, empty login: %w", ports.ErrEmptyLogin)
 	}
 	if password == "" {
-		return "", fmt.Errorf("auth service register, empty password: %w", ports.ErrEmptyPassword)
+		return nil, fmt.Errorf("auth service register, empty password: %w", ports.ErrEmptyPassword)
 	}
-	if len(password) < s.minPasswordLength {
-		return "", fmt.Errorf("auth service register, check min length: %w", ports.ErrPasswordTooShort)
+	if err := s.passwords.validate(login, password); err != nil {
+		return nil, fmt.Errorf("auth service register, password policy: %w", err)
 	}
 	hash, err := s.hashPassword(password)
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrPasswordTooLong) {
-			return "", fmt.Errorf("auth service register, password too long: %w", ports.ErrPasswordTooLong)
+			return nil, fmt.Errorf("auth service register, password too long: %w", ports.ErrPasswordTooLong)
 		}
-		return "", fmt.Errorf("auth service register, hash password error: %w", err)
+		return nil, fmt.Errorf("auth service register, hash password error: %w", err)
 	}
 	user := &domain.User{
 		Login: login,
 		Hash:  hash,
+		Role:  domain.RoleUser,
 	}
 	user, err = s.repo.CreateUser(ctx, user)
 	if err != nil {
 		if errors.Is(err, ports.ErrUserAlreadyExists) {
-			return "", fmt.Errorf("auth service register, user already exists: %w", err)
+			return nil, fmt.Errorf("auth service register, user already exists: %w", err)
 		}
-		return "", fmt.Errorf("auth service register, create user: %w", err)
+		return nil, fmt.Errorf("auth service register, create user: %w", err)
 	}
-	token, err := buildJWTString(s.jwtSecretKey, user.ID)
+	tokens, err := s.startSession(ctx, user)
 	if err != nil {
-		return "", fmt.Errorf("auth service register, build jwt token: %w", err)
+		return nil, fmt.Errorf("auth service register: %w", err)
 	}
-
-	return token, nil
+	return tokens, nil
 }
 
-func (s *AuthService) Login(ctx context.Context, login, password string) (string, error) {
+func (s *AuthService) Login(ctx context.Context, login, password, clientIP string) (*domain.TokenPair, error) {
 	if login == "" {
-		return "", fmt.Errorf("auth service login, empty login: %w", ports.ErrEmptyLogin)
+		return nil, fmt.Errorf("auth service login, empty login: %w", ports.ErrEmptyLogin)
 	}
 	if password == "" {
-		return "", fmt.Errorf("auth service login, empty password: %w", ports.ErrEmptyPassword)
+		return nil, fmt.Errorf("auth service login, empty password: %w", ports.ErrEmptyPassword)
+	}
+	// при блокировке пароль не проверяется, иначе перебор продолжался бы по ответам.
+	// Попытка засчитывается до проверки и прощается, если пароль верный.
+	attempt, err := s.throttle.begin(ctx, login, clientIP)
+	if err != nil {
+		return nil, fmt.Errorf("auth service login: %w", err)
 	}
 	user, err := s.repo.GetUserByLogin(ctx, login)
 	if err != nil {
 		if errors.Is(err, ports.ErrUserNotFound) {
-			return "", fmt.Errorf("auth service login, user not found: %w", err)
+			return nil, fmt.Errorf("auth service login, user not found: %w", err)
 		}
-		return "", fmt.Errorf("auth service login, get user by login: %w", err)
+		return nil, fmt.Errorf("auth service login, get user by login: %w", err)
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password))
+	ok, err := s.hashers.verify(user.Hash, password)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return "", fmt.Errorf("%w: auth service login, invalid password: %v", ports.ErrInvalidPassword, err)
+		return nil, fmt.Errorf("auth service login, verify password: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: auth service login, invalid password", ports.ErrInvalidPassword)
+	}
+	if err = s.throttle.forgive(ctx, attempt); err != nil {
+		return nil, fmt.Errorf("auth service login: %w", err)
+	}
+	// пароль верный, старый хеш остаётся рабочим и заменится при следующем входе
+	if err = s.rehashPassword(ctx, user, password); err != nil {
+		s.logger.Errorf("auth service login, uid %d: %v", user.ID, err)
+	}
+	totp, err := s.enabledTOTP(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("auth service login: %w", err)
+	}
+	// счётчик не сбрасывается до проверки кода, иначе верный пароль снимал бы ограничение на перебор кодов
+	if totp != nil {
+		now := s.now()
+		mfaToken, err := buildMFAToken(s.keys, user.ID, now, mfaTokenTTL)
+		if err != nil {
+			return nil, fmt.Errorf("auth service login: %w", err)
 		}
-		return "", fmt.Errorf("auth service login, compare hash and password: %w", err)
+		return nil, &ports.MFARequiredError{Token: mfaToken, ExpiresAt: now.Add(mfaTokenTTL)}
+	}
+	if err = s.throttle.reset(ctx, login); err != nil {
+		return nil, fmt.Errorf("auth service login: %w", err)
+	}
+	tokens, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("auth service login: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *AuthService) ChangePassword(ctx context.Context, principal *domain.Principal, oldPassword, newPassword string) error {
+	user, err := s.repo.GetUserByID(ctx, principal.UID)
+	if err != nil {
+		return fmt.Errorf("auth service change password: %w", err)
+	}
+	if err = s.verifyPassword(ctx, user, oldPassword); err != nil {
+		return fmt.Errorf("auth service change password: %w", err)
+	}
+	if err = s.passwords.validate(user.Login, newPassword); err != nil {
+		return fmt.Errorf("auth service change password, password policy: %w", err)
+	}
+	hash, err := s.hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("auth service change password, hash password: %w", err)
 	}
-	token, err := buildJWTString(s.jwtSecretKey, user.ID)
+	err = s.repo.ChangePassword(ctx, user.ID, hash, principal.SessionID)
 	if err != nil {
-		return "", fmt.Errorf("auth service login, build jwt token: %w", err)
+		return fmt.Errorf("auth service change password: %w", err)
 	}
-	return token, nil
+	return nil
 }
 
-func (s *AuthService) GetUserGromJWT(ctx context.Context, jwt string) (int64, error) {
-	return getUserIDFromJWT(s.jwtSecretKey, jwt)
+func (s *AuthService) DeleteUser(ctx context.Context, principal *domain.Principal, password string) error {
+	user, err := s.repo.GetUserByID(ctx, principal.UID)
+	if err != nil {
+		return fmt.Errorf("auth service delete user: %w", err)
+	}
+	if err = s.verifyPassword(ctx, user, password); err != nil {
+		return fmt.Errorf("auth service delete user: %w", err)
+	}
+	err = s.repo.DeleteUser(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("auth service delete user: %w", err)
+	}
+	// сессии уже отозваны, здесь отсекаются access токены и обновляется кеш этого экземпляра.
+	// Новых токенов у удалённого пользователя не будет, поэтому отсекается и текущая секунда.
+	err = s.revocations.revokeUserTokens(ctx, user.ID, s.now().Truncate(time.Second).Add(time.Second))
+	if err != nil {
+		return fmt.Errorf("auth service delete user: %w", err)
+	}
+	if err = s.throttle.unlock(ctx, user.Login); err != nil {
+		return fmt.Errorf("auth service delete user: %w", err)
+	}
+	return nil
 }
 
-// func (s *AuthService) validatePasswordSthregth(password string) error {
-// 	if len(password) < s.minPasswordLength {
-// 		return fmt.Errorf("validatePasswordSthregth, check length: %w", ports.ErrPasswordTooShort)
-// 	}
-// 	err := passwordvalidator.Validate(password, s.minPasswordEntropyBits)
-// 	if err != nil {
-// 		return fmt.Errorf("%w, validatePasswordSthregth, check password strength: %v", ports.ErrLowPasswordStrength, err)
-// 	}
-// 	return nil
-// }
+// verifyPassword подтверждает действие паролем, ошибки учитываются как неудачные попытки входа
+func (s *AuthService) verifyPassword(ctx context.Context, user *domain.User, password string) error {
+	if password == "" {
+		return fmt.Errorf("verify password: %w", ports.ErrEmptyPassword)
+	}
+	attempt, err := s.throttle.begin(ctx, user.Login, "")
+	if err != nil {
+		return fmt.Errorf("verify password: %w", err)
+	}
+	ok, err := s.hashers.verify(user.Hash, password)
+	if err != nil {
+		return fmt.Errorf("verify password: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: verify password", ports.ErrInvalidPassword)
+	}
+	if err = s.throttle.forgive(ctx, attempt); err != nil {
+		return fmt.Errorf("verify password: %w", err)
+	}
+	return nil
+}
+
+// rehashPassword заменяет хеш, созданный другим алгоритмом или с параметрами слабее текущих.
+// Пароль известен только при входе, поэтому обновление происходит здесь.
+func (s *AuthService) rehashPassword(ctx context.Context, user *domain.User, password string) error {
+	if !s.hashers.needsRehash(user.Hash) {
+		return nil
+	}
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("rehash password: %w", err)
+	}
+	if err = s.repo.UpdatePasswordHash(ctx, user.ID, user.Hash, hash); err != nil {
+		return fmt.Errorf("rehash password: %w", err)
+	}
+	return nil
+}
+
+func (s *AuthService) UnlockLogin(ctx context.Context, uid int64) error {
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("auth service unlock login: %w", err)
+	}
+	err = s.throttle.unlock(ctx, user.Login)
+	if err != nil {
+		return fmt.Errorf("auth service unlock login: %w", err)
+	}
+	return nil
+}
+
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*domain.TokenPair, error) {
+	if refreshToken == "" {
+		return nil, fmt.Errorf("%w: auth service refresh, empty refresh token", ports.ErrInvalidToken)
+	}
+	newRefreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("auth service refresh: %w", err)
+	}
+	now := s.now()
+	session, err := s.sessions.RotateSession(ctx, hashRefreshToken(refreshToken), hashRefreshToken(newRefreshToken), now.Add(s.refreshTokenTTL))
+	if err != nil {
+		return nil, fmt.Errorf("auth service refresh, rotate session: %w", err)
+	}
+	// роль берётся из базы, чтобы изменение роли доходило до токенов не позже следующего обновления
+	user, err := s.repo.GetUserByID(ctx, session.UID)
+	if err != nil {
+		return nil, fmt.Errorf("auth service refresh: %w", err)
+	}
+	return s.issueTokens(session, user.Role, newRefreshToken, now)
+}
+
+func (s *AuthService) Logout(ctx context.Context, principal *domain.Principal) error {
+	err := s.sessions.RevokeSession(ctx, principal.SessionID)
+	if err != nil {
+		return fmt.Errorf("auth service logout: %w", err)
+	}
+	err = s.revocations.revokeToken(ctx, principal.TokenID, principal.SessionID, principal.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("auth service logout: %w", err)
+	}
+	return nil
+}
+
+func (s *AuthService) RevokeUserSessions(ctx context.Context, uid int64) error {
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("auth service revoke user sessions: %w", err)
+	}
+	return s.RevokeUserSessionsByID(ctx, user.ID)
+}
+
+func (s *AuthService) RevokeUserSessionsByID(ctx context.Context, uid int64) error {
+	// iat в токене с точностью до секунды, токены выпущенные в ту же секунду отсекаются отзывом их сессий
+	err := s.revocations.revokeUserTokens(ctx, uid, s.now().Truncate(time.Second))
+	if err != nil {
+		return fmt.Errorf("auth service revoke user sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *AuthService) Authenticate(ctx context.Context, accessToken string) (*domain.Principal, error) {
+	claims, err := parseJWT(s.keys, accessToken, s.now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: auth service authenticate: %v", ports.ErrInvalidToken, err)
+	}
+	expiresAt := claims.ExpiresAt.Time
+	revoked, err := s.revocations.isTokenRevoked(ctx, claims.ID, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("auth service authenticate: %w", err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: auth service authenticate, token %s is revoked", ports.ErrInvalidToken, claims.ID)
+	}
+	validAfter, err := s.revocations.getTokensValidAfter(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, ports.ErrUserNotFound) {
+			return nil, fmt.Errorf("%w: auth service authenticate: %v", ports.ErrInvalidToken, err)
+		}
+		return nil, fmt.Errorf("auth service authenticate: %w", err)
+	}
+	if claims.IssuedAt.Time.Before(validAfter) {
+		return nil, fmt.Errorf("%w: auth service authenticate, tokens of user %d are revoked", ports.ErrInvalidToken, claims.UserID)
+	}
+	active, err := s.revocations.isSessionActive(ctx, claims.SessionID, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("auth service authenticate: %w", err)
+	}
+	if !active {
+		return nil, fmt.Errorf("%w: auth service authenticate, session %s is revoked", ports.ErrInvalidToken, claims.SessionID)
+	}
+	role := claims.Role
+	if role == "" {
+		role = domain.RoleUser
+	}
+	return &domain.Principal{
+		UID:       claims.UserID,
+		SessionID: claims.SessionID,
+		TokenID:   claims.ID,
+		Role:      role,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (s *AuthService) JWKS() *domain.JWKSet {
+	return s.keys.JWKS()
+}
+
+func (s *AuthService) startSession(ctx context.Context, user *domain.User) (*domain.TokenPair, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("start session, id: %w", err)
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("start session, refresh token: %w", err)
+	}
+	now := s.now()
+	session := &domain.Session{
+		ID:          sessionID,
+		UID:         user.ID,
+		RefreshHash: hashRefreshToken(refreshToken),
+		ExpiresAt:   now.Add(s.refreshTokenTTL),
+	}
+	if err = s.sessions.CreateSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("start session: %w", err)
+	}
+	return s.issueTokens(session, user.Role, refreshToken, now)
+}
+
+func (s *AuthService) issueTokens(session *domain.Session, role domain.Role, refreshToken string, now time.Time) (*domain.TokenPair, error) {
+	accessToken, err := buildJWTString(s.keys, session.UID, session.ID, role, now, s.accessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("issue tokens: %w", err)
+	}
+	return &domain.TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  now.Add(s.accessTokenTTL),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
 
 func (s *AuthService) hashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.bcryptCost)
-	return string(hash), err
+	return s.hashers.hash(password)
 }
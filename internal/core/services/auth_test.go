@@ -2,14 +2,241 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 	"github.com/stretchr/testify/require"
 )
 
+type fakeSession struct {
+	session      domain.Session
+	previousHash string
+	revoked      bool
+}
+
+// fakeSessionRepository - сессии в памяти, повторяет поведение postgres.SessionRepository
+type fakeSessionRepository struct {
+	mu       sync.Mutex
+	sessions map[string]*fakeSession
+}
+
+var _ ports.SessionRepository = (*fakeSessionRepository)(nil)
+
+func newFakeSessionRepository() *fakeSessionRepository {
+	return &fakeSessionRepository{sessions: make(map[string]*fakeSession)}
+}
+
+func (repo *fakeSessionRepository) CreateSession(ctx context.Context, session *domain.Session) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.sessions[session.ID] = &fakeSession{session: *session}
+	return nil
+}
+
+func (repo *fakeSessionRepository) RotateSession(ctx context.Context, refreshHash string, newRefreshHash string, expiresAt time.Time) (*domain.Session, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, s := range repo.sessions {
+		if s.session.RefreshHash == refreshHash && !s.revoked {
+			s.previousHash = s.session.RefreshHash
+			s.session.RefreshHash = newRefreshHash
+			s.session.ExpiresAt = expiresAt
+			session := s.session
+			return &session, nil
+		}
+		if s.previousHash == refreshHash {
+			s.revoked = true
+			return nil, fmt.Errorf("%w: refresh token reused", ports.ErrInvalidToken)
+		}
+	}
+	return nil, ports.ErrInvalidToken
+}
+
+func (repo *fakeSessionRepository) RevokeSession(ctx context.Context, sessionID string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if s, ok := repo.sessions[sessionID]; ok {
+		s.revoked = true
+	}
+	return nil
+}
+
+func (repo *fakeSessionRepository) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	s, ok := repo.sessions[sessionID]
+	return ok && !s.revoked, nil
+}
+
+// fakeRevocationRepository - отозванные токены в памяти, отзыв всех токенов пользователя отзывает и его сессии
+type fakeRevocationRepository struct {
+	mu          sync.Mutex
+	sessions    *fakeSessionRepository
+	tokens      map[string]bool
+	validAfter  map[int64]time.Time
+	tokenChecks int
+}
+
+var _ ports.RevocationRepository = (*fakeRevocationRepository)(nil)
+
+func newFakeRevocationRepository(sessions *fakeSessionRepository) *fakeRevocationRepository {
+	return &fakeRevocationRepository{
+		sessions:   sessions,
+		tokens:     make(map[string]bool),
+		validAfter: make(map[int64]time.Time),
+	}
+}
+
+func (repo *fakeRevocationRepository) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.tokens[tokenID] = true
+	return nil
+}
+
+func (repo *fakeRevocationRepository) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.tokenChecks++
+	return repo.tokens[tokenID], nil
+}
+
+func (repo *fakeRevocationRepository) RevokeUserTokens(ctx context.Context, uid int64, validAfter time.Time) error {
+	repo.mu.Lock()
+	repo.validAfter[uid] = validAfter
+	repo.mu.Unlock()
+	repo.sessions.mu.Lock()
+	defer repo.sessions.mu.Unlock()
+	for _, s := range repo.sessions.sessions {
+		if s.session.UID == uid {
+			s.revoked = true
+		}
+	}
+	return nil
+}
+
+func (repo *fakeRevocationRepository) TokensValidAfter(ctx context.Context, uid int64) (time.Time, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.validAfter[uid], nil
+}
+
+// fakeAuthRepository - пользователи в памяти, удаление и смена пароля отзывают сессии как postgres.AuthRepository
+type fakeAuthRepository struct {
+	mu       sync.Mutex
+	sessions *fakeSessionRepository
+	users    map[int64]*domain.User
+}
+
+var _ ports.AuthRepository = (*fakeAuthRepository)(nil)
+
+func newFakeAuthRepository(sessions *fakeSessionRepository) *fakeAuthRepository {
+	return &fakeAuthRepository{sessions: sessions, users: make(map[int64]*domain.User)}
+}
+
+func (repo *fakeAuthRepository) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, u := range repo.users {
+		if u.Login == user.Login {
+			return nil, ports.ErrUserAlreadyExists
+		}
+	}
+	created := *user
+	created.ID = int64(len(repo.users) + 1)
+	repo.users[created.ID] = &created
+	return &created, nil
+}
+
+func (repo *fakeAuthRepository) GetUserByLogin(ctx context.Context, login string) (*domain.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, u := range repo.users {
+		if u.Login == login && login != "" {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, ports.ErrUserNotFound
+}
+
+func (repo *fakeAuthRepository) GetUserByID(ctx context.Context, uid int64) (*domain.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	u, ok := repo.users[uid]
+	if !ok || u.Login == "" {
+		return nil, ports.ErrUserNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (repo *fakeAuthRepository) ChangePassword(ctx context.Context, uid int64, hash string, keepSessionID string) error {
+	repo.mu.Lock()
+	u, ok := repo.users[uid]
+	if ok {
+		u.Hash = hash
+	}
+	repo.mu.Unlock()
+	if !ok {
+		return ports.ErrUserNotFound
+	}
+	repo.revokeSessions(uid, keepSessionID)
+	return nil
+}
+
+func (repo *fakeAuthRepository) UpdatePasswordHash(ctx context.Context, uid int64, oldHash, newHash string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if u, ok := repo.users[uid]; ok && u.Hash == oldHash && u.Login != "" {
+		u.Hash = newHash
+	}
+	return nil
+}
+
+func (repo *fakeAuthRepository) DeleteUser(ctx context.Context, uid int64) error {
+	repo.mu.Lock()
+	u, ok := repo.users[uid]
+	if ok {
+		u.Login, u.Hash = "", ""
+	}
+	repo.mu.Unlock()
+	if !ok {
+		return ports.ErrUserNotFound
+	}
+	repo.revokeSessions(uid, "")
+	return nil
+}
+
+func (repo *fakeAuthRepository) revokeSessions(uid int64, keepSessionID string) {
+	repo.sessions.mu.Lock()
+	defer repo.sessions.mu.Unlock()
+	for id, s := range repo.sessions.sessions {
+		if s.session.UID == uid && id != keepSessionID {
+			s.revoked = true
+		}
+	}
+}
+
 func NewAuthTestService(t *testing.T) *AuthService {
-	service, err := NewAuthService(nil, 80, 8, 10, "fake_secret")
+	sessions := newFakeSessionRepository()
+	return newAuthTestServiceWithRepos(t, sessions, newFakeRevocationRepository(sessions))
+}
+
+func newAuthTestServiceWithRepos(t *testing.T, sessions *fakeSessionRepository, revocations *fakeRevocationRepository) *AuthService {
+	service, err := NewAuthService(newFakeAuthRepository(sessions), sessions, revocations, newFakeLoginThrottleRepository(time.Now), newFakeSecondFactorRepository(), AuthConfig{
+		MinPasswordEntropyBits: 80,
+		MinPasswordLength:      8,
+		BcryptCost:             10,
+		JWTSecretKey:           "fake_secret",
+		AccessTokenTTL:         15 * time.Minute,
+		RefreshTokenTTL:        time.Hour,
+		RevocationCacheTTL:     10 * time.Second,
+	})
 	require.NoError(t, err)
 	return service
 }
@@ -41,14 +268,14 @@ func TestEmptyLoginLogin(t *testing.T) {
 
 	service := NewAuthTestService(t)
 
-	_, err := service.Login(context.Background(), "", "password")
+	_, err := service.Login(context.Background(), "", "password", "127.0.0.1")
 	require.ErrorIs(t, err, ports.ErrEmptyLogin)
 }
 
 func TestEmptyPasswordLogin(t *testing.T) {
 	service := NewAuthTestService(t)
 
-	_, err := service.Login(context.Background(), "login", "")
+	_, err := service.Login(context.Background(), "login", "", "127.0.0.1")
 	require.ErrorIs(t, err, ports.ErrEmptyPassword)
 }
 
@@ -58,3 +285,210 @@ func TestPasswordTooLong(t *testing.T) {
 	_, err := service.Register(context.Background(), "svirex", "blablablablablablablablablablablablablablablablablablablablablablablablabla")
 	require.ErrorIs(t, err, ports.ErrPasswordTooLong)
 }
+
+func TestAccessTokenExpires(t *testing.T) {
+	service := NewAuthTestService(t)
+
+	tokens, err := service.startSession(context.Background(), &domain.User{ID: 42})
+	require.NoError(t, err)
+
+	principal, err := service.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), principal.UID)
+	require.NotEmpty(t, principal.TokenID)
+
+	service.now = func() time.Time { return time.Now().Add(16 * time.Minute) }
+	_, err = service.Authenticate(context.Background(), tokens.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+}
+
+func TestInvalidAccessTokenRejected(t *testing.T) {
+	service := NewAuthTestService(t)
+
+	token, err := buildJWTString(NewHMACKeyRing("fake_secret"), 42, "sid", domain.RoleUser, time.Now(), 0)
+	require.NoError(t, err)
+	_, err = service.Authenticate(context.Background(), token)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+
+	_, err = service.Authenticate(context.Background(), "garbage")
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+}
+
+func TestLogoutRevokesAccessToken(t *testing.T) {
+	service := NewAuthTestService(t)
+
+	tokens, err := service.startSession(context.Background(), &domain.User{ID: 42})
+	require.NoError(t, err)
+	principal, err := service.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+
+	require.NoError(t, service.Logout(context.Background(), principal))
+
+	_, err = service.Authenticate(context.Background(), tokens.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	_, err = service.Refresh(context.Background(), tokens.RefreshToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	service := NewAuthTestService(t)
+	service.repo.(*fakeAuthRepository).users[42] = &domain.User{ID: 42, Login: "svirex", Role: domain.RoleUser}
+
+	tokens, err := service.startSession(context.Background(), &domain.User{ID: 42})
+	require.NoError(t, err)
+
+	refreshed, err := service.Refresh(context.Background(), tokens.RefreshToken)
+	require.NoError(t, err)
+	require.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+
+	principal, err := service.Authenticate(context.Background(), refreshed.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), principal.UID)
+
+	// повторное использование старого refresh токена отзывает всю сессию
+	_, err = service.Refresh(context.Background(), tokens.RefreshToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	_, err = service.Refresh(context.Background(), refreshed.RefreshToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	// активность сессии закеширована, отзыв виден после истечения кеша
+	service.now = func() time.Time { return time.Now().Add(11 * time.Second) }
+	_, err = service.Authenticate(context.Background(), refreshed.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+}
+
+func TestRoleInAccessToken(t *testing.T) {
+	service := NewAuthTestService(t)
+	users := service.repo.(*fakeAuthRepository).users
+	users[42] = &domain.User{ID: 42, Login: "svirex", Role: domain.RoleUser}
+
+	// токены, выпущенные до появления ролей, считаются токенами пользователя
+	tokens, err := service.startSession(context.Background(), &domain.User{ID: 42})
+	require.NoError(t, err)
+	principal, err := service.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, domain.RoleUser, principal.Role)
+
+	// новая роль попадает в токен при обновлении
+	users[42].Role = domain.RoleSupport
+	refreshed, err := service.Refresh(context.Background(), tokens.RefreshToken)
+	require.NoError(t, err)
+	principal, err = service.Authenticate(context.Background(), refreshed.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, domain.RoleSupport, principal.Role)
+}
+
+func TestRevocationCachedUntilTTL(t *testing.T) {
+	sessions := newFakeSessionRepository()
+	revocations := newFakeRevocationRepository(sessions)
+	first := newAuthTestServiceWithRepos(t, sessions, revocations)
+	second := newAuthTestServiceWithRepos(t, sessions, revocations)
+
+	tokens, err := first.startSession(context.Background(), &domain.User{ID: 42})
+	require.NoError(t, err)
+
+	principal, err := first.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+	_, err = first.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, 1, revocations.tokenChecks)
+
+	// отзыв через другой экземпляр виден ему сразу
+	require.NoError(t, second.Logout(context.Background(), principal))
+	_, err = second.Authenticate(context.Background(), tokens.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+
+	// а первому - после истечения кеша
+	_, err = first.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+	first.now = func() time.Time { return time.Now().Add(11 * time.Second) }
+	_, err = first.Authenticate(context.Background(), tokens.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	service := NewAuthTestService(t)
+
+	tokens, err := service.startSession(context.Background(), &domain.User{ID: 42})
+	require.NoError(t, err)
+	other, err := service.startSession(context.Background(), &domain.User{ID: 43})
+	require.NoError(t, err)
+
+	_, err = service.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+
+	now := time.Now().Add(2 * time.Second)
+	service.now = func() time.Time { return now }
+	require.NoError(t, service.revocations.revokeUserTokens(context.Background(), 42, now.Truncate(time.Second)))
+
+	_, err = service.Authenticate(context.Background(), tokens.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	_, err = service.Refresh(context.Background(), tokens.RefreshToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+
+	_, err = service.Authenticate(context.Background(), other.AccessToken)
+	require.NoError(t, err)
+
+	// новый вход после отзыва работает
+	fresh, err := service.startSession(context.Background(), &domain.User{ID: 42})
+	require.NoError(t, err)
+	_, err = service.Authenticate(context.Background(), fresh.AccessToken)
+	require.NoError(t, err)
+}
+
+func TestChangePassword(t *testing.T) {
+	service := newLoginThrottleTestService(t, &fakeClock{now: time.Now()})
+	ctx := context.Background()
+
+	tokens, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	other, err := service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.NoError(t, err)
+	principal, err := service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+
+	err = service.ChangePassword(ctx, principal, "Gopher-Mart-Loyal-43", "Another-Strong-Pass-7")
+	require.ErrorIs(t, err, ports.ErrInvalidPassword)
+	err = service.ChangePassword(ctx, principal, "Gopher-Mart-Loyal-42", "short")
+	require.ErrorIs(t, err, ports.ErrPasswordTooShort)
+
+	err = service.ChangePassword(ctx, principal, "Gopher-Mart-Loyal-42", "Another-Strong-Pass-7")
+	require.NoError(t, err)
+
+	// текущая сессия остаётся, остальные отозваны
+	_, err = service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+	_, err = service.Refresh(ctx, other.RefreshToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrInvalidPassword)
+	_, err = service.Login(ctx, "svirex", "Another-Strong-Pass-7", "10.0.0.1")
+	require.NoError(t, err)
+}
+
+func TestDeleteUser(t *testing.T) {
+	service := newLoginThrottleTestService(t, &fakeClock{now: time.Now()})
+	ctx := context.Background()
+
+	tokens, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	principal, err := service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+
+	err = service.DeleteUser(ctx, principal, "Gopher-Mart-Loyal-43")
+	require.ErrorIs(t, err, ports.ErrInvalidPassword)
+
+	err = service.DeleteUser(ctx, principal, "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+
+	_, err = service.Authenticate(ctx, tokens.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	_, err = service.Refresh(ctx, tokens.RefreshToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
+
+	// логин освобождается для новой регистрации
+	_, err = service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+}
@@ -22,3 +22,7 @@ var _ ports.BalanceService = (*BalanceService)(nil)
 func (service *BalanceService) GetBalance(ctx context.Context, uid int64) (*domain.Balance, error) {
 	return service.repository.GetBalance(ctx, uid)
 }
+
+func (service *BalanceService) GetHistory(ctx context.Context, uid int64) ([]domain.Posting, error) {
+	return service.repository.GetHistory(ctx, uid)
+}
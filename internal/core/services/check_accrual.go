@@ -5,447 +5,317 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"regexp"
-	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/common"
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/shopspring/decimal"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 )
 
+type CheckAccrualConfig struct {
+	// InstanceID - владелец аренды задач в accrual_jobs, должен быть уникален для каждого экземпляра сервиса
+	InstanceID    string
+	Workers       int
+	PollInterval  time.Duration
+	BatchSize     int
+	LeaseDuration time.Duration
+	Retry         RetryPolicy
+	// CallbackWindow - сколько ждать callback от системы расчёта, прежде чем опрашивать её самим,
+	// 0 - опрашивать сразу
+	CallbackWindow time.Duration
+}
+
 type CheckAccrualService struct {
-	dbpool               *pgxpool.Pool
-	stopCh               chan struct{}
-	orderNumsCh          chan string
-	queueSize            int
-	accrualAddr          string
-	errorCh              chan error
-	logger               common.Logger
-	accrualResponseCh    chan *AccrualResponse
-	pauseBetweenRequests time.Duration
-	generatorsWG         sync.WaitGroup
-	checkerEndCh         chan struct{}
-	// dbWriterEndCh        chan struct{}
-	errorLogEndCh        chan struct{}
-	dbLoaderEndCh        chan struct{}
-	currentGeneratorsRun atomic.Int32
-	maxRunnedGenerators  int32
-	dbLoaderPause        time.Duration
+	repo           ports.AccrualRepository
+	client         ports.AccrualClient
+	ctx            context.Context
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+	jobsCh         chan domain.AccrualJob
+	wakeCh         chan struct{}
+	instanceID     string
+	errorCh        chan error
+	logger         common.Logger
+	workers        int
+	pollInterval   time.Duration
+	batchSize      int
+	leaseDuration  time.Duration
+	retryPolicy    RetryPolicy
+	callbackWindow time.Duration
+	errorLogEndCh  chan struct{}
 }
 
-func NewCheckAccrualService(dbpool *pgxpool.Pool,
-	logger common.Logger,
-	queueSize int,
-	accrualAddr string,
-	pauseBetweenRequests time.Duration,
-	maxRunnedGenerators int32,
-	dbLoaderPause time.Duration,
-) (*CheckAccrualService, error) {
+func NewCheckAccrualService(repo ports.AccrualRepository, client ports.AccrualClient, logger common.Logger, cfg CheckAccrualConfig) (*CheckAccrualService, error) {
+	if cfg.InstanceID == "" {
+		return nil, fmt.Errorf("new check accrual service, empty instance id")
+	}
+	if cfg.Workers < 1 {
+		return nil, fmt.Errorf("new check accrual service, workers must be positive: %d", cfg.Workers)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &CheckAccrualService{
-		dbpool:               dbpool,
-		stopCh:               make(chan struct{}),
-		orderNumsCh:          make(chan string, queueSize),
-		queueSize:            queueSize,
-		accrualAddr:          accrualAddr,
-		errorCh:              make(chan error, queueSize),
-		logger:               logger,
-		accrualResponseCh:    make(chan *AccrualResponse, queueSize),
-		pauseBetweenRequests: pauseBetweenRequests,
-		checkerEndCh:         make(chan struct{}),
-		// dbWriterEndCh:        make(chan struct{}),
-		errorLogEndCh:       make(chan struct{}),
-		dbLoaderEndCh:       make(chan struct{}),
-		maxRunnedGenerators: maxRunnedGenerators,
-		dbLoaderPause:       dbLoaderPause,
+		repo:           repo,
+		client:         client,
+		ctx:            ctx,
+		cancel:         cancel,
+		jobsCh:         make(chan domain.AccrualJob),
+		wakeCh:         make(chan struct{}, 1),
+		instanceID:     cfg.InstanceID,
+		errorCh:        make(chan error, cfg.BatchSize),
+		logger:         logger,
+		workers:        cfg.Workers,
+		pollInterval:   cfg.PollInterval,
+		batchSize:      cfg.BatchSize,
+		leaseDuration:  cfg.LeaseDuration,
+		retryPolicy:    cfg.Retry,
+		callbackWindow: cfg.CallbackWindow,
+		errorLogEndCh:  make(chan struct{}),
 	}, nil
 }
 
-// сервис старутет, мы должны из базы вычитать N номер заказов в неконечном статусе и положить их в очередь
-//
-
 func (service *CheckAccrualService) Start() {
 	service.logger.Debug("START CHECK ACCRUAL SERVICE")
-	go service.dbLoader()
-	go service.checker()
-	// go service.dbWriter()
 	go service.errorLog()
+	service.wg.Add(1 + service.workers)
+	go service.poller()
+	for i := 0; i < service.workers; i++ {
+		go service.worker()
+	}
 }
 
-func (service *CheckAccrualService) dbLoader() {
-	service.logger.Debug("START DB LOADER")
-	for {
-		select {
-		case <-service.stopCh:
-			close(service.dbLoaderEndCh)
-			service.logger.Debugln("CLOSE CHANNEL dbLoaderEndCh")
-			return
-		default:
-			service.logger.Debug("DB LOADER currentGeneratorsRun: ", service.currentGeneratorsRun.Load(), ", maxRunnedGenerators: ", service.maxRunnedGenerators)
-			if service.currentGeneratorsRun.Load() < service.maxRunnedGenerators {
-				row, _ := service.dbpool.Query(context.Background(), "SELECT order_num FROM orders WHERE status='NEW' OR status='PROCESSING';")
-				if err := row.Err(); err != nil {
-					service.errorCh <- fmt.Errorf("check accrual service, db loader, select orders num: %w", err)
-					break
-				}
-				orderNums := make([]string, 0)
-				for row.Next() {
-					if err := row.Err(); err != nil {
-						service.errorCh <- fmt.Errorf("check accrual service, db loader, row.Next: %w", err)
-						break
-					}
-					var orderNum string
-					if err := row.Scan(&orderNum); err != nil {
-						service.errorCh <- fmt.Errorf("check accrual service, db loader, scan order num: %w", err)
-						break
-					}
-					orderNums = append(orderNums, orderNum)
-				}
-				service.logger.Debug("DB LOADER orderNums: ", orderNums)
-				for i := range orderNums {
-					service.Process(orderNums[i])
-				}
-			}
-			time.Sleep(service.dbLoaderPause)
-		}
+// Process будит poller, задача на проверку заказа уже лежит в accrual_jobs
+func (service *CheckAccrualService) Process(orderNum string) {
+	service.logger.Debugln("WAKE POLLER FOR ORDER_NUM", orderNum)
+	select {
+	case service.wakeCh <- struct{}{}:
+	default:
 	}
-
 }
 
-func (service *CheckAccrualService) startGenerator() {
-	service.generatorsWG.Add(1)
-	service.currentGeneratorsRun.Add(1)
+// FirstCheckDelay - на сколько откладывать первый опрос нового заказа, давая системе расчёта прислать callback.
+// Задача заводится сразу отложенной, иначе poller мог бы успеть взять её в аренду до откладывания.
+func (service *CheckAccrualService) FirstCheckDelay() time.Duration {
+	return service.callbackWindow
 }
 
-func (service *CheckAccrualService) endGenerator() {
-	service.generatorsWG.Done()
-	service.currentGeneratorsRun.Add(-1)
+// Schedule вызывается для новых заказов, их задачи уже лежат в accrual_jobs с FirstCheckDelay.
+// Без callbackWindow будит poller, иначе первый опрос наступит сам.
+func (service *CheckAccrualService) Schedule(orderNums ...string) {
+	if len(orderNums) == 0 || service.callbackWindow > 0 {
+		return
+	}
+	// проснувшийся poller заберёт задачи всех заказов пачкой
+	service.Process(orderNums[0])
 }
 
-func (service *CheckAccrualService) Process(orderNum string) {
-	if service.currentGeneratorsRun.Load() < service.maxRunnedGenerators {
-		select {
-		case <-service.stopCh:
-			return
-		default:
-			service.startGenerator()
-			go service.generator(orderNum)
-		}
+// Apply применяет ответ, присланный системой расчёта в callback, тем же путём, что и опрос.
+// Пока статус не конечный, опрос заказа откладывается ещё на callbackWindow.
+func (service *CheckAccrualService) Apply(ctx context.Context, ar *domain.AccrualResponse) error {
+	final, err := service.apply(ctx, ar, domain.SourceWebhook)
+	if err != nil {
+		return fmt.Errorf("check accrual service, apply: %w", err)
+	}
+	if final || service.callbackWindow == 0 {
+		return nil
+	}
+	err = service.repo.DeferJobs(ctx, []string{ar.OrderNum}, service.callbackWindow)
+	if err != nil {
+		return fmt.Errorf("check accrual service, apply: %w", err)
 	}
+	return nil
 }
 
-func (service *CheckAccrualService) generator(orderNum string) {
-	select {
-	case <-service.stopCh:
-		service.endGenerator()
-		return
-	default:
-		service.orderNumsCh <- orderNum
-		service.endGenerator()
-		return
+// забирает из accrual_jobs пачку задач под аренду и раздаёт их воркерам,
+// если задач нет - ждёт pollInterval или сигнала от Process
+func (service *CheckAccrualService) poller() {
+	defer service.wg.Done()
+	for service.ctx.Err() == nil {
+		jobs, err := service.repo.ClaimJobs(service.ctx, service.instanceID, service.batchSize, service.leaseDuration)
+		if err != nil && service.ctx.Err() == nil {
+			service.errorCh <- fmt.Errorf("check accrual service, poller, claim jobs: %w", err)
+		}
+		if len(jobs) == 0 {
+			select {
+			case <-service.ctx.Done():
+			case <-service.wakeCh:
+			case <-time.After(service.pollInterval):
+			}
+			continue
+		}
+		for i := range jobs {
+			select {
+			case <-service.ctx.Done():
+				// невыданные задачи освободятся в Shutdown
+				return
+			case service.jobsCh <- jobs[i]:
+			}
+		}
 	}
 }
 
-var perMinuteRegexp = regexp.MustCompile(`\d+`)
-
-// вычитывает из orderNumsCh номер заказа и делает GET запрос в сервис проверки
-// и кладёт объект в канал, который обрабатывает заказ
-func (service *CheckAccrualService) checker() {
+func (service *CheckAccrualService) worker() {
+	defer service.wg.Done()
 	for {
 		select {
-		case <-service.stopCh:
-			close(service.checkerEndCh)
-			service.logger.Debugln("CLOSE CHANNEL checkerEndCh")
+		case <-service.ctx.Done():
 			return
-		case orderNum := <-service.orderNumsCh:
-			service.logger.Debugln("ORDER_NUM", orderNum)
-			request, err := http.NewRequest(http.MethodGet, service.accrualAddr+"/api/orders/"+orderNum, http.NoBody)
-			if err != nil {
-				service.errorCh <- fmt.Errorf("check accrual service, checker, new request: %w", err)
-				service.orderNumsCh <- orderNum // чтобы не упустить из обработки orderNum
-				break
-			}
-			response, err := http.DefaultClient.Do(request)
-			if err != nil {
-				service.errorCh <- fmt.Errorf("check accrual service, checker, do request: %w", err)
-				service.orderNumsCh <- orderNum // чтобы не упустить из обработки orderNum
-				break
-			}
-			if response.StatusCode == http.StatusOK {
-				data, err := getAccrualResponse(response)
-				if err != nil {
-					service.errorCh <- fmt.Errorf("check accrual service, checker, response status ok, get accrual response: %w", err)
-					service.orderNumsCh <- orderNum // чтобы не упустить из обработки orderNum
-					break
-				}
-				response.Body.Close()
-				// service.accrualResponseCh <- data
-				service.writeData(data)
-
-			} else if response.StatusCode == http.StatusNoContent {
-				service.orderNumsCh <- orderNum // чтобы не упустить из обработки orderNum
-			} else if response.StatusCode == http.StatusTooManyRequests {
-				// вычитать данные из заголовка Retry-After, сохранить как количество секунд
-				// получить Н из "No more than N requests per minute allowed", чтобы определить паузу между запросам
-				var retryAfter int
-				if retryAfterString := response.Header.Get("Retry-After"); len(retryAfterString) != 0 {
-					v, err := strconv.Atoi(retryAfterString)
-					if err != nil {
-						service.errorCh <- fmt.Errorf("check accrual service, checker, retry-after header atoi: %w", err)
-						service.orderNumsCh <- orderNum // чтобы не упустить из обработки orderNum
-						break
-					}
-					retryAfter = v
-					service.logger.Debugln("retry after ", retryAfter)
-				}
-				body, err := io.ReadAll(response.Body)
-				if err != nil {
-					service.errorCh <- fmt.Errorf("check accrual service, checker, 429, read body: %w", err)
-					service.orderNumsCh <- orderNum // чтобы не упустить из обработки orderNum
-					break
-				}
-				response.Body.Close()
-				requestPerMinuteStr := perMinuteRegexp.Find(body)
-				service.logger.Debugln("PER MINUTE REGEXP FIND ", string(requestPerMinuteStr))
-				if len(requestPerMinuteStr) != 0 {
-					v, err := strconv.Atoi(string(requestPerMinuteStr))
-					if err != nil {
-						service.errorCh <- fmt.Errorf("check accrual service, checker, 429, requestPerMinuteStr atoi: %w", err)
-						service.orderNumsCh <- orderNum // чтобы не упустить из обработки orderNum
-						break
-					}
-					service.logger.Debugln("REQUEST PER MINUTE ", v)
-					service.pauseBetweenRequests = time.Duration(60/v) * time.Second
-					service.logger.Debugln("NEW PAUSE BETWEEN REQUESTS ", service.pauseBetweenRequests)
-				}
-				time.Sleep(time.Duration(retryAfter) - service.pauseBetweenRequests)
-			}
-			time.Sleep(service.pauseBetweenRequests)
+		case job := <-service.jobsCh:
+			service.check(job)
 		}
 	}
-
 }
 
-func (service *CheckAccrualService) writeData(ar *AccrualResponse) {
-	service.logger.Debugln("service.accrualResponseCh", ar, decimal.Decimal(ar.Accrual).String())
-	switch ar.Status {
-	case Registered:
-		service.orderNumsCh <- ar.OrderNum
-	case Invalid:
-		service.logger.Debugln("INVALID ORDER_NUM", ar.OrderNum, ar)
-		err := service.writeInvalid(ar.OrderNum)
-		if err != nil {
-			service.errorCh <- fmt.Errorf("dbWriter, invalid: %w", err)
-			// service.accrualResponseCh <- ar // чтобы попробовать записать ещё раз
+func (service *CheckAccrualService) check(job domain.AccrualJob) {
+	service.logger.Debugln("ORDER_NUM", job.OrderNum)
+	// таймаут запроса задаёт клиент: ожидание общего лимитера не должно съедать время запроса,
+	// иначе при троттлинге задачи тратили бы попытки, не дойдя до системы расчёта
+	ar, err := service.client.GetOrderAccrual(service.ctx, job.OrderNum)
+	if err != nil {
+		if service.ctx.Err() != nil {
+			// сервис останавливается, аренда снимется в Shutdown
+			return
 		}
-	case Processing:
-		err := service.writeProcessing(ar.OrderNum)
-		if err != nil {
-			service.errorCh <- fmt.Errorf("dbWriter, processing: %w", err)
-			// service.accrualResponseCh <- ar // чтобы попробовать записать ещё раз
+		if errors.Is(err, ports.ErrAccrualRateLimited) {
+			service.postpone(job, err)
+			return
 		}
-		service.orderNumsCh <- ar.OrderNum
-	case Processed:
-		err := service.writeProcessed(ar)
-		if err != nil {
-			service.errorCh <- fmt.Errorf("dbWriter, procedd: %w", err)
-			// service.accrualResponseCh <- ar // чтобы попробовать записать ещё раз
+		if !errors.Is(err, ports.ErrAccrualNotRegistered) {
+			service.errorCh <- fmt.Errorf("check accrual service, checker, get order accrual: %w", err)
 		}
+		service.retry(job)
+		return
 	}
-}
-
-// func (service *CheckAccrualService) dbWriter() {
-// 	for {
-// 		select {
-// 		case <-service.stopCh:
-// 			close(service.dbWriterEndCh)
-// 			service.logger.Debugln("CLOSE CHANNEL dbWriterEndCh")
-// 			return
-// 		case ar := <-service.accrualResponseCh:
-// 			service.logger.Debugln("service.accrualResponseCh", ar, decimal.Decimal(ar.Accrual).String())
-// 			switch ar.Status {
-// 			case Registered:
-// 				service.orderNumsCh <- ar.OrderNum
-// 			case Invalid:
-// 				service.logger.Debugln("INVALID ORDER_NUM", ar.OrderNum, ar)
-// 				err := service.writeInvalid(ar.OrderNum)
-// 				if err != nil {
-// 					service.errorCh <- fmt.Errorf("dbWriter, invalid: %w", err)
-// 					service.accrualResponseCh <- ar // чтобы попробовать записать ещё раз
-// 				}
-// 			case Processing:
-// 				err := service.writeProcessing(ar.OrderNum)
-// 				if err != nil {
-// 					service.errorCh <- fmt.Errorf("dbWriter, processing: %w", err)
-// 					service.accrualResponseCh <- ar // чтобы попробовать записать ещё раз
-// 				}
-// 				service.orderNumsCh <- ar.OrderNum
-// 			case Processed:
-// 				err := service.writeProcessed(ar)
-// 				if err != nil {
-// 					service.errorCh <- fmt.Errorf("dbWriter, procedd: %w", err)
-// 					service.accrualResponseCh <- ar // чтобы попробовать записать ещё раз
-// 				}
-// 			}
-// 		}
-// 	}
-// }
-
-func (service *CheckAccrualService) writeInvalid(orderNum string) error {
-	_, err := service.dbpool.Exec(context.Background(), "UPDATE orders SET status='INVALID' WHERE order_num=$1;", orderNum)
-	if err != nil {
-		return fmt.Errorf("write invalid: %w", err)
+	if !service.writeData(ar) {
+		service.retry(job)
 	}
-	return nil
 }
 
-func (service *CheckAccrualService) writeProcessing(orderNum string) error {
-	_, err := service.dbpool.Exec(context.Background(), "UPDATE orders SET status='PROCESSING' WHERE order_num=$1;", orderNum)
+// retry откладывает следующую попытку по retryPolicy и снимает аренду,
+// а если попытки исчерпаны - переводит заказ в FAILED_CHECK
+func (service *CheckAccrualService) retry(job domain.AccrualJob) {
+	if service.retryPolicy.Exhausted(job, time.Now()) {
+		service.logger.Infoln("check accrual service, retries exhausted, order", job.OrderNum, "attempts", job.Attempts)
+		err := changeStatus(context.Background(), service.repo,
+			domain.StatusTransition{OrderNum: job.OrderNum, To: domain.FailedCheck, Source: domain.SourcePoller},
+			func(ctx context.Context, transition *domain.StatusTransition) error {
+				return service.repo.FailJob(ctx, transition, service.instanceID)
+			})
+		if err != nil {
+			service.errorCh <- fmt.Errorf("check accrual service, fail job: %w", err)
+		}
+		return
+	}
+	err := service.repo.RescheduleJob(context.Background(), job.OrderNum, service.instanceID, service.retryPolicy.Delay(job.Attempts))
 	if err != nil {
-		return fmt.Errorf("write processing: %w", err)
+		service.errorCh <- fmt.Errorf("check accrual service, reschedule job: %w", err)
 	}
-	return nil
 }
 
-func (service *CheckAccrualService) writeProcessed(ar *AccrualResponse) error {
-	var uid int64
-	err := service.dbpool.QueryRow(context.Background(), "UPDATE orders SET status='PROCESSED', accrual=$2 WHERE order_num=$1 AND status!='PROCESSED' RETURNING uid;", ar.OrderNum, decimal.Decimal(ar.Accrual).String()).Scan(&uid)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil
-		}
-		return fmt.Errorf("write processed, update status: %w", err)
+// postpone откладывает задачу после 429 на Retry-After, не расходуя попытку:
+// заказ не проверялся, и из-за лимита запросов он не должен уйти в FAILED_CHECK
+func (service *CheckAccrualService) postpone(job domain.AccrualJob, err error) {
+	delay := service.retryPolicy.Delay(1)
+	var rateLimitErr *ports.AccrualRateLimitError
+	if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > 0 {
+		delay = rateLimitErr.RetryAfter
 	}
-	if decimal.Decimal(ar.Accrual).IsZero() {
-		return nil
+	err = service.repo.PostponeJob(context.Background(), job.OrderNum, service.instanceID, delay)
+	if err != nil {
+		service.errorCh <- fmt.Errorf("check accrual service, postpone job: %w", err)
 	}
+}
 
-	service.logger.Debug("SELECTED UID ", uid)
-
-	_, err = service.dbpool.Exec(context.Background(), "UPDATE balance SET current=current+$1 WHERE uid=$2;", decimal.Decimal(ar.Accrual).String(), uid)
+// writeData сохраняет ответ системы расчёта, возвращает true, если заказ больше не нужно проверять
+func (service *CheckAccrualService) writeData(ar *domain.AccrualResponse) bool {
+	final, err := service.apply(context.Background(), ar, domain.SourcePoller)
 	if err != nil {
-		return fmt.Errorf("write processed, update: %w", err)
+		service.errorCh <- err
+		return false
 	}
-	return nil
+	return final
 }
 
-func (service *CheckAccrualService) errorLog() {
-	for {
-		select {
-		case <-service.stopCh:
-			close(service.errorLogEndCh)
-			service.logger.Debugln("CLOSE CHANNEL errorLogEndCh")
-			return
-		case err := <-service.errorCh:
-			service.logger.Errorf("CheckAccrualService: %v", err)
+// apply переводит заказ в статус из ответа системы расчёта, возвращает true, если статус конечный
+func (service *CheckAccrualService) apply(ctx context.Context, ar *domain.AccrualResponse, source domain.StatusSource) (bool, error) {
+	service.logger.Debugln("service.accrualResponseCh", ar, ar.Accrual.String())
+	transition := domain.StatusTransition{
+		OrderNum:    ar.OrderNum,
+		Source:      source,
+		RawResponse: rawAccrualResponse(ar),
+	}
+	switch ar.Status {
+	case domain.AccrualInvalid:
+		service.logger.Debugln("INVALID ORDER_NUM", ar.OrderNum, ar)
+		transition.To = domain.Invalid
+		err := changeStatus(ctx, service.repo, transition, service.repo.WriteInvalid)
+		if err != nil {
+			return false, fmt.Errorf("dbWriter, invalid: %w", err)
+		}
+		return true, nil
+	case domain.AccrualProcessing:
+		transition.To = domain.Processing
+		err := changeStatus(ctx, service.repo, transition, service.repo.WriteProcessing)
+		if err != nil {
+			return false, fmt.Errorf("dbWriter, processing: %w", err)
 		}
+		return false, nil
+	case domain.AccrualProcessed:
+		transition.To = domain.Processed
+		transition.Accrual = ar.Accrual
+		err := changeStatus(ctx, service.repo, transition, service.repo.WriteProcessed)
+		if err != nil {
+			return false, fmt.Errorf("dbWriter, procedd: %w", err)
+		}
+		return true, nil
 	}
+	return false, nil
 }
 
-func (service *CheckAccrualService) Shutdown() {
-	close(service.stopCh)
-	service.logger.Debugln("LEN orderNumsCh", len(service.orderNumsCh))
-	for len(service.orderNumsCh) > 0 {
-		service.logger.Debugln("LEN orderNumsCh read ", len(service.orderNumsCh))
-		<-service.orderNumsCh
+// rawAccrualResponse - ответ как его прислала система расчёта, для клиентов без тела ответа - он же, заново сериализованный
+func rawAccrualResponse(ar *domain.AccrualResponse) json.RawMessage {
+	if len(ar.Raw) > 0 {
+		return ar.Raw
 	}
-	service.generatorsWG.Wait()
-	<-service.errorLogEndCh
-	<-service.checkerEndCh
-	// <-service.dbWriterEndCh
-	<-service.dbLoaderEndCh
-	close(service.orderNumsCh)
-	service.logger.Debugln("CLOSE CHANNEL orderNumsCh")
-	close(service.errorCh)
-	service.logger.Debugln("CLOSE CHANNEL errorCh")
-	close(service.accrualResponseCh)
-	service.logger.Debugln("CLOSE CHANNEL accrualResponseCh")
-}
-
-func getAccrualResponse(response *http.Response) (*AccrualResponse, error) {
-	body, err := io.ReadAll(response.Body)
+	raw, err := json.Marshal(ar)
 	if err != nil {
-		return nil, fmt.Errorf("getAccrualResponse, read body: %w", err)
-	}
-	ar := &AccrualResponse{}
-	if err = json.Unmarshal(body, &ar); err != nil {
-		return nil, fmt.Errorf("getAccrualResponse, unmarshal body: %w, body: %v", err, string(body))
+		return nil
 	}
-	return ar, nil
+	return raw
 }
 
-type Amount decimal.Decimal
-
-func (a *Amount) UnmarshalJSON(b []byte) error {
-	var s float64
-	if err := json.Unmarshal(b, &s); err != nil {
-		return fmt.Errorf("unmarshal amount: %w", err)
-	}
-	v := decimal.NewFromFloat(s)
-	*a = Amount(v)
-	return nil
+func (service *CheckAccrualService) GetFailedOrders(ctx context.Context) ([]domain.Order, error) {
+	return service.repo.GetFailedOrders(ctx)
 }
 
-func (a Amount) MarshalJSON() ([]byte, error) {
-	s := decimal.Decimal(a).String()
-	v, err := strconv.ParseFloat(s, 64)
+func (service *CheckAccrualService) Requeue(ctx context.Context, orderNum string) error {
+	err := changeStatus(ctx, service.repo,
+		domain.StatusTransition{OrderNum: orderNum, To: domain.New, Source: domain.SourceAdmin},
+		service.repo.RequeueFailed)
 	if err != nil {
-		return nil, fmt.Errorf("marshal amount: %w", err)
-	}
-	return json.Marshal(v)
-}
-
-type Status int
-
-func (status *Status) UnmarshalJSON(b []byte) error {
-	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
-		return fmt.Errorf("unmarshal status: %w", err)
-	}
-	switch s {
-	case "REGISTERED":
-		*status = Registered
-	case "INVALID":
-		*status = Invalid
-	case "PROCESSING":
-		*status = Processing
-	case "PROCESSED":
-		*status = Processed
-	default:
-		return fmt.Errorf("unmarshal status, unknown status")
+		return fmt.Errorf("check accrual service, requeue: %w", err)
 	}
+	service.Process(orderNum)
 	return nil
 }
 
-func (status Status) MarshalJSON() ([]byte, error) {
-	var s string
-	switch status {
-	case Registered:
-		s = "REGISTERED"
-	case Invalid:
-		s = "INVALID"
-	case Processing:
-		s = "PROCESSING"
-	case Processed:
-		s = "PROCESSED"
+func (service *CheckAccrualService) errorLog() {
+	for err := range service.errorCh {
+		service.logger.Errorf("CheckAccrualService: %v", err)
 	}
-	return json.Marshal(s)
+	close(service.errorLogEndCh)
+	service.logger.Debugln("CLOSE CHANNEL errorLogEndCh")
 }
 
-const (
-	Registered Status = iota
-	Invalid
-	Processing
-	Processed
-)
-
-type AccrualResponse struct {
-	OrderNum string `json:"order"`
-	Status   Status `json:"status"`
-	Accrual  Amount `json:"accrual"`
+// Shutdown отменяет контекст воркеров, дожидается их и снимает аренду с задач этого экземпляра,
+// чтобы другие экземпляры могли забрать их сразу, не дожидаясь истечения аренды
+func (service *CheckAccrualService) Shutdown() {
+	service.cancel()
+	service.wg.Wait()
+	close(service.errorCh)
+	<-service.errorLogEndCh
+	err := service.repo.ReleaseJobs(context.Background(), service.instanceID)
+	if err != nil {
+		service.logger.Errorf("CheckAccrualService, shutdown, release jobs: %v", err)
+	}
 }
@@ -1,56 +1,296 @@
 package services
 
 import (
-	"encoding/json"
+	"context"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/shopspring/decimal"
+	"github.com/Svirex/gofermart-loyality/internal/adapters/accrual"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
-func TestAccrualResponseUnmarshal(t *testing.T) {
-	j := `
-	{
-        "order": "367347345",
-        "status": "PROCESSED",
-        "accrual": 500
-    }
-	`
-	var ar AccrualResponse
-	err := json.Unmarshal([]byte(j), &ar)
-	require.NoError(t, err)
+type fakeJob struct {
+	job         domain.AccrualJob
+	owner       string
+	nextAttempt time.Time
+}
 
-	require.Equal(t, "367347345", ar.OrderNum)
-	require.Equal(t, Processed, ar.Status)
-	d := decimal.NewFromFloat(500)
-	require.Equal(t, Amount(d), ar.Accrual)
-}
-
-func TestAccrualResponseUnmarshalWithoutAccrual(t *testing.T) {
-	j := `
-	{
-        "order": "367347345",
-        "status": "PROCESSED"
-    }
-	`
-	var ar AccrualResponse
-	err := json.Unmarshal([]byte(j), &ar)
-	require.NoError(t, err)
+// fakeAccrualRepository - очередь accrual_jobs и статусы заказов в памяти
+type fakeAccrualRepository struct {
+	mu       sync.Mutex
+	jobs     map[string]*fakeJob
+	statuses map[string]domain.Status
+	accruals map[string]domain.Money
+	history  []domain.StatusTransition
+}
+
+var _ ports.AccrualRepository = (*fakeAccrualRepository)(nil)
+
+func newFakeAccrualRepository(orderNums ...string) *fakeAccrualRepository {
+	repo := &fakeAccrualRepository{
+		jobs:     make(map[string]*fakeJob),
+		statuses: make(map[string]domain.Status),
+		accruals: make(map[string]domain.Money),
+	}
+	for _, orderNum := range orderNums {
+		repo.jobs[orderNum] = &fakeJob{job: domain.AccrualJob{OrderNum: orderNum, CreatedAt: time.Now()}}
+		repo.statuses[orderNum] = domain.New
+	}
+	return repo
+}
+
+func (repo *fakeAccrualRepository) status(orderNum string) domain.Status {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.statuses[orderNum]
+}
+
+func (repo *fakeAccrualRepository) ClaimJobs(ctx context.Context, owner string, limit int, lease time.Duration) ([]domain.AccrualJob, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	jobs := make([]domain.AccrualJob, 0)
+	for _, j := range repo.jobs {
+		if len(jobs) == limit {
+			break
+		}
+		if j.owner != "" || j.nextAttempt.After(time.Now()) {
+			continue
+		}
+		j.owner = owner
+		j.job.Attempts++
+		jobs = append(jobs, j.job)
+	}
+	return jobs, nil
+}
+
+func (repo *fakeAccrualRepository) RescheduleJob(ctx context.Context, orderNum string, owner string, delay time.Duration) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if j, ok := repo.jobs[orderNum]; ok && j.owner == owner {
+		j.owner = ""
+		j.nextAttempt = time.Now().Add(delay)
+	}
+	return nil
+}
 
-	require.Equal(t, "367347345", ar.OrderNum)
-	require.Equal(t, Processed, ar.Status)
-	d := decimal.Decimal{}
-	require.Equal(t, Amount(d), ar.Accrual)
+func (repo *fakeAccrualRepository) PostponeJob(ctx context.Context, orderNum string, owner string, delay time.Duration) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if j, ok := repo.jobs[orderNum]; ok && j.owner == owner {
+		j.owner = ""
+		j.nextAttempt = time.Now().Add(delay)
+		j.job.Attempts--
+	}
+	return nil
 }
 
-func TestAccrualResponseMarshal(t *testing.T) {
-	ar := AccrualResponse{
-		OrderNum: "367347345",
-		Status:   Processing,
-		Accrual:  Amount(decimal.NewFromFloat(0.143123)),
+func (repo *fakeAccrualRepository) ReleaseJobs(ctx context.Context, owner string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, j := range repo.jobs {
+		if j.owner == owner {
+			j.owner = ""
+		}
 	}
-	b, err := json.Marshal(ar)
+	return nil
+}
+
+func (repo *fakeAccrualRepository) DeferJobs(ctx context.Context, orderNums []string, delay time.Duration) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, orderNum := range orderNums {
+		if j, ok := repo.jobs[orderNum]; ok && j.owner == "" {
+			j.nextAttempt = time.Now().Add(delay)
+		}
+	}
+	return nil
+}
+
+func (repo *fakeAccrualRepository) hasJob(orderNum string) bool {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	_, ok := repo.jobs[orderNum]
+	return ok
+}
+
+func (repo *fakeAccrualRepository) GetOrderStatus(ctx context.Context, orderNum string) (domain.Status, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	status, ok := repo.statuses[orderNum]
+	if !ok {
+		return "", ports.ErrOrderNotFound
+	}
+	return status, nil
+}
+
+// changeStatus - то же условие на transition.From, что и в postgres, вызывается под mu
+func (repo *fakeAccrualRepository) changeStatus(transition *domain.StatusTransition) error {
+	if repo.statuses[transition.OrderNum] != transition.From {
+		return ports.ErrStatusChanged
+	}
+	repo.statuses[transition.OrderNum] = transition.To
+	repo.history = append(repo.history, *transition)
+	return nil
+}
+
+func (repo *fakeAccrualRepository) FailJob(ctx context.Context, transition *domain.StatusTransition, owner string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if err := repo.changeStatus(transition); err != nil {
+		return err
+	}
+	delete(repo.jobs, transition.OrderNum)
+	return nil
+}
+
+func (repo *fakeAccrualRepository) GetFailedOrders(ctx context.Context) ([]domain.Order, error) {
+	return nil, nil
+}
+
+func (repo *fakeAccrualRepository) RequeueFailed(ctx context.Context, transition *domain.StatusTransition) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if err := repo.changeStatus(transition); err != nil {
+		return err
+	}
+	repo.jobs[transition.OrderNum] = &fakeJob{job: domain.AccrualJob{OrderNum: transition.OrderNum, CreatedAt: time.Now()}}
+	return nil
+}
+
+func (repo *fakeAccrualRepository) WriteInvalid(ctx context.Context, transition *domain.StatusTransition) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if err := repo.changeStatus(transition); err != nil {
+		return err
+	}
+	delete(repo.jobs, transition.OrderNum)
+	return nil
+}
+
+func (repo *fakeAccrualRepository) WriteProcessing(ctx context.Context, transition *domain.StatusTransition) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.changeStatus(transition)
+}
+
+func (repo *fakeAccrualRepository) WriteProcessed(ctx context.Context, transition *domain.StatusTransition) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if err := repo.changeStatus(transition); err != nil {
+		return err
+	}
+	delete(repo.jobs, transition.OrderNum)
+	repo.accruals[transition.OrderNum] = transition.Accrual
+	return nil
+}
+
+func newTestCheckAccrualService(t *testing.T, repo ports.AccrualRepository, client ports.AccrualClient, retry RetryPolicy) *CheckAccrualService {
+	return newTestCheckAccrualServiceWindow(t, repo, client, retry, 0)
+}
+
+func newTestCheckAccrualServiceWindow(t *testing.T, repo ports.AccrualRepository, client ports.AccrualClient, retry RetryPolicy, window time.Duration) *CheckAccrualService {
+	service, err := NewCheckAccrualService(repo, client, zap.NewNop().Sugar(), CheckAccrualConfig{
+		InstanceID:     "test",
+		Workers:        2,
+		PollInterval:   10 * time.Millisecond,
+		BatchSize:      10,
+		LeaseDuration:  time.Minute,
+		Retry:          retry,
+		CallbackWindow: window,
+	})
+	require.NoError(t, err)
+	return service
+}
+
+func TestCheckAccrualServiceWritesStatuses(t *testing.T) {
+	repo := newFakeAccrualRepository("18", "26", "67", "217")
+	client := accrual.NewFakeClient()
+	client.Set(&domain.AccrualResponse{OrderNum: "18", Status: domain.AccrualInvalid})
+	client.Set(&domain.AccrualResponse{OrderNum: "26", Status: domain.AccrualProcessing})
+	client.Set(&domain.AccrualResponse{OrderNum: "67", Status: domain.AccrualProcessed, Accrual: 50000})
+
+	service := newTestCheckAccrualService(t, repo, client, RetryPolicy{BaseDelay: time.Millisecond})
+	service.Start()
+
+	require.Eventually(t, func() bool {
+		return repo.status("18") == domain.Invalid &&
+			repo.status("26") == domain.Processing &&
+			repo.status("67") == domain.Processed &&
+			client.Calls("26") > 1 &&
+			client.Calls("217") > 1
+	}, time.Second, 10*time.Millisecond)
+
+	service.Shutdown()
+
+	require.Equal(t, domain.New, repo.status("217"))
+	require.Equal(t, domain.Money(50000), repo.accruals["67"])
+	require.Equal(t, 1, client.Calls("67"))
+	for _, transition := range repo.history {
+		require.Equal(t, domain.SourcePoller, transition.Source)
+		require.NotEmpty(t, transition.RawResponse)
+	}
+}
+
+func TestCheckAccrualServiceFailsExhaustedJobs(t *testing.T) {
+	repo := newFakeAccrualRepository("217")
+	client := accrual.NewFakeClient()
+
+	service := newTestCheckAccrualService(t, repo, client, RetryPolicy{BaseDelay: time.Millisecond, MaxAttempts: 3})
+	service.Start()
+
+	require.Eventually(t, func() bool {
+		return repo.status("217") == domain.FailedCheck
+	}, time.Second, 10*time.Millisecond)
+
+	service.Shutdown()
+
+	require.Equal(t, 3, client.Calls("217"))
+}
+
+func TestCheckAccrualServiceRateLimitKeepsAttempts(t *testing.T) {
+	repo := newFakeAccrualRepository("217")
+	client := accrual.NewFakeClient()
+	client.SetError("217", &ports.AccrualRateLimitError{RetryAfter: time.Millisecond, Message: "slow down"})
+
+	service := newTestCheckAccrualService(t, repo, client, RetryPolicy{BaseDelay: time.Millisecond, MaxAttempts: 2})
+	service.Start()
+
+	require.Eventually(t, func() bool {
+		return client.Calls("217") > 3
+	}, time.Second, 10*time.Millisecond)
+
+	service.Shutdown()
+
+	require.Equal(t, domain.New, repo.status("217"))
+}
+
+func TestCheckAccrualServiceApplyCallback(t *testing.T) {
+	repo := newFakeAccrualRepository("18", "67")
+	client := accrual.NewFakeClient()
+
+	service := newTestCheckAccrualServiceWindow(t, repo, client, RetryPolicy{BaseDelay: time.Millisecond}, time.Hour)
+	// так задачи новых заказов заводит OrdersRepository
+	require.NoError(t, repo.DeferJobs(context.Background(), []string{"18", "67"}, service.FirstCheckDelay()))
+	service.Start()
+
+	err := service.Apply(context.Background(), &domain.AccrualResponse{OrderNum: "18", Status: domain.AccrualProcessing})
 	require.NoError(t, err)
-	expected := `{"order":"367347345","status":"PROCESSING","accrual":0.143123}`
-	require.Equal(t, expected, string(b))
+	require.Equal(t, domain.Processing, repo.status("18"))
+	require.True(t, repo.hasJob("18"))
+
+	err = service.Apply(context.Background(), &domain.AccrualResponse{OrderNum: "67", Status: domain.AccrualProcessed, Accrual: 50000})
+	require.NoError(t, err)
+	require.Equal(t, domain.Processed, repo.status("67"))
+	require.False(t, repo.hasJob("67"))
+
+	// опрос отложен на callbackWindow
+	time.Sleep(50 * time.Millisecond)
+	service.Shutdown()
+
+	require.Equal(t, 0, client.Calls("18"))
+	require.Equal(t, 0, client.Calls("67"))
 }
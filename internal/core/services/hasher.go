@@ -0,0 +1,204 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher - алгоритм хеширования паролей. Хеш содержит алгоритм и параметры,
+// поэтому проверка не зависит от текущих настроек.
+type PasswordHasher interface {
+	// Name - идентификатор алгоритма в настройках
+	Name() string
+	Hash(password string) (string, error)
+	// Supports сообщает, создан ли хеш этим алгоритмом
+	Supports(hash string) bool
+	// Verify возвращает false без ошибки, если пароль не подходит
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash - хеш создан этим алгоритмом, но с параметрами слабее текущих
+	NeedsRehash(hash string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+var _ PasswordHasher = BcryptHasher{}
+
+func (h BcryptHasher) Name() string {
+	return "bcrypt"
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt hash: %w", err)
+	}
+	return string(hash), nil
+}
+
+func (h BcryptHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h BcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("bcrypt verify: %w", err)
+	}
+	return true, nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
+
+// Argon2idHasher хранит хеш в формате PHC: $argon2id$v=19$m=65536,t=3,p=2$соль$хеш
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+var _ PasswordHasher = Argon2idHasher{}
+
+// Предельные параметры argon2id: хеш с параметрами больше отвергается при проверке
+const (
+	Argon2MaxTime      = 64
+	Argon2MaxMemoryKiB = 4 * 1024 * 1024
+)
+
+// Validate проверяет параметры на те же пределы, что и хеши из базы,
+// иначе созданные хеши не пройдут проверку при входе
+func (h Argon2idHasher) Validate() error {
+	if h.Time == 0 || h.Time > Argon2MaxTime {
+		return fmt.Errorf("argon2id time must be in [1, %d]: %d", Argon2MaxTime, h.Time)
+	}
+	if h.Memory == 0 || h.Memory > Argon2MaxMemoryKiB {
+		return fmt.Errorf("argon2id memory must be in [1, %d] KiB: %d", Argon2MaxMemoryKiB, h.Memory)
+	}
+	if h.Threads == 0 {
+		return fmt.Errorf("argon2id threads must be positive")
+	}
+	if h.KeyLen == 0 || h.SaltLen == 0 {
+		return fmt.Errorf("argon2id key and salt length must be positive: key %d, salt %d", h.KeyLen, h.SaltLen)
+	}
+	return nil
+}
+
+type argon2Params struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h Argon2idHasher) Name() string {
+	return "argon2id"
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("argon2id hash, salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h Argon2idHasher) Verify(hash, password string) (bool, error) {
+	params, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, fmt.Errorf("argon2id verify: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return params.version != argon2.Version || params.memory < h.Memory || params.time < h.Time ||
+		params.threads < h.Threads || uint32(len(params.key)) < h.KeyLen || uint32(len(params.salt)) < h.SaltLen
+}
+
+func parseArgon2idHash(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errUnknownHashFormat
+	}
+	params := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &params.version); err != nil {
+		return nil, fmt.Errorf("parse argon2id version: %w", err)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, fmt.Errorf("parse argon2id params: %w", err)
+	}
+	// хеш из базы не должен заставить сервер выделить гигабайты или считать вечно
+	if params.memory == 0 || params.memory > Argon2MaxMemoryKiB || params.time == 0 || params.time > Argon2MaxTime || params.threads == 0 {
+		return nil, fmt.Errorf("argon2id params out of range: m=%d, t=%d, p=%d", params.memory, params.time, params.threads)
+	}
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("parse argon2id salt: %w", err)
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("parse argon2id key: %w", err)
+	}
+	if len(params.key) == 0 {
+		return nil, fmt.Errorf("parse argon2id, empty key")
+	}
+	return params, nil
+}
+
+// passwordHashers хеширует текущим алгоритмом и проверяет хеши всех известных алгоритмов
+type passwordHashers struct {
+	current PasswordHasher
+	all     []PasswordHasher
+}
+
+func newPasswordHashers(current PasswordHasher, known ...PasswordHasher) *passwordHashers {
+	return &passwordHashers{current: current, all: append([]PasswordHasher{current}, known...)}
+}
+
+func (h *passwordHashers) hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *passwordHashers) verify(hash, password string) (bool, error) {
+	for _, hasher := range h.all {
+		if hasher.Supports(hash) {
+			return hasher.Verify(hash, password)
+		}
+	}
+	return false, errUnknownHashFormat
+}
+
+// needsRehash - хеш создан другим алгоритмом или с параметрами слабее текущих
+func (h *passwordHashers) needsRehash(hash string) bool {
+	return !h.current.Supports(hash) || h.current.NeedsRehash(hash)
+}
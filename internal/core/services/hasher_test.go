@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2id - минимальные параметры, чтобы тесты не тратили память и время
+var testArgon2id = Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+func TestPasswordHashers(t *testing.T) {
+	for _, hasher := range []PasswordHasher{BcryptHasher{Cost: bcrypt.MinCost}, testArgon2id} {
+		t.Run(hasher.Name(), func(t *testing.T) {
+			hash, err := hasher.Hash("Gopher-Mart-Loyal-42")
+			require.NoError(t, err)
+			require.True(t, hasher.Supports(hash))
+			require.False(t, hasher.NeedsRehash(hash))
+
+			ok, err := hasher.Verify(hash, "Gopher-Mart-Loyal-42")
+			require.NoError(t, err)
+			require.True(t, ok)
+			ok, err = hasher.Verify(hash, "Gopher-Mart-Loyal-43")
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			other, err := hasher.Hash("Gopher-Mart-Loyal-42")
+			require.NoError(t, err)
+			require.NotEqual(t, hash, other, "salt must differ")
+		})
+	}
+}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash("Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	stronger := testArgon2id
+	stronger.Time = 2
+	require.True(t, stronger.NeedsRehash(hash))
+	stronger = testArgon2id
+	stronger.Memory = 2048
+	require.True(t, stronger.NeedsRehash(hash))
+	weaker := testArgon2id
+	weaker.Threads = 0
+	require.False(t, weaker.NeedsRehash(hash))
+
+	// параметры из хеша ограничены, испорченная запись в базе не должна занять всю память
+	for _, bad := range []string{
+		"$argon2id$v=19$m=1073741824,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5",
+		"$argon2id$v=19$m=1024",
+	} {
+		_, err = testArgon2id.Verify(bad, "Gopher-Mart-Loyal-42")
+		require.Error(t, err, bad)
+		require.True(t, testArgon2id.NeedsRehash(bad), bad)
+	}
+}
+
+func TestPasswordHashersDispatch(t *testing.T) {
+	hashers := newPasswordHashers(BcryptHasher{Cost: bcrypt.MinCost + 1}, BcryptHasher{}, Argon2idHasher{})
+
+	argonHash, err := testArgon2id.Hash("Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	ok, err := hashers.verify(argonHash, "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, hashers.needsRehash(argonHash))
+
+	weakHash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	require.True(t, hashers.needsRehash(weakHash))
+
+	hash, err := hashers.hash("Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	require.False(t, hashers.needsRehash(hash))
+
+	_, err = hashers.verify("plain-text", "plain-text")
+	require.ErrorIs(t, err, errUnknownHashFormat)
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	sessions := newFakeSessionRepository()
+	repo := newFakeAuthRepository(sessions)
+	newService := func(hasher PasswordHasher) *AuthService {
+		service, err := NewAuthService(repo, sessions, newFakeRevocationRepository(sessions),
+			newFakeLoginThrottleRepository(time.Now), newFakeSecondFactorRepository(), AuthConfig{
+				MinPasswordLength: 8,
+				PasswordHasher:    hasher,
+				JWTSecretKey:      "fake_secret",
+				AccessTokenTTL:    15 * time.Minute,
+				RefreshTokenTTL:   time.Hour,
+			})
+		require.NoError(t, err)
+		return service
+	}
+	ctx := context.Background()
+
+	_, err := newService(BcryptHasher{Cost: bcrypt.MinCost}).Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	user, err := repo.GetUserByLogin(ctx, "svirex")
+	require.NoError(t, err)
+	oldHash := user.Hash
+
+	// неверный пароль хеш не меняет
+	service := newService(BcryptHasher{Cost: bcrypt.MinCost + 1})
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-43", "10.0.0.1")
+	require.Error(t, err)
+	user, err = repo.GetUserByLogin(ctx, "svirex")
+	require.NoError(t, err)
+	require.Equal(t, oldHash, user.Hash)
+
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.NoError(t, err)
+	user, err = repo.GetUserByLogin(ctx, "svirex")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(user.Hash))
+	require.NoError(t, err)
+	require.Equal(t, bcrypt.MinCost+1, cost)
+
+	// смена алгоритма
+	_, err = newService(testArgon2id).Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.NoError(t, err)
+	user, err = repo.GetUserByLogin(ctx, "svirex")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(user.Hash, "$argon2id$"))
+
+	// алгоритм задаётся настройками, поэтому при возврате к bcrypt хеш тоже заменяется
+	_, err = newService(BcryptHasher{Cost: bcrypt.MinCost}).Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.NoError(t, err)
+	user, err = repo.GetUserByLogin(ctx, "svirex")
+	require.NoError(t, err)
+	require.True(t, BcryptHasher{}.Supports(user.Hash))
+}
+
+func TestArgon2idValidate(t *testing.T) {
+	require.NoError(t, testArgon2id.Validate())
+	for _, bad := range []Argon2idHasher{
+		{Time: 0, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16},
+		{Time: Argon2MaxTime + 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16},
+		{Time: 1, Memory: 0, Threads: 1, KeyLen: 32, SaltLen: 16},
+		{Time: 1, Memory: Argon2MaxMemoryKiB + 1, Threads: 1, KeyLen: 32, SaltLen: 16},
+		{Time: 1, Memory: 1024, Threads: 0, KeyLen: 32, SaltLen: 16},
+		{Time: 1, Memory: 1024, Threads: 1, KeyLen: 0, SaltLen: 16},
+	} {
+		require.Error(t, bad.Validate(), bad)
+	}
+}
+
+// failingRehashRepository не может сохранить новый хеш
+type failingRehashRepository struct {
+	*fakeAuthRepository
+}
+
+func (repo failingRehashRepository) UpdatePasswordHash(ctx context.Context, uid int64, oldHash, newHash string) error {
+	return errors.New("update password hash failed")
+}
+
+func TestLoginSucceedsWhenRehashFails(t *testing.T) {
+	sessions := newFakeSessionRepository()
+	repo := newFakeAuthRepository(sessions)
+	ctx := context.Background()
+	cfg := AuthConfig{
+		MinPasswordLength: 8,
+		PasswordHasher:    BcryptHasher{Cost: bcrypt.MinCost},
+		JWTSecretKey:      "fake_secret",
+		AccessTokenTTL:    15 * time.Minute,
+		RefreshTokenTTL:   time.Hour,
+	}
+	service, err := NewAuthService(repo, sessions, newFakeRevocationRepository(sessions),
+		newFakeLoginThrottleRepository(time.Now), newFakeSecondFactorRepository(), cfg)
+	require.NoError(t, err)
+	_, err = service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	user, err := repo.GetUserByLogin(ctx, "svirex")
+	require.NoError(t, err)
+	oldHash := user.Hash
+
+	cfg.PasswordHasher = testArgon2id
+	service, err = NewAuthService(failingRehashRepository{repo}, sessions, newFakeRevocationRepository(sessions),
+		newFakeLoginThrottleRepository(time.Now), newFakeSecondFactorRepository(), cfg)
+	require.NoError(t, err)
+	tokens, err := service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens.AccessToken)
+	user, err = repo.GetUserByLogin(ctx, "svirex")
+	require.NoError(t, err)
+	require.Equal(t, oldHash, user.Hash)
+}
@@ -1,37 +1,110 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"time"
 
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID int64
+	UserID    int64
+	SessionID string `json:"sid"`
+	// Role - роль на момент выпуска токена, в токенах до появления ролей её нет
+	Role domain.Role `json:"role,omitempty"`
 }
 
-func buildJWTString(secretKey string, uid int64) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		UserID: uid,
+func buildJWTString(keys *KeyRing, uid int64, sessionID string, role domain.Role, now time.Time, ttl time.Duration) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("build jwt token, jti: %w", err)
+	}
+	tokenString, err := keys.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		UserID:    uid,
+		SessionID: sessionID,
+		Role:      role,
 	})
-	tokenString, err := token.SignedString([]byte(secretKey))
 	if err != nil {
 		return "", fmt.Errorf("build jwt token: %w", err)
 	}
 	return tokenString, nil
 }
 
-func getUserIDFromJWT(secretKey string, token string) (int64, error) {
+// parseJWT проверяет подпись ключом из кольца и срок действия, токены без exp не принимаются
+func parseJWT(keys *KeyRing, token string, now time.Time) (*Claims, error) {
 	claims := &Claims{}
-	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return []byte(secretKey), nil
+	_, err := jwt.ParseWithClaims(token, claims, keys.keyFunc, jwt.WithExpirationRequired(), jwt.WithIssuedAt(), jwt.WithTimeFunc(func() time.Time { return now }))
+	if err != nil {
+		return nil, fmt.Errorf("parse jwt: %w", err)
+	}
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("parse jwt, empty session id")
+	}
+	// у access токенов нет aud, так частичная сессия не пройдёт за access токен
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("parse jwt, unexpected audience %v", claims.Audience)
+	}
+	return claims, nil
+}
+
+// mfaAudience отличает частичную сессию после пароля от access токена
+const mfaAudience = "mfa"
+
+type mfaClaims struct {
+	jwt.RegisteredClaims
+	UserID int64
+}
+
+func buildMFAToken(keys *KeyRing, uid int64, now time.Time, ttl time.Duration) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("build mfa token, jti: %w", err)
+	}
+	tokenString, err := keys.sign(mfaClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		UserID: uid,
 	})
 	if err != nil {
-		return -1, fmt.Errorf("parse user id from jwt: %w", err)
+		return "", fmt.Errorf("build mfa token: %w", err)
+	}
+	return tokenString, nil
+}
+
+func parseMFAToken(keys *KeyRing, token string, now time.Time) (*mfaClaims, error) {
+	claims := &mfaClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, keys.keyFunc, jwt.WithExpirationRequired(), jwt.WithIssuedAt(),
+		jwt.WithAudience(mfaAudience), jwt.WithTimeFunc(func() time.Time { return now }))
+	if err != nil {
+		return nil, fmt.Errorf("parse mfa token: %w", err)
 	}
-	return claims.UserID, nil
+	return claims, nil
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyID - kid ключа, созданного из одного секрета через NewHMACKeyRing
+const DefaultKeyID = "default"
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeyRing - ключи подписи JWT. Токены подписываются одним ключом, а проверяются любым ключом кольца
+// по kid из заголовка, поэтому ротация не разлогинивает пользователей: новый ключ добавляется
+// и становится подписывающим, старый убирается после истечения выданных им токенов.
+type KeyRing struct {
+	keys       map[string]*signingKey
+	signing    *signingKey
+	defaultKey *signingKey
+}
+
+// NewHMACKeyRing возвращает кольцо из одного HS256 ключа, он же проверяет токены без kid
+func NewHMACKeyRing(secret string) *KeyRing {
+	key := &signingKey{
+		id:        DefaultKeyID,
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+	return &KeyRing{
+		keys:       map[string]*signingKey{key.id: key},
+		signing:    key,
+		defaultKey: key,
+	}
+}
+
+// LoadKeyRing загружает ключи из спецификации вида "kid:ALG:path,kid2:ALG:path".
+// Для HS256 файл содержит секрет, для RS256 и EdDSA - PEM ключ. Ключ, заданный только публичной
+// частью, годится лишь для проверки. defaultKeyID - ключ для токенов без kid, пустой - такие токены отклоняются.
+func LoadKeyRing(spec, signingKeyID, defaultKeyID string) (*KeyRing, error) {
+	ring := &KeyRing{keys: make(map[string]*signingKey)}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("load key ring, invalid key spec %q, want kid:ALG:path", item)
+		}
+		if _, ok := ring.keys[parts[0]]; ok {
+			return nil, fmt.Errorf("load key ring, duplicate kid %q", parts[0])
+		}
+		key, err := loadSigningKey(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("load key ring: %w", err)
+		}
+		ring.keys[key.id] = key
+	}
+	if len(ring.keys) == 0 {
+		return nil, fmt.Errorf("load key ring, no keys in spec")
+	}
+	ring.signing = ring.keys[signingKeyID]
+	if ring.signing == nil {
+		return nil, fmt.Errorf("load key ring, unknown signing kid %q", signingKeyID)
+	}
+	if ring.signing.signKey == nil {
+		return nil, fmt.Errorf("load key ring, signing key %q has no private part", signingKeyID)
+	}
+	if defaultKeyID != "" {
+		ring.defaultKey = ring.keys[defaultKeyID]
+		if ring.defaultKey == nil {
+			return nil, fmt.Errorf("load key ring, unknown default kid %q", defaultKeyID)
+		}
+	}
+	return ring, nil
+}
+
+func loadSigningKey(kid, alg, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key %s, read file: %w", kid, err)
+	}
+	key := &signingKey{id: kid}
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("key %s, empty secret", kid)
+		}
+		key.method, key.signKey, key.verifyKey = jwt.SigningMethodHS256, secret, secret
+	case jwt.SigningMethodRS256.Alg():
+		key.method = jwt.SigningMethodRS256
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+			key.signKey, key.verifyKey = private, &private.PublicKey
+		} else if public, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+			key.verifyKey = public
+		} else {
+			return nil, fmt.Errorf("key %s, parse RSA key: %w", kid, err)
+		}
+	case jwt.SigningMethodEdDSA.Alg():
+		key.method = jwt.SigningMethodEdDSA
+		if private, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+			key.signKey, key.verifyKey = private, private.(ed25519.PrivateKey).Public()
+		} else if public, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+			key.verifyKey = public
+		} else {
+			return nil, fmt.Errorf("key %s, parse Ed25519 key: %w", kid, err)
+		}
+	default:
+		return nil, fmt.Errorf("key %s, unsupported algorithm %q", kid, alg)
+	}
+	return key, nil
+}
+
+func (ring *KeyRing) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ring.signing.method, claims)
+	token.Header["kid"] = ring.signing.id
+	return token.SignedString(ring.signing.signKey)
+}
+
+// keyFunc выбирает ключ по kid, алгоритм токена должен совпадать с алгоритмом ключа
+func (ring *KeyRing) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := ring.defaultKey
+	if kid, ok := token.Header["kid"]; ok {
+		id, _ := kid.(string)
+		key = ring.keys[id]
+		if key == nil {
+			return nil, fmt.Errorf("unknown kid %v", kid)
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("token without kid")
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for kid %s", token.Header["alg"], key.id)
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS возвращает публичные ключи кольца, HS256 ключи не публикуются
+func (ring *KeyRing) JWKS() *domain.JWKSet {
+	set := &domain.JWKSet{Keys: []domain.JWK{}}
+	for _, key := range ring.keys {
+		jwk := domain.JWK{Kid: key.id, Alg: key.method.Alg(), Use: "sig"}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func writeTestKeys(t *testing.T) (rsaPath, edPath, edPublicPath, secretPath string) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	rsaPath = writePEM(t, "rsa.pem", "PRIVATE KEY", der)
+
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edPath = writePEM(t, "ed.pem", "PRIVATE KEY", der)
+	der, err = x509.MarshalPKIXPublicKey(edPublic)
+	require.NoError(t, err)
+	edPublicPath = writePEM(t, "ed.pub.pem", "PUBLIC KEY", der)
+
+	secretPath = filepath.Join(t.TempDir(), "legacy.secret")
+	require.NoError(t, os.WriteFile(secretPath, []byte("fake_secret\n"), 0600))
+	return
+}
+
+func signTestToken(t *testing.T, ring *KeyRing) string {
+	token, err := buildJWTString(ring, 42, "sid", domain.RoleUser, time.Now(), time.Minute)
+	require.NoError(t, err)
+	return token
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	rsaPath, edPath, _, secretPath := writeTestKeys(t)
+
+	old, err := LoadKeyRing("legacy:HS256:"+secretPath+",k1:RS256:"+rsaPath, "k1", "legacy")
+	require.NoError(t, err)
+	oldToken := signTestToken(t, old)
+
+	rotated, err := LoadKeyRing("legacy:HS256:"+secretPath+",k1:RS256:"+rsaPath+",k2:EdDSA:"+edPath, "k2", "legacy")
+	require.NoError(t, err)
+	newToken := signTestToken(t, rotated)
+
+	claims, err := parseJWT(rotated, oldToken, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(42), claims.UserID)
+	_, err = parseJWT(rotated, newToken, time.Now())
+	require.NoError(t, err)
+
+	// старый экземпляр не знает k2
+	_, err = parseJWT(old, newToken, time.Now())
+	require.Error(t, err)
+
+	// токены без kid, выпущенные до появления кольца, проверяются ключом по умолчанию
+	legacyToken := signTestToken(t, NewHMACKeyRing("fake_secret"))
+	parsed, _, err := jwt.NewParser().ParseUnverified(legacyToken, &Claims{})
+	require.NoError(t, err)
+	delete(parsed.Header, "kid")
+	legacyToken, err = parsed.SignedString([]byte("fake_secret"))
+	require.NoError(t, err)
+	_, err = parseJWT(rotated, legacyToken, time.Now())
+	require.NoError(t, err)
+
+	withoutDefault, err := LoadKeyRing("k2:EdDSA:"+edPath, "k2", "")
+	require.NoError(t, err)
+	_, err = parseJWT(withoutDefault, legacyToken, time.Now())
+	require.Error(t, err)
+}
+
+func TestKeyRingRejectsAlgorithmMismatch(t *testing.T) {
+	rsaPath, _, _, _ := writeTestKeys(t)
+
+	ring, err := LoadKeyRing("k1:RS256:"+rsaPath, "k1", "")
+	require.NoError(t, err)
+
+	// HS256 токен с kid RSA ключа не должен проверяться публичным ключом как секретом
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+		SessionID:        "sid",
+	})
+	token.Header["kid"] = "k1"
+	tokenString, err := token.SignedString([]byte("anything"))
+	require.NoError(t, err)
+	_, err = parseJWT(ring, tokenString, time.Now())
+	require.Error(t, err)
+}
+
+func TestLoadKeyRingErrors(t *testing.T) {
+	rsaPath, _, edPublicPath, _ := writeTestKeys(t)
+
+	_, err := LoadKeyRing("", "k1", "")
+	require.Error(t, err)
+	_, err = LoadKeyRing("k1:RS256:"+rsaPath, "k2", "")
+	require.Error(t, err)
+	_, err = LoadKeyRing("k1:ES256:"+rsaPath, "k1", "")
+	require.Error(t, err)
+	_, err = LoadKeyRing("k1:RS256", "k1", "")
+	require.Error(t, err)
+	_, err = LoadKeyRing("k1:RS256:"+rsaPath+",k1:RS256:"+rsaPath, "k1", "")
+	require.Error(t, err)
+
+	// публичным ключом можно только проверять
+	_, err = LoadKeyRing("k1:RS256:"+rsaPath+",k2:EdDSA:"+edPublicPath, "k2", "")
+	require.Error(t, err)
+	_, err = LoadKeyRing("k1:RS256:"+rsaPath+",k2:EdDSA:"+edPublicPath, "k1", "")
+	require.NoError(t, err)
+}
+
+func TestKeyRingJWKS(t *testing.T) {
+	rsaPath, _, edPublicPath, secretPath := writeTestKeys(t)
+
+	ring, err := LoadKeyRing("legacy:HS256:"+secretPath+",k1:RS256:"+rsaPath+",k2:EdDSA:"+edPublicPath, "k1", "legacy")
+	require.NoError(t, err)
+
+	set := ring.JWKS()
+	require.Len(t, set.Keys, 2)
+	require.Equal(t, "k1", set.Keys[0].Kid)
+	require.Equal(t, "RSA", set.Keys[0].Kty)
+	require.Equal(t, "RS256", set.Keys[0].Alg)
+	require.Equal(t, "AQAB", set.Keys[0].E)
+	require.NotEmpty(t, set.Keys[0].N)
+	require.Equal(t, "k2", set.Keys[1].Kid)
+	require.Equal(t, "OKP", set.Keys[1].Kty)
+	require.Equal(t, "Ed25519", set.Keys[1].Crv)
+	require.NotEmpty(t, set.Keys[1].X)
+
+	require.Empty(t, NewHMACKeyRing("fake_secret").JWKS().Keys)
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+// LoginThrottleConfig - защита входа от перебора. После FreeAttempts неудачных попыток ключ блокируется
+// на BaseLockout, каждая следующая ошибка удваивает блокировку до MaxLockout.
+type LoginThrottleConfig struct {
+	// LoginFreeAttempts - неудачные попытки для одного логина до блокировки, 0 - без ограничения
+	LoginFreeAttempts int
+	// IPFreeAttempts - неудачные попытки с одного IP до блокировки, 0 - без ограничения
+	IPFreeAttempts int
+	BaseLockout    time.Duration
+	MaxLockout     time.Duration
+	// Window - если столько времени не было ошибок, счётчик начинается заново
+	Window time.Duration
+}
+
+type loginThrottle struct {
+	repo ports.LoginThrottleRepository
+	cfg  LoginThrottleConfig
+}
+
+func newLoginThrottle(repo ports.LoginThrottleRepository, cfg LoginThrottleConfig) *loginThrottle {
+	return &loginThrottle{repo: repo, cfg: cfg}
+}
+
+func (t *loginThrottle) keys(login, clientIP string) []domain.ThrottleKey {
+	var keys []domain.ThrottleKey
+	if t.cfg.LoginFreeAttempts > 0 {
+		keys = append(keys, domain.ThrottleKey{Scope: domain.ThrottleScopeLogin, Subject: login})
+	}
+	if t.cfg.IPFreeAttempts > 0 && clientIP != "" {
+		keys = append(keys, domain.ThrottleKey{Scope: domain.ThrottleScopeIP, Subject: clientIP})
+	}
+	return keys
+}
+
+func (t *loginThrottle) free(key domain.ThrottleKey) int {
+	if key.Scope == domain.ThrottleScopeIP {
+		return t.cfg.IPFreeAttempts
+	}
+	return t.cfg.LoginFreeAttempts
+}
+
+// loginAttempt - попытка, засчитанная до проверки пароля или кода.
+// locking[i] - попытка сама заблокировала keys[i], блокировка останется, если она окажется неудачной.
+type loginAttempt struct {
+	keys    []domain.ThrottleKey
+	locking []bool
+}
+
+// begin засчитывает попытку по логину и IP до проверки пароля или кода. Параллельные запросы
+// не проходят мимо блокировки: следующая попытка сверх бесплатных блокирует ключ сразу, а не после ошибки.
+// Если ключ заблокирован, возвращает LoginLockedError, уже засчитанное по другим ключам прощается.
+func (t *loginThrottle) begin(ctx context.Context, login, clientIP string) (*loginAttempt, error) {
+	attempt := &loginAttempt{}
+	for _, key := range t.keys(login, clientIP) {
+		free := t.free(key)
+		failures, lockedFor, err := t.repo.Attempt(ctx, key, t.cfg.Window, func(failures int) time.Duration {
+			return t.lockout(failures, free)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("login throttle, begin: %w", err)
+		}
+		if lockedFor > 0 {
+			if err = t.forgive(ctx, attempt); err != nil {
+				return nil, fmt.Errorf("login throttle, begin: %w", err)
+			}
+			return nil, &ports.LoginLockedError{RetryAfter: lockedFor}
+		}
+		attempt.keys = append(attempt.keys, key)
+		attempt.locking = append(attempt.locking, t.lockout(failures, free) > 0)
+	}
+	return attempt, nil
+}
+
+// forgive отменяет попытку, оказавшуюся верной: ошибкой она не была
+func (t *loginThrottle) forgive(ctx context.Context, attempt *loginAttempt) error {
+	for i, key := range attempt.keys {
+		if err := t.repo.Forgive(ctx, key, attempt.locking[i]); err != nil {
+			return fmt.Errorf("login throttle, forgive: %w", err)
+		}
+	}
+	return nil
+}
+
+// reset вызывается после успешного входа. Счётчик IP не сбрасывается: с одного адреса
+// можно подбирать пароли к многим логинам, зная пароль от своего.
+func (t *loginThrottle) reset(ctx context.Context, login string) error {
+	if t.cfg.LoginFreeAttempts == 0 {
+		return nil
+	}
+	err := t.repo.Reset(ctx, domain.ThrottleKey{Scope: domain.ThrottleScopeLogin, Subject: login})
+	if err != nil {
+		return fmt.Errorf("login throttle, reset: %w", err)
+	}
+	return nil
+}
+
+// unlock снимает блокировку логина независимо от настроек, её могли выставить с другими настройками
+func (t *loginThrottle) unlock(ctx context.Context, login string) error {
+	err := t.repo.Reset(ctx, domain.ThrottleKey{Scope: domain.ThrottleScopeLogin, Subject: login})
+	if err != nil {
+		return fmt.Errorf("login throttle, unlock: %w", err)
+	}
+	return nil
+}
+
+func (t *loginThrottle) lockout(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	lockout := t.cfg.BaseLockout
+	for i := free + 1; i < failures && lockout < t.cfg.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > t.cfg.MaxLockout {
+		lockout = t.cfg.MaxLockout
+	}
+	return lockout
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// fakeLoginThrottleRepository - счётчики в памяти, повторяет поведение postgres.LoginThrottleRepository
+type fakeLoginThrottleRepository struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	attempts map[domain.ThrottleKey]*fakeAttempts
+}
+
+var _ ports.LoginThrottleRepository = (*fakeLoginThrottleRepository)(nil)
+
+func newFakeLoginThrottleRepository(now func() time.Time) *fakeLoginThrottleRepository {
+	return &fakeLoginThrottleRepository{now: now, attempts: make(map[domain.ThrottleKey]*fakeAttempts)}
+}
+
+func (repo *fakeLoginThrottleRepository) Attempt(ctx context.Context, key domain.ThrottleKey, window time.Duration, lockout func(failures int) time.Duration) (int, time.Duration, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	now := repo.now()
+	a, ok := repo.attempts[key]
+	if !ok {
+		a = &fakeAttempts{}
+		repo.attempts[key] = a
+	}
+	if a.lockedUntil.After(now) {
+		return 0, a.lockedUntil.Sub(now), nil
+	}
+	if a.lastFailure.Before(now.Add(-window)) {
+		a.failures = 0
+	}
+	a.failures++
+	a.lastFailure = now
+	if duration := lockout(a.failures); duration > 0 {
+		a.lockedUntil = now.Add(duration)
+	}
+	return a.failures, 0, nil
+}
+
+func (repo *fakeLoginThrottleRepository) Forgive(ctx context.Context, key domain.ThrottleKey, unlock bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if a, ok := repo.attempts[key]; ok {
+		a.failures = max(a.failures-1, 0)
+		if unlock {
+			a.lockedUntil = time.Time{}
+		}
+	}
+	return nil
+}
+
+func (repo *fakeLoginThrottleRepository) Reset(ctx context.Context, key domain.ThrottleKey) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	delete(repo.attempts, key)
+	return nil
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newLoginThrottleTestService(t *testing.T, clock *fakeClock) *AuthService {
+	sessions := newFakeSessionRepository()
+	service, err := NewAuthService(newFakeAuthRepository(sessions), sessions,
+		newFakeRevocationRepository(sessions), newFakeLoginThrottleRepository(clock.Now), newFakeSecondFactorRepository(), AuthConfig{
+			MinPasswordLength: 8,
+			BcryptCost:        bcrypt.MinCost,
+			JWTSecretKey:      "fake_secret",
+			AccessTokenTTL:    15 * time.Minute,
+			RefreshTokenTTL:   time.Hour,
+			LoginThrottle: LoginThrottleConfig{
+				LoginFreeAttempts: 3,
+				IPFreeAttempts:    5,
+				BaseLockout:       time.Second,
+				MaxLockout:        4 * time.Second,
+				Window:            time.Hour,
+			},
+		})
+	require.NoError(t, err)
+	service.now = clock.Now
+	return service
+}
+
+func TestLoginLockout(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	service := newLoginThrottleTestService(t, clock)
+	ctx := context.Background()
+
+	tokens, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	principal, err := service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		_, err = service.Login(ctx, "svirex", "wrong-password", "10.0.0.1")
+		require.ErrorIs(t, err, ports.ErrInvalidPassword)
+	}
+
+	// при блокировке не помогает и верный пароль
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.2")
+	var lockedErr *ports.LoginLockedError
+	require.ErrorAs(t, err, &lockedErr)
+	require.ErrorIs(t, err, ports.ErrTooManyLoginAttempts)
+	require.Equal(t, time.Second, lockedErr.RetryAfter)
+
+	clock.Advance(2 * time.Second)
+	_, err = service.Login(ctx, "svirex", "wrong-password", "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrInvalidPassword)
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.ErrorAs(t, err, &lockedErr)
+	require.Equal(t, 2*time.Second, lockedErr.RetryAfter)
+
+	require.ErrorIs(t, service.UnlockLogin(ctx, principal.UID+1), ports.ErrUserNotFound)
+	require.NoError(t, service.UnlockLogin(ctx, principal.UID))
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.2")
+	require.NoError(t, err)
+
+	// успешный вход сбросил счётчик логина
+	for i := 0; i < 3; i++ {
+		_, err = service.Login(ctx, "svirex", "wrong-password", "10.0.0.2")
+		require.ErrorIs(t, err, ports.ErrInvalidPassword)
+	}
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.2")
+	require.NoError(t, err)
+}
+
+func TestLoginLockoutByIP(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	service := newLoginThrottleTestService(t, clock)
+	ctx := context.Background()
+
+	_, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+
+	// перебор разных логинов с одного адреса, по каждому логину попыток меньше порога
+	for _, login := range []string{"a1", "a2", "a3", "a4", "a5", "a6"} {
+		_, err = service.Login(ctx, login, "wrong-password", "10.0.0.1")
+		require.ErrorIs(t, err, ports.ErrUserNotFound)
+	}
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrTooManyLoginAttempts)
+
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.2")
+	require.NoError(t, err)
+}
+
+func TestLoginLockoutConcurrent(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	service := newLoginThrottleTestService(t, clock)
+	ctx := context.Background()
+
+	_, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+
+	// попытка засчитывается до проверки пароля, так что параллельный перебор не обгоняет блокировку:
+	// проверяются только бесплатные попытки и одна, выставившая блокировку
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	verified := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.Login(ctx, "svirex", "wrong-password", "10.0.0.1")
+			if errors.Is(err, ports.ErrInvalidPassword) {
+				mu.Lock()
+				verified++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 4, verified)
+}
+
+func TestLoginLockoutDuration(t *testing.T) {
+	throttle := newLoginThrottle(nil, LoginThrottleConfig{BaseLockout: time.Second, MaxLockout: 4 * time.Second})
+
+	require.Equal(t, time.Duration(0), throttle.lockout(3, 3))
+	require.Equal(t, time.Second, throttle.lockout(4, 3))
+	require.Equal(t, 2*time.Second, throttle.lockout(5, 3))
+	require.Equal(t, 4*time.Second, throttle.lockout(6, 3))
+	require.Equal(t, 4*time.Second, throttle.lockout(100, 3))
+}
+
+func TestNewAuthServiceInvalidLoginThrottle(t *testing.T) {
+	_, err := NewAuthService(nil, nil, nil, nil, nil, AuthConfig{
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+		LoginThrottle:   LoginThrottleConfig{LoginFreeAttempts: 3},
+	})
+	require.Error(t, err)
+}
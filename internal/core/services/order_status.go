@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+// maxStatusAttempts - сколько раз перепроверять переход, если статус заказа меняют параллельно
+const maxStatusAttempts = 3
+
+// orderTransitions - допустимые переходы статуса заказа, PROCESSED и INVALID конечные.
+// Из FAILED_CHECK заказ возвращает в очередь администратор, а запоздавший callback может сразу дать конечный статус.
+var orderTransitions = map[domain.Status][]domain.Status{
+	domain.New:         {domain.Processing, domain.Invalid, domain.Processed, domain.FailedCheck},
+	domain.Processing:  {domain.Invalid, domain.Processed, domain.FailedCheck},
+	domain.FailedCheck: {domain.New, domain.Invalid, domain.Processed},
+}
+
+// repeatableStatuses система расчёта может прислать повторно, повтор ничего не меняет
+var repeatableStatuses = []domain.Status{domain.Processing, domain.Invalid, domain.Processed}
+
+var errStatusUnchanged = errors.New("order status unchanged")
+
+// checkTransition - единственное место, где решается, можно ли перевести заказ из from в to
+func checkTransition(from, to domain.Status) error {
+	if from == to && slices.Contains(repeatableStatuses, to) {
+		return errStatusUnchanged
+	}
+	if !slices.Contains(orderTransitions[from], to) {
+		return fmt.Errorf("%w: %s -> %s", ports.ErrIllegalTransition, from, to)
+	}
+	return nil
+}
+
+// changeStatus проверяет переход по текущему статусу заказа и применяет его через write.
+// Если статус сменился между проверкой и записью, переход проверяется заново.
+// Повтор текущего статуса не пишется и ошибкой не считается.
+func changeStatus(ctx context.Context, repo ports.AccrualRepository, transition domain.StatusTransition,
+	write func(ctx context.Context, transition *domain.StatusTransition) error) error {
+	for attempt := 1; ; attempt++ {
+		from, err := repo.GetOrderStatus(ctx, transition.OrderNum)
+		if err != nil {
+			return fmt.Errorf("change status: %w", err)
+		}
+		err = checkTransition(from, transition.To)
+		if errors.Is(err, errStatusUnchanged) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("change status, order %s: %w", transition.OrderNum, err)
+		}
+		transition.From = from
+		err = write(ctx, &transition)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ports.ErrStatusChanged) || attempt == maxStatusAttempts {
+			return fmt.Errorf("change status: %w", err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/adapters/accrual"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTransition(t *testing.T) {
+	cases := []struct {
+		from, to domain.Status
+		err      error
+	}{
+		{from: domain.New, to: domain.Processing},
+		{from: domain.New, to: domain.Processed},
+		{from: domain.Processing, to: domain.Invalid},
+		{from: domain.Processing, to: domain.FailedCheck},
+		{from: domain.FailedCheck, to: domain.New},
+		{from: domain.FailedCheck, to: domain.Processed},
+		{from: domain.Processing, to: domain.Processing, err: errStatusUnchanged},
+		{from: domain.Processed, to: domain.Processed, err: errStatusUnchanged},
+		{from: domain.Processed, to: domain.Processing, err: ports.ErrIllegalTransition},
+		{from: domain.Invalid, to: domain.Processed, err: ports.ErrIllegalTransition},
+		{from: domain.Processing, to: domain.New, err: ports.ErrIllegalTransition},
+		{from: domain.New, to: domain.New, err: ports.ErrIllegalTransition},
+		{from: domain.FailedCheck, to: domain.Processing, err: ports.ErrIllegalTransition},
+	}
+	for _, c := range cases {
+		t.Run(string(c.from)+"->"+string(c.to), func(t *testing.T) {
+			err := checkTransition(c.from, c.to)
+			if c.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, c.err)
+			}
+		})
+	}
+}
+
+func TestChangeStatusRechecksConcurrentChange(t *testing.T) {
+	repo := newFakeAccrualRepository("18")
+	writes := 0
+	err := changeStatus(context.Background(), repo, domain.StatusTransition{OrderNum: "18", To: domain.Processing},
+		func(ctx context.Context, transition *domain.StatusTransition) error {
+			writes++
+			// callback успел раньше опроса
+			repo.statuses["18"] = domain.Processed
+			return ports.ErrStatusChanged
+		})
+	require.ErrorIs(t, err, ports.ErrIllegalTransition)
+	require.Equal(t, 1, writes)
+
+	err = changeStatus(context.Background(), repo, domain.StatusTransition{OrderNum: "67", To: domain.Processing}, repo.WriteProcessing)
+	require.ErrorIs(t, err, ports.ErrOrderNotFound)
+}
+
+func TestCheckAccrualServiceRejectsIllegalTransition(t *testing.T) {
+	repo := newFakeAccrualRepository("67")
+	service := newTestCheckAccrualService(t, repo, accrual.NewFakeClient(), RetryPolicy{BaseDelay: time.Millisecond})
+	ctx := context.Background()
+
+	raw := []byte(`{"order":"67","status":"PROCESSED","accrual":500}`)
+	err := service.Apply(ctx, &domain.AccrualResponse{OrderNum: "67", Status: domain.AccrualProcessed, Accrual: 50000, Raw: raw})
+	require.NoError(t, err)
+	// повтор конечного статуса не ошибка и в историю не пишется
+	err = service.Apply(ctx, &domain.AccrualResponse{OrderNum: "67", Status: domain.AccrualProcessed, Accrual: 50000})
+	require.NoError(t, err)
+	err = service.Apply(ctx, &domain.AccrualResponse{OrderNum: "67", Status: domain.AccrualProcessing})
+	require.ErrorIs(t, err, ports.ErrIllegalTransition)
+	require.Equal(t, domain.Processed, repo.status("67"))
+
+	require.Len(t, repo.history, 1)
+	require.Equal(t, domain.New, repo.history[0].From)
+	require.Equal(t, domain.SourceWebhook, repo.history[0].Source)
+	require.JSONEq(t, string(raw), string(repo.history[0].RawResponse))
+
+	err = service.Requeue(ctx, "67")
+	require.ErrorIs(t, err, ports.ErrIllegalTransition)
+
+	repo.statuses["67"] = domain.FailedCheck
+	require.NoError(t, service.Requeue(ctx, "67"))
+	require.Equal(t, domain.New, repo.status("67"))
+	require.Equal(t, domain.SourceAdmin, repo.history[1].Source)
+	require.True(t, repo.hasJob("67"))
+}
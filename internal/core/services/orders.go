@@ -4,22 +4,23 @@ import (
 	"context"
 	"fmt"
 	"strconv"
-	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/common"
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// maxBatchOrders - сколько номеров можно загрузить одним запросом
+const maxBatchOrders = 1000
+
 type OrderService struct {
 	repo                ports.OrdersRepository
 	checkAccrualService *CheckAccrualService
 	logger              common.Logger
 }
 
-func NewOrderService(dbpool *pgxpool.Pool, repo ports.OrdersRepository, logger common.Logger, queueSize int, accrualAddr string, pauseBetweenRequests time.Duration, maxRunnedGenerators int32, dbLoaderPause time.Duration) (*OrderService, error) {
-	cas, err := NewCheckAccrualService(dbpool, logger, queueSize, accrualAddr, pauseBetweenRequests, maxRunnedGenerators, dbLoaderPause)
+func NewOrderService(repo ports.OrdersRepository, accrualRepo ports.AccrualRepository, accrualClient ports.AccrualClient, logger common.Logger, accrualCfg CheckAccrualConfig) (*OrderService, error) {
+	cas, err := NewCheckAccrualService(accrualRepo, accrualClient, logger, accrualCfg)
 	if err != nil {
 		logger.Errorf("new order service, create check accrual service: %v", err)
 		return nil, fmt.Errorf("new order service, create check accrual service: %w", err)
@@ -43,25 +44,108 @@ func (service *OrderService) CreateOrder(ctx context.Context, uid int64, orderNu
 	if !ok {
 		return ports.Err, ports.ErrInvalidOrderNum
 	}
-	userOrder, err := service.repo.CreateOrder(ctx, uid, orderNum)
+	userOrder, err := service.repo.CreateOrder(ctx, uid, orderNum, service.checkAccrualService.FirstCheckDelay())
 	if err != nil {
 		service.logger.Errorf("order service, create order, repo answer: %v", err)
 		return ports.Err, fmt.Errorf("order service, create order, repo answer: %w", err)
 	}
 	if userOrder.New {
 		service.logger.Debugln("SERVICE CREATE ORDER WITH NUM", orderNum)
-		service.checkAccrualService.Process(orderNum)
+		service.checkAccrualService.Schedule(orderNum)
 		return ports.Ok, nil
 	} else {
-		if userOrder.ID == uid {
+		if checkOrderOwner(userOrder.ID, uid) == nil {
 			return ports.AlreadyAdded, nil
 		}
 		return ports.NotOwnOrder, nil
 	}
 }
 
-func (service *OrderService) GetOrders(ctx context.Context, uid int64) ([]domain.Order, error) {
-	return service.repo.GetOrders(ctx, uid)
+func (service *OrderService) CreateOrders(ctx context.Context, uid int64, orderNums []string) ([]ports.Status, error) {
+	if len(orderNums) > maxBatchOrders {
+		return nil, fmt.Errorf("%w: order service, create orders, %d numbers", ports.ErrOrdersBatchTooLarge, len(orderNums))
+	}
+	statuses := make([]ports.Status, len(orderNums))
+	valid := make([]string, 0, len(orderNums))
+	for i, orderNum := range orderNums {
+		if ok, _ := checkLuhn(orderNum); !ok {
+			statuses[i] = ports.InvalidNumber
+			continue
+		}
+		valid = append(valid, orderNum)
+	}
+	if len(valid) == 0 {
+		return statuses, nil
+	}
+	userOrders, err := service.repo.CreateOrders(ctx, uid, valid, service.checkAccrualService.FirstCheckDelay())
+	if err != nil {
+		service.logger.Errorf("order service, create orders, repo answer: %v", err)
+		return nil, fmt.Errorf("order service, create orders, repo answer: %w", err)
+	}
+	created := make([]string, 0, len(userOrders))
+	seen := make(map[string]bool, len(valid))
+	for i, orderNum := range orderNums {
+		if statuses[i] == ports.InvalidNumber {
+			continue
+		}
+		userOrder := userOrders[orderNum]
+		switch {
+		case userOrder.New && !seen[orderNum]:
+			statuses[i] = ports.Ok
+			created = append(created, orderNum)
+		case checkOrderOwner(userOrder.ID, uid) == nil:
+			statuses[i] = ports.AlreadyAdded
+		default:
+			statuses[i] = ports.NotOwnOrder
+		}
+		seen[orderNum] = true
+	}
+	service.checkAccrualService.Schedule(created...)
+	return statuses, nil
+}
+
+func (service *OrderService) GetOrder(ctx context.Context, uid int64, orderNum string) (*domain.Order, error) {
+	order, err := service.repo.GetOrder(ctx, orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("order service, get order: %w", err)
+	}
+	if err = checkOrderOwner(order.UID, uid); err != nil {
+		return nil, fmt.Errorf("order service, get order %s: %w", orderNum, err)
+	}
+	return order, nil
+}
+
+// checkOrderOwner - номер заказа уникален среди всех пользователей, чужой заказ нельзя ни загрузить повторно, ни посмотреть
+func checkOrderOwner(ownerUID, uid int64) error {
+	if ownerUID != uid {
+		return ports.ErrNotOwnOrder
+	}
+	return nil
+}
+
+func (service *OrderService) GetOrders(ctx context.Context, uid int64, query domain.OrdersQuery) (*domain.OrdersPage, error) {
+	query.Limit = pageFetchLimit(query.Limit)
+	orders, err := service.repo.GetOrders(ctx, uid, query)
+	if err != nil {
+		return nil, fmt.Errorf("order service, get orders: %w", err)
+	}
+	page := &domain.OrdersPage{}
+	page.Orders, page.Next = cutPage(orders, query.Limit, func(order domain.Order) domain.Cursor {
+		return domain.Cursor{At: order.UploadedAt, ID: order.ID}
+	})
+	return page, nil
+}
+
+func (service *OrderService) GetFailedOrders(ctx context.Context) ([]domain.Order, error) {
+	return service.checkAccrualService.GetFailedOrders(ctx)
+}
+
+func (service *OrderService) RequeueOrder(ctx context.Context, orderNum string) error {
+	return service.checkAccrualService.Requeue(ctx, orderNum)
+}
+
+func (service *OrderService) ApplyAccrual(ctx context.Context, ar *domain.AccrualResponse) error {
+	return service.checkAccrualService.Apply(ctx, ar)
 }
 
 func (service *OrderService) Shutdown() {
@@ -69,6 +153,10 @@ func (service *OrderService) Shutdown() {
 }
 
 func checkLuhn(orderNum string) (bool, error) {
+	// у пустой строки сумма 0, без этой проверки она прошла бы как верный номер
+	if orderNum == "" {
+		return false, fmt.Errorf("checkLunh, empty number")
+	}
 	sum := 0
 	digitsQnt := len(orderNum)
 	parity := digitsQnt % 2
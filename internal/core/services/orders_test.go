@@ -1,9 +1,15 @@
 package services
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/Svirex/gofermart-loyality/internal/adapters/accrual"
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestCheckLunh(t *testing.T) {
@@ -37,6 +43,11 @@ func TestCheckLunh(t *testing.T) {
 			ok:     false,
 			err:    true,
 		},
+		{
+			number: "",
+			ok:     false,
+			err:    true,
+		},
 	}
 	for i := range cases {
 		c := &cases[i]
@@ -51,3 +62,136 @@ func TestCheckLunh(t *testing.T) {
 		})
 	}
 }
+
+// fakeOrdersRepository отдаёт заранее отсортированные заказы с учётом только Limit
+type fakeOrdersRepository struct {
+	orders     []domain.Order
+	query      domain.OrdersQuery
+	batches    [][]string
+	checkDelay time.Duration
+}
+
+func (repo *fakeOrdersRepository) CreateOrder(ctx context.Context, uid int64, orderNum string, checkDelay time.Duration) (*ports.UserOrder, error) {
+	return nil, nil
+}
+
+func (repo *fakeOrdersRepository) GetOrders(ctx context.Context, uid int64, query domain.OrdersQuery) ([]domain.Order, error) {
+	repo.query = query
+	if query.Limit > 0 && query.Limit < len(repo.orders) {
+		return repo.orders[:query.Limit], nil
+	}
+	return repo.orders, nil
+}
+
+// CreateOrders заводит заказы, которых ещё нет в repo.orders, на uid, результат по каждому различному номеру
+func (repo *fakeOrdersRepository) CreateOrders(ctx context.Context, uid int64, orderNums []string, checkDelay time.Duration) (map[string]ports.UserOrder, error) {
+	repo.batches = append(repo.batches, orderNums)
+	repo.checkDelay = checkDelay
+	userOrders := make(map[string]ports.UserOrder)
+	for _, orderNum := range orderNums {
+		if _, ok := userOrders[orderNum]; ok {
+			continue
+		}
+		if order, err := repo.GetOrder(ctx, orderNum); err == nil {
+			userOrders[orderNum] = ports.UserOrder{ID: order.UID}
+			continue
+		}
+		repo.orders = append(repo.orders, domain.Order{Number: orderNum, UID: uid})
+		userOrders[orderNum] = ports.UserOrder{ID: uid, New: true}
+	}
+	return userOrders, nil
+}
+
+func (repo *fakeOrdersRepository) GetOrder(ctx context.Context, orderNum string) (*domain.Order, error) {
+	for i := range repo.orders {
+		if repo.orders[i].Number == orderNum {
+			return &repo.orders[i], nil
+		}
+	}
+	return nil, ports.ErrOrderNotFound
+}
+
+func TestGetOrdersPage(t *testing.T) {
+	now := time.Now()
+	repo := &fakeOrdersRepository{orders: []domain.Order{
+		{ID: 3, Number: "18", UploadedAt: now},
+		{ID: 2, Number: "26", UploadedAt: now.Add(-time.Second)},
+		{ID: 1, Number: "67", UploadedAt: now.Add(-2 * time.Second)},
+	}}
+	service := &OrderService{repo: repo}
+
+	page, err := service.GetOrders(context.Background(), 1, domain.OrdersQuery{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Orders, 2)
+	require.Equal(t, &domain.Cursor{At: now.Add(-time.Second), ID: 2}, page.Next)
+
+	page, err = service.GetOrders(context.Background(), 1, domain.OrdersQuery{Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, page.Orders, 3)
+	require.Nil(t, page.Next)
+
+	// без limit список целиком, как до появления страниц
+	page, err = service.GetOrders(context.Background(), 1, domain.OrdersQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Orders, 3)
+	require.Nil(t, page.Next)
+	require.Equal(t, 0, repo.query.Limit)
+
+	_, err = service.GetOrders(context.Background(), 1, domain.OrdersQuery{Limit: 100_000})
+	require.NoError(t, err)
+	require.Equal(t, maxPageLimit+1, repo.query.Limit)
+}
+
+func TestGetOrder(t *testing.T) {
+	repo := &fakeOrdersRepository{orders: []domain.Order{
+		{Number: "18", UID: 1, Status: domain.Processed, History: []domain.OrderStatusChange{
+			{Status: domain.New},
+			{Status: domain.Processing},
+			{Status: domain.Processed, Accrual: 500},
+		}},
+		{Number: "26", UID: 2},
+	}}
+	service := &OrderService{repo: repo}
+
+	order, err := service.GetOrder(context.Background(), 1, "18")
+	require.NoError(t, err)
+	require.Len(t, order.History, 3)
+
+	_, err = service.GetOrder(context.Background(), 1, "26")
+	require.ErrorIs(t, err, ports.ErrNotOwnOrder)
+	_, err = service.GetOrder(context.Background(), 1, "67")
+	require.ErrorIs(t, err, ports.ErrOrderNotFound)
+}
+
+func TestCreateOrders(t *testing.T) {
+	repo := &fakeOrdersRepository{orders: []domain.Order{
+		{Number: "18", UID: 1},
+		{Number: "26", UID: 2},
+	}}
+	accrualRepo := newFakeAccrualRepository("67")
+	cas := newTestCheckAccrualServiceWindow(t, accrualRepo, accrual.NewFakeClient(), RetryPolicy{}, time.Hour)
+	service := &OrderService{repo: repo, checkAccrualService: cas, logger: zap.NewNop().Sugar()}
+
+	statuses, err := service.CreateOrders(context.Background(), 1, []string{"67", "18", "26", "12", "67", "йо"})
+	require.NoError(t, err)
+	require.Equal(t, []ports.Status{
+		ports.Ok,
+		ports.AlreadyAdded,
+		ports.NotOwnOrder,
+		ports.InvalidNumber,
+		ports.AlreadyAdded,
+		ports.InvalidNumber,
+	}, statuses)
+	// все проверенные номера уходят в repo одним вызовом
+	require.Equal(t, [][]string{{"67", "18", "26", "67"}}, repo.batches)
+	// задача нового заказа заводится уже отложенной до callback
+	require.Equal(t, time.Hour, repo.checkDelay)
+
+	statuses, err = service.CreateOrders(context.Background(), 1, []string{"12", ""})
+	require.NoError(t, err)
+	require.Equal(t, []ports.Status{ports.InvalidNumber, ports.InvalidNumber}, statuses)
+	require.Len(t, repo.batches, 1)
+
+	_, err = service.CreateOrders(context.Background(), 1, make([]string, maxBatchOrders+1))
+	require.ErrorIs(t, err, ports.ErrOrdersBatchTooLarge)
+}
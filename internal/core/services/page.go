@@ -0,0 +1,25 @@
+package services
+
+import "github.com/Svirex/gofermart-loyality/internal/core/domain"
+
+// maxPageLimit - наибольший размер страницы в списках с курсором
+const maxPageLimit = 1000
+
+// pageFetchLimit - сколько записей запросить у репозитория: на одну больше страницы,
+// лишняя запись показывает, что за страницей есть ещё. 0 - без ограничения.
+func pageFetchLimit(limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	return min(limit, maxPageLimit) + 1
+}
+
+// cutPage отрезает лишнюю запись и возвращает курсор последней записи страницы, nil - страница последняя
+func cutPage[T any](items []T, fetchLimit int, cursor func(T) domain.Cursor) ([]T, *domain.Cursor) {
+	if fetchLimit <= 0 || len(items) < fetchLimit {
+		return items, nil
+	}
+	items = items[:fetchLimit-1]
+	next := cursor(items[len(items)-1])
+	return items, &next
+}
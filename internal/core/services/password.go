@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+// bcrypt учитывает только первые 72 байта пароля
+const maxPasswordBytes = 72
+
+// последовательности, которые почти не добавляют стойкости паролю
+var passwordSequences = []string{
+	"0123456789",
+	"abcdefghijklmnopqrstuvwxyz",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+type passwordPolicy struct {
+	minLength      int
+	minEntropyBits float64
+	blocklist      map[string]struct{}
+}
+
+func newPasswordPolicy(minLength int, minEntropyBits float64, blocklist []string) *passwordPolicy {
+	policy := &passwordPolicy{
+		minLength:      minLength,
+		minEntropyBits: minEntropyBits,
+		blocklist:      make(map[string]struct{}, len(blocklist)),
+	}
+	for _, password := range blocklist {
+		policy.blocklist[strings.ToLower(password)] = struct{}{}
+	}
+	return policy
+}
+
+// LoadPasswordBlocklist читает файл с утёкшими и популярными паролями, по одному в строке.
+// Пустые строки и строки, начинающиеся с #, пропускаются.
+func LoadPasswordBlocklist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("load password blocklist: %w", err)
+	}
+	defer file.Close()
+	var passwords []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords = append(passwords, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("load password blocklist, read: %w", err)
+	}
+	return passwords, nil
+}
+
+// validate проверяет все правила сразу, чтобы клиент мог показать все причины отказа
+func (p *passwordPolicy) validate(login, password string) error {
+	var rejections []domain.PasswordRejection
+	reject := func(code domain.PasswordRejectCode, format string, args ...interface{}) {
+		rejections = append(rejections, domain.PasswordRejection{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	if len([]rune(password)) < p.minLength {
+		reject(domain.PasswordTooShort, "password must be at least %d characters long", p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		reject(domain.PasswordTooLong, "password must be at most %d bytes long", maxPasswordBytes)
+	}
+	if p.minEntropyBits > 0 && passwordEntropy(password) < p.minEntropyBits {
+		reject(domain.PasswordLowEntropy, "password is too predictable, make it longer or mix character types")
+	}
+	if p.isBlocked(password) {
+		reject(domain.PasswordCommon, "password is too common or was found in a data breach")
+	}
+	if similarToLogin(login, password) {
+		reject(domain.PasswordSimilarToLogin, "password must not be similar to the login")
+	}
+	if len(rejections) > 0 {
+		return &ports.PasswordPolicyError{Rejections: rejections}
+	}
+	return nil
+}
+
+// isBlocked сравнивает без учёта регистра, в том числе без цифр и символов в конце: Password123! = password
+func (p *passwordPolicy) isBlocked(password string) bool {
+	if len(p.blocklist) == 0 {
+		return false
+	}
+	lower := strings.ToLower(password)
+	if _, ok := p.blocklist[lower]; ok {
+		return true
+	}
+	base := strings.TrimRightFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	_, ok := p.blocklist[base]
+	return ok && base != ""
+}
+
+// passwordEntropy оценивает стойкость как log2(алфавит) * длина, где повторы больше двух символов
+// подряд и отрезки клавиатурных и алфавитных последовательностей длиннее двух не учитываются
+func passwordEntropy(password string) float64 {
+	var lower, upper, digit, symbol bool
+	other := make(map[rune]struct{})
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && (unicode.IsPunct(r) || unicode.IsSymbol(r) || r == ' '):
+			symbol = true
+		default:
+			other[r] = struct{}{}
+		}
+	}
+	base := len(other)
+	if lower {
+		base += 26
+	}
+	if upper {
+		base += 26
+	}
+	if digit {
+		base += 10
+	}
+	if symbol {
+		base += 33
+	}
+	if base < 2 {
+		return 0
+	}
+	return math.Log2(float64(base)) * float64(effectiveLength(password))
+}
+
+func effectiveLength(password string) int {
+	runes := []rune(strings.ToLower(password))
+	length := 0
+	for i := range runes {
+		if i >= 2 && runes[i] == runes[i-1] && runes[i] == runes[i-2] {
+			continue
+		}
+		if i >= 2 && inSequence(runes[i-2], runes[i-1], runes[i]) {
+			continue
+		}
+		length++
+	}
+	return length
+}
+
+func inSequence(a, b, c rune) bool {
+	for _, seq := range passwordSequences {
+		for _, s := range []string{seq, reverse(seq)} {
+			if strings.Contains(s, string([]rune{a, b, c})) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// similarToLogin - пароль содержит логин (в том числе задом наперёд) или отличается от него на несколько правок
+func similarToLogin(login, password string) bool {
+	l := normalizeForCompare(login)
+	p := normalizeForCompare(password)
+	if len(l) < 3 || len(p) == 0 {
+		return false
+	}
+	if strings.Contains(p, l) || strings.Contains(p, reverse(l)) || strings.Contains(l, p) {
+		return true
+	}
+	return levenshtein(l, p) <= len([]rune(p))/3
+}
+
+func normalizeForCompare(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
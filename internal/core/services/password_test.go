@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/stretchr/testify/require"
+)
+
+func rejectionCodes(t *testing.T, err error) []domain.PasswordRejectCode {
+	var policyErr *ports.PasswordPolicyError
+	require.True(t, errors.As(err, &policyErr))
+	codes := make([]domain.PasswordRejectCode, 0, len(policyErr.Rejections))
+	for _, r := range policyErr.Rejections {
+		codes = append(codes, r.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := newPasswordPolicy(8, 80, []string{"password", "Dragon"})
+
+	require.NoError(t, policy.validate("svirex", "Gopher-Mart-Loyal-42"))
+
+	tests := []struct {
+		name     string
+		password string
+		want     []domain.PasswordRejectCode
+	}{
+		{"short", "aB3$", []domain.PasswordRejectCode{domain.PasswordTooShort, domain.PasswordLowEntropy}},
+		{"low entropy", "abcdefghijkl", []domain.PasswordRejectCode{domain.PasswordLowEntropy}},
+		{"repeats", "aaaaaaaaaaaaaaaaaaaaaaaa", []domain.PasswordRejectCode{domain.PasswordLowEntropy}},
+		{"blocked", "PASSWORD", []domain.PasswordRejectCode{domain.PasswordLowEntropy, domain.PasswordCommon}},
+		{"blocked with suffix", "dragon2024!", []domain.PasswordRejectCode{domain.PasswordLowEntropy, domain.PasswordCommon}},
+		{"contains login", "Svirex-Gopher-Mart-42", []domain.PasswordRejectCode{domain.PasswordSimilarToLogin}},
+		{"reversed login", "Xeriv-S-Gopher-Mart-42", []domain.PasswordRejectCode{domain.PasswordSimilarToLogin}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.validate("svirex", tt.password)
+			require.ElementsMatch(t, tt.want, rejectionCodes(t, err))
+		})
+	}
+}
+
+func TestPasswordPolicyErrorIs(t *testing.T) {
+	policy := newPasswordPolicy(8, 80, nil)
+
+	err := policy.validate("svirex", "abc")
+	require.ErrorIs(t, err, ports.ErrPasswordTooShort)
+	require.ErrorIs(t, err, ports.ErrLowPasswordStrength)
+	require.NotErrorIs(t, err, ports.ErrPasswordTooLong)
+}
+
+func TestSimilarToLogin(t *testing.T) {
+	require.True(t, similarToLogin("svirex", "svirex1"))
+	require.True(t, similarToLogin("svirex", "Sv1rex"))
+	require.True(t, similarToLogin("gopher-mart", "gophermart"))
+	require.False(t, similarToLogin("svirex", "Gopher-Mart-Loyal-42"))
+	// слишком короткий логин не сравнивается
+	require.False(t, similarToLogin("ab", "abcdef"))
+}
+
+func TestLoadPasswordBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# top passwords\n123456\n\n  qwerty  \n"), 0600))
+
+	passwords, err := LoadPasswordBlocklist(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"123456", "qwerty"}, passwords)
+
+	_, err = LoadPasswordBlocklist(filepath.Join(t.TempDir(), "missing.txt"))
+	require.Error(t, err)
+}
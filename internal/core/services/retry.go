@@ -0,0 +1,55 @@
+package services
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+)
+
+// RetryPolicy - экспоненциальная задержка между попытками проверки заказа.
+// Задержка n-й попытки равна BaseDelay*Multiplier^(n-1), но не больше MaxDelay,
+// и случайно отклоняется на долю Jitter в обе стороны.
+type RetryPolicy struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+	Jitter     float64
+	// MaxAttempts и MaxAge ограничивают число попыток и время жизни задачи, 0 - без ограничения
+	MaxAttempts int
+	MaxAge      time.Duration
+}
+
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	return p.delay(attempt, rand.Float64())
+}
+
+func (p RetryPolicy) delay(attempt int, random float64) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	delay += delay * p.Jitter * (2*random - 1)
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
+func (p RetryPolicy) Exhausted(job domain.AccrualJob, now time.Time) bool {
+	if p.MaxAttempts > 0 && job.Attempts >= p.MaxAttempts {
+		return true
+	}
+	if p.MaxAge > 0 && now.Sub(job.CreatedAt) >= p.MaxAge {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{
+		BaseDelay:  time.Second,
+		MaxDelay:   10 * time.Second,
+		Multiplier: 2,
+		Jitter:     0.5,
+	}
+	cases := []struct {
+		attempt  int
+		random   float64
+		expected time.Duration
+	}{
+		{attempt: 0, random: 0.5, expected: time.Second},
+		{attempt: 1, random: 0.5, expected: time.Second},
+		{attempt: 2, random: 0.5, expected: 2 * time.Second},
+		{attempt: 3, random: 0.5, expected: 4 * time.Second},
+		{attempt: 10, random: 0.5, expected: 10 * time.Second},
+		{attempt: 2, random: 0, expected: time.Second},
+		{attempt: 2, random: 1, expected: 3 * time.Second},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, policy.delay(c.attempt, c.random))
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	now := time.Now()
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		MaxAge:      time.Hour,
+	}
+	require.False(t, policy.Exhausted(domain.AccrualJob{Attempts: 2, CreatedAt: now}, now))
+	require.True(t, policy.Exhausted(domain.AccrualJob{Attempts: 3, CreatedAt: now}, now))
+	require.True(t, policy.Exhausted(domain.AccrualJob{Attempts: 1, CreatedAt: now.Add(-2 * time.Hour)}, now))
+
+	require.False(t, RetryPolicy{}.Exhausted(domain.AccrualJob{Attempts: 1000, CreatedAt: now.Add(-1000 * time.Hour)}, now))
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+// при переполнении кеша сначала выбрасываются устаревшие записи, затем весь кеш
+const maxRevocationCacheEntries = 100000
+
+type cacheEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+type ttlCache[K comparable, T any] struct {
+	entries map[K]cacheEntry[T]
+}
+
+func newTTLCache[K comparable, T any]() ttlCache[K, T] {
+	return ttlCache[K, T]{entries: make(map[K]cacheEntry[T])}
+}
+
+func (c *ttlCache[K, T]) get(key K, now time.Time) (T, bool) {
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (c *ttlCache[K, T]) set(key K, value T, expiresAt time.Time, now time.Time) {
+	if len(c.entries) >= maxRevocationCacheEntries {
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxRevocationCacheEntries {
+			c.entries = make(map[K]cacheEntry[T])
+		}
+	}
+	c.entries[key] = cacheEntry[T]{value: value, expiresAt: expiresAt}
+}
+
+// revocationCache проверяет отзыв токенов и сессий через Postgres, запоминая ответы на ttl.
+// Отзыв, сделанный этим экземпляром, виден сразу, сделанный другим - не позже чем через ttl.
+// Отозванный токен или сессия остаются отозванными навсегда, такие ответы кешируются до истечения токена.
+type revocationCache struct {
+	revocations ports.RevocationRepository
+	sessions    ports.SessionRepository
+	ttl         time.Duration
+	now         func() time.Time
+
+	mu               sync.Mutex
+	revokedTokens    ttlCache[string, bool]
+	activeSessions   ttlCache[string, bool]
+	tokensValidAfter ttlCache[int64, time.Time]
+}
+
+func newRevocationCache(revocations ports.RevocationRepository, sessions ports.SessionRepository, ttl time.Duration, now func() time.Time) *revocationCache {
+	return &revocationCache{
+		revocations:      revocations,
+		sessions:         sessions,
+		ttl:              ttl,
+		now:              now,
+		revokedTokens:    newTTLCache[string, bool](),
+		activeSessions:   newTTLCache[string, bool](),
+		tokensValidAfter: newTTLCache[int64, time.Time](),
+	}
+}
+
+func (c *revocationCache) isTokenRevoked(ctx context.Context, tokenID string, tokenExpiresAt time.Time) (bool, error) {
+	now := c.now()
+	c.mu.Lock()
+	revoked, ok := c.revokedTokens.get(tokenID, now)
+	c.mu.Unlock()
+	if ok {
+		return revoked, nil
+	}
+	revoked, err := c.revocations.IsTokenRevoked(ctx, tokenID)
+	if err != nil {
+		return false, fmt.Errorf("revocation cache, is token revoked: %w", err)
+	}
+	c.mu.Lock()
+	c.revokedTokens.set(tokenID, revoked, c.expiresAt(revoked, tokenExpiresAt, now), now)
+	c.mu.Unlock()
+	return revoked, nil
+}
+
+func (c *revocationCache) isSessionActive(ctx context.Context, sessionID string, tokenExpiresAt time.Time) (bool, error) {
+	now := c.now()
+	c.mu.Lock()
+	active, ok := c.activeSessions.get(sessionID, now)
+	c.mu.Unlock()
+	if ok {
+		return active, nil
+	}
+	active, err := c.sessions.IsSessionActive(ctx, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("revocation cache, is session active: %w", err)
+	}
+	c.mu.Lock()
+	c.activeSessions.set(sessionID, active, c.expiresAt(!active, tokenExpiresAt, now), now)
+	c.mu.Unlock()
+	return active, nil
+}
+
+func (c *revocationCache) getTokensValidAfter(ctx context.Context, uid int64) (time.Time, error) {
+	now := c.now()
+	c.mu.Lock()
+	validAfter, ok := c.tokensValidAfter.get(uid, now)
+	c.mu.Unlock()
+	if ok {
+		return validAfter, nil
+	}
+	validAfter, err := c.revocations.TokensValidAfter(ctx, uid)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("revocation cache, tokens valid after: %w", err)
+	}
+	c.mu.Lock()
+	c.tokensValidAfter.set(uid, validAfter, now.Add(c.ttl), now)
+	c.mu.Unlock()
+	return validAfter, nil
+}
+
+func (c *revocationCache) revokeToken(ctx context.Context, tokenID string, sessionID string, tokenExpiresAt time.Time) error {
+	err := c.revocations.RevokeToken(ctx, tokenID, tokenExpiresAt)
+	if err != nil {
+		return fmt.Errorf("revocation cache, revoke token: %w", err)
+	}
+	now := c.now()
+	c.mu.Lock()
+	c.revokedTokens.set(tokenID, true, tokenExpiresAt, now)
+	if sessionID != "" {
+		c.activeSessions.set(sessionID, false, tokenExpiresAt, now)
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *revocationCache) revokeUserTokens(ctx context.Context, uid int64, validAfter time.Time) error {
+	err := c.revocations.RevokeUserTokens(ctx, uid, validAfter)
+	if err != nil {
+		return fmt.Errorf("revocation cache, revoke user tokens: %w", err)
+	}
+	now := c.now()
+	c.mu.Lock()
+	c.tokensValidAfter.set(uid, validAfter, now.Add(c.ttl), now)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *revocationCache) expiresAt(sticky bool, tokenExpiresAt time.Time, now time.Time) time.Time {
+	if sticky {
+		return tokenExpiresAt
+	}
+	return now.Add(c.ttl)
+}
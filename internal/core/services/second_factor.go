@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+)
+
+const (
+	defaultTOTPIssuer = "Gophermart"
+	// mfaTokenTTL - сколько живёт частичная сессия между паролем и кодом
+	mfaTokenTTL = 5 * time.Minute
+)
+
+func (s *AuthService) EnrollTOTP(ctx context.Context, principal *domain.Principal, password string) (*domain.TOTPEnrollment, error) {
+	user, err := s.repo.GetUserByID(ctx, principal.UID)
+	if err != nil {
+		return nil, fmt.Errorf("auth service enroll totp: %w", err)
+	}
+	// одного access токена мало: с украденным токеном можно было бы привязать свой аутентификатор
+	if err = s.verifyPassword(ctx, user, password); err != nil {
+		return nil, fmt.Errorf("auth service enroll totp: %w", err)
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("auth service enroll totp: %w", err)
+	}
+	if err = s.secondFactor.SaveTOTPSecret(ctx, user.ID, secret); err != nil {
+		return nil, fmt.Errorf("auth service enroll totp: %w", err)
+	}
+	return &domain.TOTPEnrollment{
+		Secret: secret,
+		URL:    totpURL(s.totpIssuer, user.Login, secret),
+	}, nil
+}
+
+func (s *AuthService) ConfirmTOTP(ctx context.Context, principal *domain.Principal, code string) ([]string, error) {
+	user, err := s.repo.GetUserByID(ctx, principal.UID)
+	if err != nil {
+		return nil, fmt.Errorf("auth service confirm totp: %w", err)
+	}
+	totp, err := s.secondFactor.GetTOTP(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("auth service confirm totp: %w", err)
+	}
+	if totp.Enabled {
+		return nil, fmt.Errorf("auth service confirm totp: %w", ports.ErrSecondFactorAlreadyEnabled)
+	}
+	attempt, err := s.throttle.begin(ctx, user.Login, "")
+	if err != nil {
+		return nil, fmt.Errorf("auth service confirm totp: %w", err)
+	}
+	step, ok, err := matchTOTP(totp.Secret, code, s.now())
+	if err != nil {
+		return nil, fmt.Errorf("auth service confirm totp: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: auth service confirm totp", ports.ErrInvalidSecondFactor)
+	}
+	if err = s.throttle.forgive(ctx, attempt); err != nil {
+		return nil, fmt.Errorf("auth service confirm totp: %w", err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("auth service confirm totp: %w", err)
+	}
+	if err = s.secondFactor.EnableTOTP(ctx, user.ID, totp.Secret, step, hashes); err != nil {
+		return nil, fmt.Errorf("auth service confirm totp: %w", err)
+	}
+	return codes, nil
+}
+
+func (s *AuthService) DisableTOTP(ctx context.Context, principal *domain.Principal, password, code string) error {
+	user, err := s.repo.GetUserByID(ctx, principal.UID)
+	if err != nil {
+		return fmt.Errorf("auth service disable totp: %w", err)
+	}
+	totp, err := s.enabledTOTP(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("auth service disable totp: %w", err)
+	}
+	if totp == nil {
+		return fmt.Errorf("auth service disable totp: %w", ports.ErrSecondFactorNotEnabled)
+	}
+	if err = s.verifyPassword(ctx, user, password); err != nil {
+		return fmt.Errorf("auth service disable totp: %w", err)
+	}
+	if err = s.verifySecondFactor(ctx, user, totp, code, ""); err != nil {
+		return fmt.Errorf("auth service disable totp: %w", err)
+	}
+	if err = s.secondFactor.DisableTOTP(ctx, user.ID); err != nil {
+		return fmt.Errorf("auth service disable totp: %w", err)
+	}
+	return nil
+}
+
+func (s *AuthService) LoginSecondFactor(ctx context.Context, mfaToken, code, clientIP string) (*domain.TokenPair, error) {
+	claims, err := parseMFAToken(s.keys, mfaToken, s.now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: auth service login second factor: %v", ports.ErrInvalidToken, err)
+	}
+	user, err := s.repo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, ports.ErrUserNotFound) {
+			return nil, fmt.Errorf("%w: auth service login second factor: %v", ports.ErrInvalidToken, err)
+		}
+		return nil, fmt.Errorf("auth service login second factor: %w", err)
+	}
+	totp, err := s.enabledTOTP(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("auth service login second factor: %w", err)
+	}
+	// второй фактор выключили, пока жила частичная сессия: пароль нужно ввести заново
+	if totp == nil {
+		return nil, fmt.Errorf("%w: auth service login second factor, second factor disabled", ports.ErrInvalidToken)
+	}
+	if err = s.verifySecondFactor(ctx, user, totp, code, clientIP); err != nil {
+		return nil, fmt.Errorf("auth service login second factor: %w", err)
+	}
+	if err = s.throttle.reset(ctx, user.Login); err != nil {
+		return nil, fmt.Errorf("auth service login second factor: %w", err)
+	}
+	tokens, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("auth service login second factor: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *AuthService) RequireSecondFactor(ctx context.Context, uid int64, code string) error {
+	totp, err := s.enabledTOTP(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("auth service require second factor: %w", err)
+	}
+	if totp == nil {
+		return nil
+	}
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("auth service require second factor: %w", err)
+	}
+	if err = s.verifySecondFactor(ctx, user, totp, code, ""); err != nil {
+		return fmt.Errorf("auth service require second factor: %w", err)
+	}
+	return nil
+}
+
+// enabledTOTP возвращает nil без ошибки, если второй фактор не включён или секрет ещё не подтверждён
+func (s *AuthService) enabledTOTP(ctx context.Context, uid int64) (*domain.TOTP, error) {
+	totp, err := s.secondFactor.GetTOTP(ctx, uid)
+	if err != nil {
+		if errors.Is(err, ports.ErrSecondFactorNotEnabled) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("enabled totp: %w", err)
+	}
+	if !totp.Enabled {
+		return nil, nil
+	}
+	return totp, nil
+}
+
+// verifySecondFactor принимает код TOTP или код восстановления. Ошибки учитываются как неудачные
+// попытки входа, иначе шесть цифр перебирались бы за минуты.
+func (s *AuthService) verifySecondFactor(ctx context.Context, user *domain.User, totp *domain.TOTP, code, clientIP string) error {
+	if code == "" {
+		return fmt.Errorf("verify second factor: %w", ports.ErrSecondFactorRequired)
+	}
+	attempt, err := s.throttle.begin(ctx, user.Login, clientIP)
+	if err != nil {
+		return fmt.Errorf("verify second factor: %w", err)
+	}
+	ok, err := s.useSecondFactorCode(ctx, totp, code)
+	if err != nil {
+		return fmt.Errorf("verify second factor: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: verify second factor", ports.ErrInvalidSecondFactor)
+	}
+	if err = s.throttle.forgive(ctx, attempt); err != nil {
+		return fmt.Errorf("verify second factor: %w", err)
+	}
+	return nil
+}
+
+// useSecondFactorCode отмечает код использованным, повторно тот же код не принимается
+func (s *AuthService) useSecondFactorCode(ctx context.Context, totp *domain.TOTP, code string) (bool, error) {
+	if !isTOTPCode(code) {
+		return s.secondFactor.UseRecoveryCode(ctx, totp.UID, hashRecoveryCode(code))
+	}
+	step, ok, err := matchTOTP(totp.Secret, code, s.now())
+	if err != nil || !ok {
+		return false, err
+	}
+	return s.secondFactor.UseTOTPStep(ctx, totp.UID, step)
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"encoding/base32"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSecondFactorRepository - второй фактор в памяти, повторяет поведение postgres.SecondFactorRepository
+type fakeSecondFactorRepository struct {
+	mu            sync.Mutex
+	totp          map[int64]*domain.TOTP
+	recoveryCodes map[int64]map[string]bool
+}
+
+var _ ports.SecondFactorRepository = (*fakeSecondFactorRepository)(nil)
+
+func newFakeSecondFactorRepository() *fakeSecondFactorRepository {
+	return &fakeSecondFactorRepository{
+		totp:          make(map[int64]*domain.TOTP),
+		recoveryCodes: make(map[int64]map[string]bool),
+	}
+}
+
+func (repo *fakeSecondFactorRepository) SaveTOTPSecret(ctx context.Context, uid int64, secret string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if t, ok := repo.totp[uid]; ok && t.Enabled {
+		return ports.ErrSecondFactorAlreadyEnabled
+	}
+	repo.totp[uid] = &domain.TOTP{UID: uid, Secret: secret}
+	return nil
+}
+
+func (repo *fakeSecondFactorRepository) GetTOTP(ctx context.Context, uid int64) (*domain.TOTP, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	t, ok := repo.totp[uid]
+	if !ok {
+		return nil, ports.ErrSecondFactorNotEnabled
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (repo *fakeSecondFactorRepository) EnableTOTP(ctx context.Context, uid int64, secret string, step int64, recoveryCodeHashes []string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	t, ok := repo.totp[uid]
+	if !ok || t.Enabled || t.Secret != secret {
+		return ports.ErrInvalidSecondFactor
+	}
+	t.Enabled, t.LastUsedStep = true, step
+	repo.recoveryCodes[uid] = make(map[string]bool)
+	for _, hash := range recoveryCodeHashes {
+		repo.recoveryCodes[uid][hash] = false
+	}
+	return nil
+}
+
+func (repo *fakeSecondFactorRepository) UseTOTPStep(ctx context.Context, uid int64, step int64) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	t, ok := repo.totp[uid]
+	if !ok || !t.Enabled || t.LastUsedStep >= step {
+		return false, nil
+	}
+	t.LastUsedStep = step
+	return true, nil
+}
+
+func (repo *fakeSecondFactorRepository) UseRecoveryCode(ctx context.Context, uid int64, codeHash string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	used, ok := repo.recoveryCodes[uid][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	repo.recoveryCodes[uid][codeHash] = true
+	return true, nil
+}
+
+func (repo *fakeSecondFactorRepository) DisableTOTP(ctx context.Context, uid int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	delete(repo.totp, uid)
+	delete(repo.recoveryCodes, uid)
+	return nil
+}
+
+func testTOTPCode(t *testing.T, secret string, now time.Time) string {
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	return hotpCode(key, totpStep(now))
+}
+
+// TestTOTPCode - векторы из приложения B RFC 6238, последние 6 цифр 8-значных кодов
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.code, testTOTPCode(t, secret, time.Unix(test.unix, 0)))
+	}
+
+	now := time.Unix(1111111109, 0)
+	for _, skew := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+		step, ok, err := matchTOTP(secret, testTOTPCode(t, secret, now.Add(skew)), now)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, totpStep(now.Add(skew)), step)
+	}
+	_, ok, err := matchTOTP(secret, testTOTPCode(t, secret, now.Add(time.Minute)), now)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestTOTPURL(t *testing.T) {
+	url := totpURL("Gophermart", "svirex", "JBSWY3DPEHPK3PXP")
+	require.Equal(t, "otpauth://totp/Gophermart:svirex?algorithm=SHA1&digits=6&issuer=Gophermart&period=30&secret=JBSWY3DPEHPK3PXP", url)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodesCount)
+	require.Len(t, hashes, recoveryCodesCount)
+	require.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`, codes[0])
+	require.Equal(t, hashes[0], hashRecoveryCode(codes[0]))
+	require.False(t, isTOTPCode(codes[0]))
+}
+
+func enableTestTOTP(t *testing.T, service *AuthService, clock *fakeClock, principal *domain.Principal) (string, []string) {
+	ctx := context.Background()
+	enrollment, err := service.EnrollTOTP(ctx, principal, "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	_, err = service.ConfirmTOTP(ctx, principal, "000000")
+	require.ErrorIs(t, err, ports.ErrInvalidSecondFactor)
+	codes, err := service.ConfirmTOTP(ctx, principal, testTOTPCode(t, enrollment.Secret, clock.Now()))
+	require.NoError(t, err)
+	return enrollment.Secret, codes
+}
+
+func TestSecondFactorLogin(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	service := newLoginThrottleTestService(t, clock)
+	ctx := context.Background()
+
+	tokens, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	principal, err := service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+	secret, recoveryCodes := enableTestTOTP(t, service, clock, principal)
+
+	_, err = service.EnrollTOTP(ctx, principal, "Gopher-Mart-Loyal-42")
+	require.ErrorIs(t, err, ports.ErrSecondFactorAlreadyEnabled)
+
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	var mfaErr *ports.MFARequiredError
+	require.ErrorAs(t, err, &mfaErr)
+	require.ErrorIs(t, err, ports.ErrSecondFactorRequired)
+
+	// частичная сессия не заменяет access токен
+	_, err = service.Authenticate(ctx, mfaErr.Token)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	_, err = service.LoginSecondFactor(ctx, tokens.AccessToken, testTOTPCode(t, secret, clock.Now()), "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+
+	// код, которым подтверждён секрет, уже использован
+	_, err = service.LoginSecondFactor(ctx, mfaErr.Token, testTOTPCode(t, secret, clock.Now()), "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrInvalidSecondFactor)
+
+	clock.Advance(totpPeriod * time.Second)
+	code := testTOTPCode(t, secret, clock.Now())
+	tokens, err = service.LoginSecondFactor(ctx, mfaErr.Token, code, "10.0.0.1")
+	require.NoError(t, err)
+	_, err = service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+	_, err = service.LoginSecondFactor(ctx, mfaErr.Token, code, "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrInvalidSecondFactor)
+
+	// код восстановления одноразовый, регистр и дефисы не важны
+	_, err = service.LoginSecondFactor(ctx, mfaErr.Token, recoveryCodes[0], "10.0.0.1")
+	require.NoError(t, err)
+	_, err = service.LoginSecondFactor(ctx, mfaErr.Token, recoveryCodes[0], "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrInvalidSecondFactor)
+	_, err = service.LoginSecondFactor(ctx, mfaErr.Token, " "+strings.ToUpper(recoveryCodes[1][:4]+recoveryCodes[1][5:]), "10.0.0.1")
+	require.NoError(t, err)
+
+	clock.Advance(mfaTokenTTL)
+	_, err = service.LoginSecondFactor(ctx, mfaErr.Token, recoveryCodes[2], "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	service := newLoginThrottleTestService(t, clock)
+	ctx := context.Background()
+
+	tokens, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	principal, err := service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+	secret, _ := enableTestTOTP(t, service, clock, principal)
+	clock.Advance(totpPeriod * time.Second)
+
+	// неверные коды считаются вместе с неверными паролями, верный пароль счётчик не сбрасывает.
+	// Одна ошибка уже была при подтверждении секрета.
+	var mfaErr *ports.MFARequiredError
+	for i := 0; i < 3; i++ {
+		_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+		require.ErrorAs(t, err, &mfaErr)
+		_, err = service.LoginSecondFactor(ctx, mfaErr.Token, "000000", "10.0.0.1")
+		require.ErrorIs(t, err, ports.ErrInvalidSecondFactor)
+	}
+	_, err = service.LoginSecondFactor(ctx, mfaErr.Token, testTOTPCode(t, secret, clock.Now()), "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrTooManyLoginAttempts)
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.ErrorIs(t, err, ports.ErrTooManyLoginAttempts)
+
+	clock.Advance(time.Second)
+	_, err = service.LoginSecondFactor(ctx, mfaErr.Token, testTOTPCode(t, secret, clock.Now()), "10.0.0.1")
+	require.NoError(t, err)
+}
+
+func TestEnrollTOTPRequiresPassword(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	service := newLoginThrottleTestService(t, clock)
+	ctx := context.Background()
+
+	tokens, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	principal, err := service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+
+	_, err = service.EnrollTOTP(ctx, principal, "")
+	require.ErrorIs(t, err, ports.ErrEmptyPassword)
+	_, err = service.EnrollTOTP(ctx, principal, "Gopher-Mart-Loyal-43")
+	require.ErrorIs(t, err, ports.ErrInvalidPassword)
+	_, err = service.secondFactor.GetTOTP(ctx, principal.UID)
+	require.ErrorIs(t, err, ports.ErrSecondFactorNotEnabled)
+
+	enrollment, err := service.EnrollTOTP(ctx, principal, "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	require.NotEmpty(t, enrollment.Secret)
+}
+
+func TestRequireAndDisableSecondFactor(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	service := newLoginThrottleTestService(t, clock)
+	ctx := context.Background()
+
+	tokens, err := service.Register(ctx, "svirex", "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	principal, err := service.Authenticate(ctx, tokens.AccessToken)
+	require.NoError(t, err)
+
+	// без второго фактора код не нужен
+	require.NoError(t, service.RequireSecondFactor(ctx, principal.UID, ""))
+	// неподтверждённый секрет не включает второй фактор
+	_, err = service.EnrollTOTP(ctx, principal, "Gopher-Mart-Loyal-42")
+	require.NoError(t, err)
+	require.NoError(t, service.RequireSecondFactor(ctx, principal.UID, ""))
+	err = service.DisableTOTP(ctx, principal, "Gopher-Mart-Loyal-42", "000000")
+	require.ErrorIs(t, err, ports.ErrSecondFactorNotEnabled)
+
+	secret, _ := enableTestTOTP(t, service, clock, principal)
+	clock.Advance(totpPeriod * time.Second)
+
+	require.ErrorIs(t, service.RequireSecondFactor(ctx, principal.UID, ""), ports.ErrSecondFactorRequired)
+	require.ErrorIs(t, service.RequireSecondFactor(ctx, principal.UID, "000000"), ports.ErrInvalidSecondFactor)
+	require.NoError(t, service.RequireSecondFactor(ctx, principal.UID, testTOTPCode(t, secret, clock.Now())))
+
+	clock.Advance(totpPeriod * time.Second)
+	code := testTOTPCode(t, secret, clock.Now())
+	err = service.DisableTOTP(ctx, principal, "Gopher-Mart-Loyal-43", code)
+	require.ErrorIs(t, err, ports.ErrInvalidPassword)
+	err = service.DisableTOTP(ctx, principal, "Gopher-Mart-Loyal-42", code)
+	require.NoError(t, err)
+
+	require.NoError(t, service.RequireSecondFactor(ctx, principal.UID, ""))
+	_, err = service.Login(ctx, "svirex", "Gopher-Mart-Loyal-42", "10.0.0.1")
+	require.NoError(t, err)
+}
@@ -6,11 +6,12 @@ package services
 import (
 	"context"
 	"fmt"
-	"math"
+	"net/http"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/Svirex/gofermart-loyality/internal/adapters/accrual"
 	"github.com/Svirex/gofermart-loyality/internal/adapters/postgres"
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
@@ -21,7 +22,18 @@ import (
 
 func NewAuthIntegrationTestService(t *testing.T) *AuthService {
 	repo := postgres.NewAuthRepository(testdb.GetPool())
-	service, err := NewAuthService(repo, 80, 8, 10, "fake_secret")
+	service, err := NewAuthService(repo, postgres.NewSessionRepository(testdb.GetPool(), testdb.GetLogger()),
+		postgres.NewRevocationRepository(testdb.GetPool(), testdb.GetLogger()),
+		postgres.NewLoginThrottleRepository(testdb.GetPool(), testdb.GetLogger()),
+		postgres.NewSecondFactorRepository(testdb.GetPool(), testdb.GetLogger()), AuthConfig{
+			MinPasswordEntropyBits: 80,
+			MinPasswordLength:      8,
+			BcryptCost:             10,
+			JWTSecretKey:           "fake_secret",
+			AccessTokenTTL:         15 * time.Minute,
+			RefreshTokenTTL:        time.Hour,
+			RevocationCacheTTL:     time.Second,
+		})
 	require.NoError(t, err)
 	return service
 }
@@ -42,8 +54,8 @@ func TestUserAlreadyExists(t *testing.T) {
 	_, err := service.Register(context.Background(), "svirex", "SuperPuperPassword")
 	require.NoError(t, err)
 
-	key, err := service.Register(context.Background(), "svirex", "SuperPuperPassword")
-	require.Empty(t, key)
+	tokens, err := service.Register(context.Background(), "svirex", "SuperPuperPassword")
+	require.Nil(t, tokens)
 	require.ErrorIs(t, err, ports.ErrUserAlreadyExists)
 
 	err = testdb.Truncate()
@@ -53,13 +65,66 @@ func TestUserAlreadyExists(t *testing.T) {
 func TestGoodRegister(t *testing.T) {
 	service := NewAuthIntegrationTestService(t)
 
-	key, err := service.Register(context.Background(), "svirex", "SuperPuperPassword")
-	require.NotEmpty(t, key)
+	tokens, err := service.Register(context.Background(), "svirex", "SuperPuperPassword")
 	require.NoError(t, err)
+	require.NotEmpty(t, tokens.AccessToken)
+	require.NotEmpty(t, tokens.RefreshToken)
 
-	uid, err := getUserIDFromJWT("fake_secret", key)
-	require.NotEqual(t, int64(-1), uid)
+	principal, err := service.Authenticate(context.Background(), tokens.AccessToken)
 	require.NoError(t, err)
+	require.NotEqual(t, int64(-1), principal.UID)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	service := NewAuthIntegrationTestService(t)
+
+	tokens, err := service.Register(context.Background(), "svirex", "SuperPuperPassword")
+	require.NoError(t, err)
+
+	refreshed, err := service.Refresh(context.Background(), tokens.RefreshToken)
+	require.NoError(t, err)
+
+	// повторное использование заменённого refresh токена отзывает сессию
+	_, err = service.Refresh(context.Background(), tokens.RefreshToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	_, err = service.Authenticate(context.Background(), refreshed.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+
+	tokens, err = service.Login(context.Background(), "svirex", "SuperPuperPassword", "127.0.0.1")
+	require.NoError(t, err)
+	principal, err := service.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+
+	err = service.Logout(context.Background(), principal)
+	require.NoError(t, err)
+	_, err = service.Authenticate(context.Background(), tokens.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+
+	err = testdb.Truncate()
+	require.NoError(t, err)
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	service := NewAuthIntegrationTestService(t)
+
+	tokens, err := service.Register(context.Background(), "svirex", "SuperPuperPassword")
+	require.NoError(t, err)
+
+	principal, err := service.Authenticate(context.Background(), tokens.AccessToken)
+	require.NoError(t, err)
+	err = service.RevokeUserSessions(context.Background(), principal.UID)
+	require.NoError(t, err)
+
+	_, err = service.Authenticate(context.Background(), tokens.AccessToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+	_, err = service.Refresh(context.Background(), tokens.RefreshToken)
+	require.ErrorIs(t, err, ports.ErrInvalidToken)
+
+	err = service.RevokeUserSessions(context.Background(), principal.UID+1)
+	require.ErrorIs(t, err, ports.ErrUserNotFound)
 
 	err = testdb.Truncate()
 	require.NoError(t, err)
@@ -67,7 +132,19 @@ func TestGoodRegister(t *testing.T) {
 
 func NewOrdersTestService(t *testing.T) *OrderService {
 	repo := postgres.NewOrdersRepository(testdb.GetPool(), testdb.GetLogger())
-	service, err := NewOrderService(testdb.GetPool(), repo, testdb.GetLogger(), 20, "http://mock_accrual:3000", 1*time.Second, 20, 2*time.Second)
+	service, err := NewOrderService(repo, postgres.NewAccrualRepository(testdb.GetPool(), testdb.GetLogger()),
+		accrual.NewClient("http://mock_accrual:3000", http.DefaultClient, accrual.NewLimiter(60, 1)), testdb.GetLogger(), CheckAccrualConfig{
+			InstanceID:    "test",
+			Workers:       4,
+			PollInterval:  2 * time.Second,
+			BatchSize:     20,
+			LeaseDuration: 30 * time.Second,
+			Retry: RetryPolicy{
+				BaseDelay:  time.Second,
+				MaxDelay:   time.Second,
+				Multiplier: 1,
+			},
+		})
 	require.NoError(t, err)
 	return service
 }
@@ -184,8 +261,9 @@ func TestCreateOrderAddedByAnotherUser(t *testing.T) {
 func TestGetOrdersNotFound(t *testing.T) {
 	service := NewOrdersTestService(t)
 
-	orders, err := service.GetOrders(context.Background(), 1)
+	page, err := service.GetOrders(context.Background(), 1, domain.OrdersQuery{})
 	require.NoError(t, err)
+	orders := page.Orders
 	require.NotNil(t, orders)
 	require.Empty(t, orders)
 
@@ -212,8 +290,9 @@ func TestGetOrdersOneOrder(t *testing.T) {
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
-	orders, err := service.GetOrders(context.Background(), user.ID)
+	page, err := service.GetOrders(context.Background(), user.ID, domain.OrdersQuery{})
 	require.NoError(t, err)
+	orders := page.Orders
 	require.NotNil(t, orders)
 	require.NotEmpty(t, orders)
 
@@ -252,8 +331,9 @@ func TestGetOrdersOrderBy(t *testing.T) {
 	require.NotNil(t, result)
 	require.NoError(t, err)
 
-	orders, err := service.GetOrders(context.Background(), user.ID)
+	page, err := service.GetOrders(context.Background(), user.ID, domain.OrdersQuery{})
 	require.NoError(t, err)
+	orders := page.Orders
 	require.NotNil(t, orders)
 	require.NotEmpty(t, orders)
 
@@ -288,8 +368,9 @@ func TestCheckAccrualInvalid(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 
-	orders, err := service.GetOrders(context.Background(), user.ID)
+	page, err := service.GetOrders(context.Background(), user.ID, domain.OrdersQuery{})
 	require.NoError(t, err)
+	orders := page.Orders
 	require.NotNil(t, orders)
 	require.NotEmpty(t, orders)
 
@@ -323,8 +404,9 @@ func TestCheckAccrualProcessing(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 
-	orders, err := service.GetOrders(context.Background(), user.ID)
+	page, err := service.GetOrders(context.Background(), user.ID, domain.OrdersQuery{})
 	require.NoError(t, err)
+	orders := page.Orders
 	require.NotNil(t, orders)
 	require.NotEmpty(t, orders)
 
@@ -358,7 +440,9 @@ func TestCheckAccrualChangePauseBetweenRequests(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 
-	require.Equal(t, 30*time.Second, service.checkAccrualService.pauseBetweenRequests)
+	stats := service.checkAccrualService.client.(*accrual.Client).Limiter().Stats()
+	require.Equal(t, float64(2), stats.PerMinute)
+	require.Equal(t, int64(1), stats.Throttled)
 
 	service.Shutdown()
 	err = testdb.Truncate()
@@ -379,19 +463,26 @@ func TestCheckAccrualDBLoader(t *testing.T) {
 
 	_, err = testdb.GetPool().Exec(context.Background(), "INSERT INTO orders (uid, order_num) VALUES ($1, $2);", user.ID, "1")
 	require.NoError(t, err)
+	_, err = testdb.GetPool().Exec(context.Background(), "INSERT INTO accrual_jobs (order_num) VALUES ($1);", "1")
+	require.NoError(t, err)
 
 	_, err = testdb.GetPool().Exec(context.Background(), "INSERT INTO orders (uid, order_num) VALUES ($1, $2);", user.ID, "2")
 	require.NoError(t, err)
+	_, err = testdb.GetPool().Exec(context.Background(), "INSERT INTO accrual_jobs (order_num) VALUES ($1);", "2")
+	require.NoError(t, err)
 
 	_, err = testdb.GetPool().Exec(context.Background(), "INSERT INTO orders (uid, order_num) VALUES ($1, $2);", user.ID, "3")
 	require.NoError(t, err)
+	_, err = testdb.GetPool().Exec(context.Background(), "INSERT INTO accrual_jobs (order_num) VALUES ($1);", "3")
+	require.NoError(t, err)
 
 	service := NewOrdersTestService(t)
 
 	time.Sleep(5 * time.Second)
 
-	orders, err := service.GetOrders(context.Background(), user.ID)
+	page, err := service.GetOrders(context.Background(), user.ID, domain.OrdersQuery{})
 	require.NoError(t, err)
+	orders := page.Orders
 	require.NotNil(t, orders)
 	require.NotEmpty(t, orders)
 
@@ -444,8 +535,8 @@ func TestCheckAccrualProcessed(t *testing.T) {
 
 	fmt.Println(balance)
 
-	require.True(t, math.Abs(500.0-balance.Current) < 1e-9)
-	require.True(t, math.Abs(0.0-balance.Withdrawn) < 1e-9)
+	require.Equal(t, domain.Money(50000), balance.Current)
+	require.Equal(t, domain.Money(0), balance.Withdrawn)
 
 	service.Shutdown()
 	err = testdb.Truncate()
@@ -478,8 +569,8 @@ func TestCheckAccrualProcessed2(t *testing.T) {
 
 	fmt.Println(balance)
 
-	require.True(t, math.Abs(729.98-balance.Current) < 1e-9)
-	require.True(t, math.Abs(0.0-balance.Withdrawn) < 1e-9)
+	require.Equal(t, domain.Money(72998), balance.Current)
+	require.Equal(t, domain.Money(0), balance.Withdrawn)
 
 	service.Shutdown()
 	err = testdb.Truncate()
@@ -506,11 +597,12 @@ func TestGetOrders(t *testing.T) {
 
 	time.Sleep(100 * time.Millisecond)
 
-	orders, err := service.GetOrders(context.Background(), user.ID)
+	page, err := service.GetOrders(context.Background(), user.ID, domain.OrdersQuery{})
 	require.NoError(t, err)
+	orders := page.Orders
 	require.Len(t, orders, 1)
 
-	require.True(t, math.Abs(729.98-orders[0].Accrual) < 1e-9)
+	require.Equal(t, domain.Money(72998), orders[0].Accrual)
 
 	service.Shutdown()
 	err = testdb.Truncate()
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTP по RFC 6238 с параметрами, которые понимают все приложения-аутентификаторы: SHA1, 6 цифр, шаг 30 секунд
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew - сколько соседних шагов принимается из-за расхождения часов
+	totpSkew           = 1
+	totpSecretSize     = 20
+	recoveryCodesCount = 10
+	recoveryCodeSize   = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("new totp secret: %w", err)
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// hotpCode - код RFC 4226 для счётчика step
+func hotpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// matchTOTP возвращает шаг, которому соответствует код. Проверяются все шаги окна,
+// чтобы время ответа не зависело от того, какой из них совпал.
+func matchTOTP(secret, code string, now time.Time) (int64, bool, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false, fmt.Errorf("match totp, decode secret: %w", err)
+	}
+	var matched int64
+	ok := false
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotpCode(key, step)), []byte(code)) == 1 {
+			matched, ok = step, true
+		}
+	}
+	return matched, ok, nil
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	_, err := strconv.ParseUint(code, 10, 32)
+	return err == nil
+}
+
+// totpURL - otpauth:// ссылка в формате Google Authenticator Key Uri
+func totpURL(issuer, login, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + login,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// newRecoveryCodes возвращает коды для пользователя и их хеши для базы. Энтропии в коде 80 бит,
+// поэтому для хранения хватает sha256, как и для refresh токенов.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		b := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("new recovery codes: %w", err)
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode не различает регистр, дефисы и пробелы, код часто вводят вручную
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	return hashRefreshToken(normalized)
+}
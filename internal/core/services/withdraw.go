@@ -3,24 +3,38 @@ package services
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/Svirex/gofermart-loyality/internal/core/domain"
 	"github.com/Svirex/gofermart-loyality/internal/core/ports"
 )
 
 type WithdrawService struct {
-	repository ports.WithdrawRepository
+	repository            ports.WithdrawRepository
+	secondFactor          ports.SecondFactorVerifier
+	cancelWindow          time.Duration
+	secondFactorThreshold domain.Money
 }
 
-func NewWithdrawService(repository ports.WithdrawRepository) *WithdrawService {
+// cancelWindow - сколько времени после списания его можно отменить, 0 - отмена выключена.
+// Списания больше secondFactorThreshold подтверждаются вторым фактором, если он включён, 0 - не подтверждаются.
+func NewWithdrawService(repository ports.WithdrawRepository, secondFactor ports.SecondFactorVerifier,
+	cancelWindow time.Duration, secondFactorThreshold domain.Money) *WithdrawService {
 	return &WithdrawService{
-		repository: repository,
+		repository:            repository,
+		secondFactor:          secondFactor,
+		cancelWindow:          cancelWindow,
+		secondFactorThreshold: secondFactorThreshold,
 	}
 }
 
 var _ ports.WithdrawService = (*WithdrawService)(nil)
 
-func (service *WithdrawService) Withdraw(ctx context.Context, uid int64, data *domain.WithdrawData) error {
+func (service *WithdrawService) Withdraw(ctx context.Context, uid int64, data *domain.WithdrawData, secondFactorCode string) error {
+	// отрицательная сумма увеличила бы баланс, а сумма меньше копейки округляется до нулевого списания
+	if data.Sum <= 0 {
+		return fmt.Errorf("%w: withdraw service, withdraw, sum %s", ports.ErrSumNotPositive, data.Sum)
+	}
 	ok, err := checkLuhn(data.OrderNum)
 	if err != nil {
 		return fmt.Errorf("%w: withdraw service, withdraw, check luhn: %v", ports.ErrInvalidOrderNum, err)
@@ -28,9 +42,35 @@ func (service *WithdrawService) Withdraw(ctx context.Context, uid int64, data *d
 	if !ok {
 		return ports.ErrInvalidOrderNum
 	}
+	if service.secondFactorThreshold > 0 && data.Sum > service.secondFactorThreshold {
+		// код одноразовый, поэтому сначала отсекаются списания, которые заведомо не пройдут.
+		// Проверка без блокировки: если параллельное списание успеет забрать баллы, код всё же будет потрачен.
+		if err = service.repository.CheckWithdraw(ctx, uid, data); err != nil {
+			return fmt.Errorf("withdraw service, withdraw: %w", err)
+		}
+		if err = service.secondFactor.RequireSecondFactor(ctx, uid, secondFactorCode); err != nil {
+			return fmt.Errorf("withdraw service, withdraw: %w", err)
+		}
+	}
 	return service.repository.Withdraw(ctx, uid, data)
 }
 
-func (service *WithdrawService) GetWithdrawals(ctx context.Context, uid int64) ([]*domain.WithdrawData, error) {
-	return service.repository.GetWithdrawals(ctx, uid)
+func (service *WithdrawService) GetWithdrawals(ctx context.Context, uid int64, query domain.WithdrawalsQuery) (*domain.WithdrawalsPage, error) {
+	query.Limit = pageFetchLimit(query.Limit)
+	withdrawals, err := service.repository.GetWithdrawals(ctx, uid, query)
+	if err != nil {
+		return nil, fmt.Errorf("withdraw service, get withdrawals: %w", err)
+	}
+	page := &domain.WithdrawalsPage{}
+	page.Withdrawals, page.Next = cutPage(withdrawals, query.Limit, func(w *domain.WithdrawData) domain.Cursor {
+		return domain.Cursor{At: w.ProcessedAt, ID: w.ID}
+	})
+	return page, nil
+}
+
+func (service *WithdrawService) Cancel(ctx context.Context, uid int64, orderNum string) error {
+	if service.cancelWindow <= 0 {
+		return fmt.Errorf("%w: withdraw service, cancel, cancellation is disabled", ports.ErrWithdrawalNotCancellable)
+	}
+	return service.repository.Cancel(ctx, uid, orderNum, service.cancelWindow)
 }
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Svirex/gofermart-loyality/internal/core/domain"
+	"github.com/Svirex/gofermart-loyality/internal/core/ports"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWithdrawRepository struct {
+	withdrawals []*domain.WithdrawData
+	// balance - сколько ещё можно списать, 0 - без ограничения
+	balance domain.Money
+}
+
+var _ ports.WithdrawRepository = (*fakeWithdrawRepository)(nil)
+
+func (repo *fakeWithdrawRepository) Withdraw(ctx context.Context, uid int64, data *domain.WithdrawData) error {
+	if err := repo.CheckWithdraw(ctx, uid, data); err != nil {
+		return err
+	}
+	if repo.balance > 0 {
+		repo.balance -= data.Sum
+	}
+	repo.withdrawals = append(repo.withdrawals, data)
+	return nil
+}
+
+func (repo *fakeWithdrawRepository) CheckWithdraw(ctx context.Context, uid int64, data *domain.WithdrawData) error {
+	if repo.balance > 0 && data.Sum > repo.balance {
+		return ports.ErrNotEnoughMoney
+	}
+	return nil
+}
+
+// GetWithdrawals учитывает только Limit, списания отдаются в порядке добавления
+func (repo *fakeWithdrawRepository) GetWithdrawals(ctx context.Context, uid int64, query domain.WithdrawalsQuery) ([]*domain.WithdrawData, error) {
+	if query.Limit > 0 && query.Limit < len(repo.withdrawals) {
+		return repo.withdrawals[:query.Limit], nil
+	}
+	return repo.withdrawals, nil
+}
+
+func (repo *fakeWithdrawRepository) Cancel(ctx context.Context, uid int64, orderNum string, window time.Duration) error {
+	return nil
+}
+
+// fakeSecondFactorVerifier принимает только код "123456"
+type fakeSecondFactorVerifier struct {
+	calls int
+}
+
+func (verifier *fakeSecondFactorVerifier) RequireSecondFactor(ctx context.Context, uid int64, code string) error {
+	verifier.calls++
+	switch code {
+	case "":
+		return ports.ErrSecondFactorRequired
+	case "123456":
+		return nil
+	default:
+		return ports.ErrInvalidSecondFactor
+	}
+}
+
+func TestWithdrawSecondFactorThreshold(t *testing.T) {
+	repo := &fakeWithdrawRepository{}
+	service := NewWithdrawService(repo, &fakeSecondFactorVerifier{}, time.Hour, 100_00)
+	ctx := context.Background()
+
+	err := service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12345678903", Sum: 100_00}, "")
+	require.NoError(t, err)
+	err = service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12345678903", Sum: 100_01}, "")
+	require.ErrorIs(t, err, ports.ErrSecondFactorRequired)
+	err = service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12345678903", Sum: 100_01}, "654321")
+	require.ErrorIs(t, err, ports.ErrInvalidSecondFactor)
+	err = service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12345678903", Sum: 100_01}, "123456")
+	require.NoError(t, err)
+	require.Len(t, repo.withdrawals, 2)
+
+	// порог 0 - подтверждение не требуется
+	service = NewWithdrawService(repo, &fakeSecondFactorVerifier{}, time.Hour, 0)
+	err = service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12345678903", Sum: 1_000_000_00}, "")
+	require.NoError(t, err)
+}
+
+func TestWithdrawSumMustBePositive(t *testing.T) {
+	repo := &fakeWithdrawRepository{}
+	service := NewWithdrawService(repo, &fakeSecondFactorVerifier{}, time.Hour, 0)
+	ctx := context.Background()
+
+	var roundsToZero domain.Money
+	require.NoError(t, json.Unmarshal([]byte("0.001"), &roundsToZero))
+	for _, sum := range []domain.Money{-100_00, 0, roundsToZero} {
+		// сумма проверяется раньше номера
+		err := service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12", Sum: sum}, "")
+		require.ErrorIs(t, err, ports.ErrSumNotPositive, sum.String())
+	}
+	require.Empty(t, repo.withdrawals)
+
+	require.NoError(t, service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12345678903", Sum: 1}, ""))
+}
+
+func TestWithdrawSecondFactorNotSpentWithoutMoney(t *testing.T) {
+	repo := &fakeWithdrawRepository{balance: 150_00}
+	verifier := &fakeSecondFactorVerifier{}
+	service := NewWithdrawService(repo, verifier, time.Hour, 100_00)
+	ctx := context.Background()
+
+	err := service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12345678903", Sum: 200_00}, "123456")
+	require.ErrorIs(t, err, ports.ErrNotEnoughMoney)
+	require.Equal(t, 0, verifier.calls)
+	require.Empty(t, repo.withdrawals)
+
+	err = service.Withdraw(ctx, 1, &domain.WithdrawData{OrderNum: "12345678903", Sum: 150_00}, "123456")
+	require.NoError(t, err)
+	require.Equal(t, 1, verifier.calls)
+}
+
+func TestGetWithdrawalsPage(t *testing.T) {
+	now := time.Now()
+	repo := &fakeWithdrawRepository{withdrawals: []*domain.WithdrawData{
+		{ID: 2, OrderNum: "2377225624", Sum: 100, ProcessedAt: now},
+		{ID: 1, OrderNum: "12345678903", Sum: 200, ProcessedAt: now.Add(-time.Minute)},
+	}}
+	service := NewWithdrawService(repo, &fakeSecondFactorVerifier{}, 0, 0)
+
+	page, err := service.GetWithdrawals(context.Background(), 1, domain.WithdrawalsQuery{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Withdrawals, 1)
+	require.Equal(t, &domain.Cursor{At: now, ID: 2}, page.Next)
+
+	page, err = service.GetWithdrawals(context.Background(), 1, domain.WithdrawalsQuery{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Withdrawals, 2)
+	require.Nil(t, page.Next)
+
+	page, err = service.GetWithdrawals(context.Background(), 1, domain.WithdrawalsQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Withdrawals, 2)
+	require.Nil(t, page.Next)
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ledger;
+
+DROP TYPE IF EXISTS POSTING_KIND;
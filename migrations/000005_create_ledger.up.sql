@@ -0,0 +1,26 @@
+CREATE TYPE POSTING_KIND AS ENUM ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT');
+
+CREATE TABLE IF NOT EXISTS ledger (
+    id SERIAL PRIMARY KEY,
+    uid INT REFERENCES users (id),
+    order_num TEXT,
+    kind POSTING_KIND NOT NULL,
+    amount NUMERIC(20, 10) NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ledger_uid_created_at_idx ON ledger (uid, created_at);
+
+INSERT INTO ledger (uid, order_num, kind, amount, created_at)
+SELECT uid, order_num, 'ACCRUAL', accrual, uploaded_at FROM orders WHERE status='PROCESSED' AND accrual > 0;
+
+INSERT INTO ledger (uid, order_num, kind, amount, created_at)
+SELECT uid, order_num, 'WITHDRAWAL', -sum, processed_at FROM withdraws;
+
+-- всё, что попало в balance мимо заказов и списаний, фиксируем корректировкой,
+-- чтобы сумма проводок совпадала с текущим балансом
+INSERT INTO ledger (uid, kind, amount)
+SELECT b.uid, 'ADJUSTMENT', b.current - COALESCE(SUM(l.amount), 0)
+FROM balance b LEFT JOIN ledger l ON l.uid = b.uid
+GROUP BY b.uid, b.current
+HAVING b.current - COALESCE(SUM(l.amount), 0) <> 0;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS outbox;
+
+DROP INDEX IF EXISTS ledger_accrual_order_num_idx;
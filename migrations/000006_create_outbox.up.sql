@@ -0,0 +1,10 @@
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_accrual_order_num_idx ON ledger (order_num) WHERE kind = 'ACCRUAL';
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (event_type, aggregate_id)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS accrual_jobs (
+    order_num TEXT PRIMARY KEY REFERENCES orders (order_num) ON DELETE CASCADE,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_by TEXT,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS accrual_jobs_next_attempt_at_idx ON accrual_jobs (next_attempt_at);
+
+INSERT INTO accrual_jobs (order_num)
+SELECT order_num FROM orders WHERE status='NEW' OR status='PROCESSING'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+-- значение из enum в postgres удалить нельзя, возвращаем такие заказы в очередь
+INSERT INTO accrual_jobs (order_num) SELECT order_num FROM orders WHERE status='FAILED_CHECK' ON CONFLICT DO NOTHING;
+
+UPDATE orders SET status='NEW' WHERE status='FAILED_CHECK';
@@ -0,0 +1 @@
+ALTER TYPE STATUS ADD VALUE IF NOT EXISTS 'FAILED_CHECK';
@@ -0,0 +1,9 @@
+ALTER TABLE ledger ALTER COLUMN amount TYPE NUMERIC(20, 10);
+
+ALTER TABLE withdraws ALTER COLUMN sum TYPE NUMERIC(20, 10);
+
+ALTER TABLE balance
+    ALTER COLUMN current TYPE NUMERIC(20, 10),
+    ALTER COLUMN withdrawn TYPE NUMERIC(20, 10);
+
+ALTER TABLE orders ALTER COLUMN accrual TYPE NUMERIC(12, 2);
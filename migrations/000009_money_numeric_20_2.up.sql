@@ -0,0 +1,10 @@
+-- все денежные колонки хранят копейки, округление как в domain.Money - половина от нуля
+ALTER TABLE orders ALTER COLUMN accrual TYPE NUMERIC(20, 2) USING ROUND(accrual, 2);
+
+ALTER TABLE balance
+    ALTER COLUMN current TYPE NUMERIC(20, 2) USING ROUND(current, 2),
+    ALTER COLUMN withdrawn TYPE NUMERIC(20, 2) USING ROUND(withdrawn, 2);
+
+ALTER TABLE withdraws ALTER COLUMN sum TYPE NUMERIC(20, 2) USING ROUND(sum, 2);
+
+ALTER TABLE ledger ALTER COLUMN amount TYPE NUMERIC(20, 2) USING ROUND(amount, 2);
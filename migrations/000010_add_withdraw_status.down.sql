@@ -0,0 +1,5 @@
+ALTER TABLE withdraws
+    DROP COLUMN IF EXISTS cancelled_at,
+    DROP COLUMN IF EXISTS status;
+
+DROP TYPE IF EXISTS WITHDRAW_STATUS;
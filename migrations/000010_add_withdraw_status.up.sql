@@ -0,0 +1,5 @@
+CREATE TYPE WITHDRAW_STATUS AS ENUM ('PROCESSED', 'CANCELLED');
+
+ALTER TABLE withdraws
+    ADD COLUMN status WITHDRAW_STATUS NOT NULL DEFAULT 'PROCESSED',
+    ADD COLUMN cancelled_at TIMESTAMP;
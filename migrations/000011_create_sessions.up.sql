@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    uid INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    refresh_hash TEXT NOT NULL UNIQUE,
+    -- хеш предыдущего refresh токена, его повторное предъявление отзывает сессию
+    previous_hash TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_previous_hash_idx ON sessions (previous_hash);
+CREATE INDEX IF NOT EXISTS sessions_uid_idx ON sessions (uid);
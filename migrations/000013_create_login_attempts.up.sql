@@ -0,0 +1,11 @@
+-- неудачные попытки входа по логину и по IP, общие для всех экземпляров
+CREATE TABLE IF NOT EXISTS login_attempts (
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    PRIMARY KEY (scope, subject)
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_last_failure_at_idx ON login_attempts (last_failure_at);
@@ -0,0 +1,2 @@
+ALTER TABLE balance DROP COLUMN IF EXISTS frozen_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
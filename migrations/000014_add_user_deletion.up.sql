@@ -0,0 +1,4 @@
+-- удалённый пользователь остаётся в базе ради истории заказов и списаний, логин обнуляется
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+-- баланс удалённого пользователя замораживается и не списывается
+ALTER TABLE balance ADD COLUMN frozen_at TIMESTAMP;
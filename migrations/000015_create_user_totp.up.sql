@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    uid INT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    -- NULL, пока пользователь не подтвердил секрет первым кодом
+    enabled_at TIMESTAMP,
+    -- шаг последнего принятого кода, защита от повторного использования кода
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- одноразовые коды восстановления, хранятся только хеши
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    uid INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    PRIMARY KEY (uid, code_hash)
+);
@@ -0,0 +1,16 @@
+CREATE TYPE USER_ROLE AS ENUM ('user', 'support', 'admin');
+
+ALTER TABLE users ADD COLUMN role USER_ROLE NOT NULL DEFAULT 'user';
+
+-- действия сотрудников, actor_uid NULL - действие по X-Admin-Token
+CREATE TABLE IF NOT EXISTS audit_log (
+    id SERIAL PRIMARY KEY,
+    actor_uid INT REFERENCES users (id),
+    action TEXT NOT NULL,
+    target_uid INT NOT NULL REFERENCES users (id),
+    reason TEXT NOT NULL DEFAULT '',
+    details JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_target_uid_idx ON audit_log (target_uid, created_at);
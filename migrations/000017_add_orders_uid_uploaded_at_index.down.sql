@@ -0,0 +1 @@
+DROP INDEX IF EXISTS orders_uid_uploaded_at_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS orders_uid_uploaded_at_idx ON orders (uid, uploaded_at DESC, id DESC);
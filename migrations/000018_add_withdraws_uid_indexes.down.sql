@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS withdraws_uid_sum_idx;
+DROP INDEX IF EXISTS withdraws_uid_processed_at_idx;
@@ -0,0 +1,3 @@
+-- список списаний пользователя от новых к старым и фильтр по сумме
+CREATE INDEX IF NOT EXISTS withdraws_uid_processed_at_idx ON withdraws (uid, processed_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS withdraws_uid_sum_idx ON withdraws (uid, sum);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_num TEXT NOT NULL REFERENCES orders (order_num) ON DELETE CASCADE,
+    status STATUS NOT NULL,
+    accrual NUMERIC(20, 2),
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_num_idx ON order_status_history (order_num, changed_at, id);
+
+-- для старых заказов известны только загрузка и текущий статус, время перехода неизвестно
+INSERT INTO order_status_history (order_num, status, changed_at)
+SELECT order_num, 'NEW', uploaded_at FROM orders;
+
+INSERT INTO order_status_history (order_num, status, accrual)
+SELECT order_num, status, CASE WHEN status='PROCESSED' THEN accrual END FROM orders WHERE status!='NEW';
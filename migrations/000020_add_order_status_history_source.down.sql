@@ -0,0 +1,5 @@
+ALTER TABLE order_status_history
+    DROP COLUMN IF EXISTS raw_response,
+    DROP COLUMN IF EXISTS source;
+
+DROP TYPE IF EXISTS STATUS_SOURCE;
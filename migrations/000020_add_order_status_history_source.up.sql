@@ -0,0 +1,6 @@
+CREATE TYPE STATUS_SOURCE AS ENUM ('user', 'poller', 'webhook', 'admin');
+
+-- у записей, перенесённых из заказов, источник неизвестен
+ALTER TABLE order_status_history
+    ADD COLUMN source STATUS_SOURCE,
+    ADD COLUMN raw_response JSONB;
@@ -45,7 +45,7 @@ func main() {
 			`, number)
 			w.Write([]byte(s))
 		} else if number == "67" {
-			time.Sleep(2 * time.Second) // чтобы checkAccrualService.dbLoader прочитал значение из БД и заново закинул такой же номер в канал
+			time.Sleep(2 * time.Second) // медленный ответ не должен задерживать проверку остальных заказов
 			s := fmt.Sprintf(`
 			{
 				"order": "%s",
@@ -123,7 +123,7 @@ func MigrateDown() {
 }
 
 func Truncate() error {
-	_, err := dbpool.Exec(context.Background(), "TRUNCATE TABLE users, orders, balance, withdraws RESTART IDENTITY;")
+	_, err := dbpool.Exec(context.Background(), "TRUNCATE TABLE users, orders, balance, withdraws, ledger, outbox, accrual_jobs, sessions, revoked_tokens, login_attempts, user_totp, recovery_codes, audit_log, order_status_history RESTART IDENTITY;")
 	if err != nil {
 		logger.Error("couldn't truncate tables ", err)
 		return err